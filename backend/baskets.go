@@ -0,0 +1,432 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// baskets (etfs) live in stocks like any other symbol so trading, portfolio and history just work,
+// their price is the value of what one unit holds and gets recomputed every time prices change
+const basketKind = "etf"
+
+type BasketComponent struct {
+	StockID string  `json:"stock_id"`
+	Units   float64 `json:"units"`            // shares of the stock behind one basket unit
+	Weight  float64 `json:"weight,omitempty"` // current share of the price, output only
+}
+
+type Basket struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Components  []BasketComponent `json:"components"`
+	Cash        float64           `json:"cash"` // per unit, left behind by delisted components
+	Price       float64           `json:"price"`
+	CreatedAt   string            `json:"created_at,omitempty"`
+}
+
+var (
+	basketLock     sync.Mutex
+	baskets        = map[string]*Basket{}
+	lastBasketTick = map[string]time.Time{}
+)
+
+// caller holds basketLock
+func (b *Basket) nav(prices map[string]float64) float64 {
+	v := b.Cash
+	for _, c := range b.Components {
+		v += c.Units * prices[c.StockID]
+	}
+	return v
+}
+
+func isBasket(id string) bool {
+	basketLock.Lock()
+	defer basketLock.Unlock()
+	_, ok := baskets[strings.ToUpper(strings.TrimSpace(id))]
+	return ok
+}
+
+// caller holds stocksLock. sets every basket's price from its components and adds chart ticks
+func repriceBaskets() {
+	prices := make(map[string]float64, len(stocks))
+	for _, s := range stocks {
+		prices[s.ID] = s.Price
+	}
+	now := time.Now()
+	basketLock.Lock()
+	defer basketLock.Unlock()
+	for i := range stocks {
+		b, ok := baskets[stocks[i].ID]
+		if !ok {
+			continue
+		}
+		prev := stocks[i].Price
+		price := b.nav(prices)
+		if price < 0.01 {
+			price = 0.01
+		}
+		b.Price = price
+		stocks[i].Price = price
+		stocks[i].Change = price - prev
+		if now.Sub(lastBasketTick[b.ID]) > 1200*time.Millisecond {
+			appendTick(b.ID, price, int64(100+rand.Intn(400)))
+			lastBasketTick[b.ID] = now
+		}
+	}
+}
+
+// a split changes the component price by f, the basket holds 1/f as many units so its price doesnt move.
+// caller holds stocksLock
+func splitBasketComponents(stockID string, f float64) []Basket {
+	basketLock.Lock()
+	defer basketLock.Unlock()
+	var changed []Basket
+	for _, b := range baskets {
+		for i := range b.Components {
+			if b.Components[i].StockID == stockID {
+				b.Components[i].Units /= f
+				changed = append(changed, *b)
+			}
+		}
+	}
+	return changed
+}
+
+// a delisted component turns into cash inside the basket at the final price
+func dropBasketComponent(stockID string, final float64) {
+	basketLock.Lock()
+	var changed []Basket
+	for _, b := range baskets {
+		kept := b.Components[:0]
+		for _, c := range b.Components {
+			if c.StockID == stockID {
+				b.Cash += c.Units * final
+				continue
+			}
+			kept = append(kept, c)
+		}
+		if len(kept) != len(b.Components) {
+			b.Components = kept
+			changed = append(changed, *b)
+		}
+	}
+	basketLock.Unlock()
+	saveBaskets(changed)
+}
+
+func saveBaskets(list []Basket) {
+	for _, b := range list {
+		comps, _ := json.Marshal(b.Components)
+		if _, err := db.Exec("UPDATE baskets SET components = ?, cash = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", string(comps), b.Cash, b.ID); err != nil {
+			log.Printf("Failed to save basket %s: %v", b.ID, err)
+		}
+	}
+}
+
+// the basket itself got delisted
+func removeBasket(id string) {
+	basketLock.Lock()
+	delete(baskets, id)
+	delete(lastBasketTick, id)
+	basketLock.Unlock()
+	_, _ = db.Exec("UPDATE baskets SET status = 'delisted', updated_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+}
+
+// what an admin posts to create a basket. either weights, or a sector (empty = whole market) plus a method
+type BasketRequest struct {
+	ID          string             `json:"id"`
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Price       float64            `json:"price"` // starting price of one unit, 100 if left out
+	Weights     map[string]float64 `json:"weights,omitempty"`
+	Sector      string             `json:"sector,omitempty"`
+	Method      string             `json:"method,omitempty"` // equal or cap, for sector/market baskets
+}
+
+// turns weights into units at today's prices
+func buildBasket(req BasketRequest) (*Basket, error) {
+	req.ID = strings.ToUpper(strings.TrimSpace(req.ID))
+	req.Name = strings.TrimSpace(req.Name)
+	if !validSymbol(req.ID) {
+		return nil, errors.New("id must be 1-8 letters/digits")
+	}
+	if req.Name == "" {
+		return nil, errors.New("name required")
+	}
+	if symbolTaken(req.ID) {
+		return nil, errors.New("symbol already in use")
+	}
+	if req.Price == 0 {
+		req.Price = 100
+	}
+	if req.Price < 1 || req.Price > 1e6 {
+		return nil, errors.New("price must be between 1 and 1000000")
+	}
+
+	stocksLock.Lock()
+	universe := make(map[string]Stock, len(stocks))
+	for _, s := range stocks {
+		if s.Kind != basketKind {
+			universe[s.ID] = s
+		}
+	}
+	stocksLock.Unlock()
+
+	weights := map[string]float64{}
+	if len(req.Weights) > 0 {
+		for id, w := range req.Weights {
+			id = strings.ToUpper(strings.TrimSpace(id))
+			if _, ok := universe[id]; !ok {
+				return nil, errors.New("unknown component " + id + " (baskets cant hold other baskets)")
+			}
+			if w <= 0 {
+				return nil, errors.New("weights must be > 0")
+			}
+			weights[id] += w
+		}
+	} else {
+		method := strings.ToLower(strings.TrimSpace(req.Method))
+		if method == "" {
+			method = "equal"
+		}
+		if method != "equal" && method != "cap" {
+			return nil, errors.New("method must be equal or cap")
+		}
+		for id, s := range universe {
+			if req.Sector != "" && !strings.EqualFold(strings.TrimSpace(s.Sector), strings.TrimSpace(req.Sector)) {
+				continue
+			}
+			weights[id] = 1
+			if method == "cap" {
+				weights[id] = s.Price * sharesOutstanding(s)
+			}
+		}
+		if len(weights) == 0 {
+			return nil, errors.New("no stocks in that sector")
+		}
+	}
+
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	b := &Basket{ID: req.ID, Name: req.Name, Description: strings.TrimSpace(req.Description), Price: req.Price}
+	for id, w := range weights {
+		b.Components = append(b.Components, BasketComponent{StockID: id, Units: w / total * req.Price / universe[id].Price})
+	}
+	sort.Slice(b.Components, func(i, j int) bool { return b.Components[i].StockID < b.Components[j].StockID })
+	return b, nil
+}
+
+// basket price history rebuilt from its components' charts, so a new basket has a chart straight away
+func basketHistory(b *Basket) ([]Tick, []RawTickEvent) {
+	tickLock.Lock()
+	defer tickLock.Unlock()
+	bars := map[int64]*Tick{}
+	counts := map[int64]int{}
+	for _, c := range b.Components {
+		for _, t := range tickBuffer[c.StockID] {
+			k := t.Time.Unix() / 60
+			bar, ok := bars[k]
+			if !ok {
+				bar = &Tick{Time: t.Time, Open: b.Cash, High: b.Cash, Low: b.Cash, Close: b.Cash}
+				bars[k] = bar
+			}
+			bar.Open += c.Units * t.Open
+			bar.High += c.Units * t.High
+			bar.Low += c.Units * t.Low
+			bar.Close += c.Units * t.Close
+			bar.Volume += t.Volume / 10
+			counts[k]++
+		}
+	}
+	keys := make([]int64, 0, len(bars))
+	for k := range bars {
+		// minutes where a component had no bar would show a fake dip
+		if counts[k] == len(b.Components) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	ticks := make([]Tick, 0, len(keys))
+	raw := make([]RawTickEvent, 0, len(keys))
+	for _, k := range keys {
+		t := *bars[k]
+		ticks = append(ticks, t)
+		raw = append(raw, RawTickEvent{Price: t.Close, Time: t.Time, Volume: t.Volume})
+	}
+	return ticks, raw
+}
+
+// puts a basket into the live universe with a backfilled chart
+func listBasket(b *Basket) {
+	stocksLock.Lock()
+	prices := make(map[string]float64, len(stocks))
+	for _, s := range stocks {
+		prices[s.ID] = s.Price
+	}
+	stocksLock.Unlock()
+	b.Price = b.nav(prices)
+
+	ticks, raw := basketHistory(b)
+	addStockToUniverse(Stock{ID: b.ID, Name: b.Name, Sector: "ETF", Price: b.Price, Kind: basketKind})
+	if len(ticks) > 0 {
+		tickLock.Lock()
+		tickBuffer[b.ID] = ticks
+		rawTickHistory[b.ID] = raw
+		tickLock.Unlock()
+	}
+
+	basketLock.Lock()
+	baskets[b.ID] = b
+	basketLock.Unlock()
+}
+
+func createBasket(req BasketRequest) (*Basket, error) {
+	b, err := buildBasket(req)
+	if err != nil {
+		return nil, err
+	}
+	comps, _ := json.Marshal(b.Components)
+	if _, err := db.Exec("INSERT INTO baskets (id, name, description, components, cash, status) VALUES (?, ?, ?, ?, 0, 'active')", b.ID, b.Name, b.Description, string(comps)); err != nil {
+		return nil, err
+	}
+	listBasket(b)
+	return b, nil
+}
+
+// loads active baskets and seeds data/baskets.json on first run, runs after initTicks
+func initBaskets() {
+	rows, err := db.Query("SELECT id, name, description, components, cash, created_at FROM baskets WHERE status = 'active' ORDER BY created_at ASC")
+	if err != nil {
+		log.Printf("Failed to load baskets: %v", err)
+		return
+	}
+	var loaded []*Basket
+	for rows.Next() {
+		var b Basket
+		var desc, created sql.NullString
+		var comps string
+		if err := rows.Scan(&b.ID, &b.Name, &desc, &comps, &b.Cash, &created); err != nil {
+			continue
+		}
+		b.Description = nullToString(desc)
+		b.CreatedAt = nullToString(created)
+		if err := json.Unmarshal([]byte(comps), &b.Components); err != nil {
+			log.Printf("Skipping basket %s: %v", b.ID, err)
+			continue
+		}
+		loaded = append(loaded, &b)
+	}
+	rows.Close()
+	for _, b := range loaded {
+		listBasket(b)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM baskets").Scan(&count); err != nil || count > 0 {
+		return
+	}
+	data, err := os.ReadFile("data/baskets.json")
+	if err != nil {
+		return
+	}
+	var arr []BasketRequest
+	if err := json.Unmarshal(data, &arr); err != nil {
+		log.Printf("Failed to parse baskets.json: %v", err)
+		return
+	}
+	for _, req := range arr {
+		if _, err := createBasket(req); err != nil {
+			log.Printf("Skipping basket %q: %v", req.ID, err)
+		}
+	}
+	log.Printf("Seeded %d baskets", len(arr))
+}
+
+func listBaskets() []Basket {
+	stocksLock.Lock()
+	prices := make(map[string]float64, len(stocks))
+	for _, s := range stocks {
+		prices[s.ID] = s.Price
+	}
+	stocksLock.Unlock()
+
+	basketLock.Lock()
+	defer basketLock.Unlock()
+	out := make([]Basket, 0, len(baskets))
+	for _, b := range baskets {
+		c := *b
+		c.Components = make([]BasketComponent, len(b.Components))
+		for i, comp := range b.Components {
+			if b.Price > 0 {
+				comp.Weight = roundToFour(comp.Units * prices[comp.StockID] / b.Price)
+			}
+			comp.Units = math.Round(comp.Units*1e6) / 1e6
+			c.Components[i] = comp
+		}
+		c.Price = roundToFour(c.Price)
+		c.Cash = roundToFour(c.Cash)
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// GET /api/baskets, every etf with what it holds
+func basketsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	writeJSON(w, listBaskets())
+}
+
+// POST /api/admin/baskets {"id": "TECHX", "name": "Tech Basket", "sector": "Technology", "method": "equal"}
+// or {"id": "BIG3", "name": "...", "weights": {"APEX": 0.5, "NOVA": 0.3, "TITAN": 0.2}, "price": 50}
+// baskets are removed through /api/admin/listings/delist like any other symbol
+func adminBasketsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(w, listBaskets())
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req BasketRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	b, err := createBasket(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	publishEvent("listing", map[string]interface{}{
+		"type":   "listing",
+		"action": "basket_listed",
+		"basket": b,
+		"time":   time.Now().Local().Format(time.RFC3339),
+	})
+	writeJSON(w, b)
+}
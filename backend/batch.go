@@ -0,0 +1,316 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const maxBatchLegs = 50
+
+// a rebalance target, percent of net worth (cash plus stock positions) to hold in the stock
+type batchTarget struct {
+	StockID string  `json:"stock_id"`
+	Percent float64 `json:"percent"`
+}
+
+// POST /api/trade/batch, several trades in one go:
+// {"legs": [{"stock_id": "APEX", "action": "sell", "shares": 2}, {"stock_id": "NOVA", "action": "buy", "amount": 500}]}
+// or a rebalance: {"targets": [{"stock_id": "APEX", "percent": 40}, {"stock_id": "NOVA", "percent": 0}]}
+// every leg trades at one price snapshot, sells go before buys and either every leg commits or none do
+func batchTradeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := parseUserIDFromRequest(r)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	var req struct {
+		Legs      []tradeLeg    `json:"legs"`
+		Targets   []batchTarget `json:"targets"`
+		LotMethod string        `json:"lot_method"` // for every sell that doesnt set its own
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, badJSON(err), http.StatusBadRequest)
+		return
+	}
+	if (len(req.Legs) == 0) == (len(req.Targets) == 0) {
+		http.Error(w, "give legs or targets", http.StatusBadRequest)
+		return
+	}
+	if len(req.Legs) > maxBatchLegs || len(req.Targets) > maxBatchLegs {
+		http.Error(w, fmt.Sprintf("at most %d legs in a batch", maxBatchLegs), http.StatusBadRequest)
+		return
+	}
+
+	if key := strings.TrimSpace(r.Header.Get("Idempotency-Key")); key != "" {
+		if !claimIdempotencyKey(w, userID, key, body) {
+			return
+		}
+		defer holdIdempotencyKey(userID, key)()
+		iw := &idempotentWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			if p := recover(); p != nil {
+				iw.status = http.StatusInternalServerError
+				finishIdempotencyKey(userID, key, iw)
+				panic(p)
+			}
+			finishIdempotencyKey(userID, key, iw)
+		}()
+		w = iw
+	}
+
+	for i := range req.Legs {
+		l := &req.Legs[i]
+		l.StockID = strings.ToUpper(strings.TrimSpace(l.StockID))
+		l.Action = strings.ToLower(strings.TrimSpace(l.Action))
+		if l.Action != "buy" && l.Action != "sell" {
+			http.Error(w, fmt.Sprintf("leg %d: action must be buy or sell", i+1), http.StatusBadRequest)
+			return
+		}
+		if err := l.validate(); err != nil {
+			http.Error(w, fmt.Sprintf("leg %d: %s", i+1, err), http.StatusBadRequest)
+			return
+		}
+	}
+	total := 0.0
+	seen := map[string]bool{}
+	for i := range req.Targets {
+		t := &req.Targets[i]
+		t.StockID = strings.ToUpper(strings.TrimSpace(t.StockID))
+		if t.Percent < 0 || t.Percent > 100 {
+			http.Error(w, fmt.Sprintf("target %d: percent must be between 0 and 100", i+1), http.StatusBadRequest)
+			return
+		}
+		if seen[t.StockID] {
+			http.Error(w, t.StockID+" is in targets twice", http.StatusBadRequest)
+			return
+		}
+		seen[t.StockID] = true
+		total += t.Percent
+	}
+	if total > 100+1e-9 {
+		http.Error(w, "targets add up to more than 100%", http.StatusBadRequest)
+		return
+	}
+
+	defer lockAccount(userID)()
+
+	// a split or buyback cant run halfway through the batch
+	corpActionLock.RLock()
+	defer corpActionLock.RUnlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "db tx error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	var cash Money
+	if err := tx.QueryRow("SELECT cash FROM users WHERE id = ?", userID).Scan(&cash); err == sql.ErrNoRows {
+		tx.Rollback()
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		tx.Rollback()
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	held, err := batchHoldings(tx, userID)
+	if err != nil {
+		tx.Rollback()
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	// one look at the prices for the whole batch
+	prices := priceSnapshot()
+	symbols := map[string]bool{}
+	for _, l := range req.Legs {
+		symbols[l.StockID] = true
+	}
+	for _, t := range req.Targets {
+		symbols[t.StockID] = true
+	}
+	for id := range symbols {
+		if _, ok := prices[id]; !ok {
+			tx.Rollback()
+			http.Error(w, "unknown stock "+id, http.StatusBadRequest)
+			return
+		}
+		if msg := haltMessage(id); msg != "" {
+			tx.Rollback()
+			http.Error(w, msg, http.StatusConflict)
+			return
+		}
+	}
+
+	legs := req.Legs
+	if len(req.Targets) > 0 {
+		legs = targetLegs(req.Targets, cash, held, prices)
+	}
+
+	// size everything up front so the cash check covers the whole batch
+	for i := range legs {
+		l := &legs[i]
+		if l.LotMethod == "" {
+			l.LotMethod = req.LotMethod
+		}
+		if l.Amount > 0 {
+			l.Shares = sharesForAmount(l.Action, l.Amount, prices[l.StockID])
+			l.Amount = 0
+			// a rebalance can have legs too small to trade, given legs cant
+			if l.Shares <= 0 && len(req.Targets) == 0 {
+				tx.Rollback()
+				http.Error(w, fmt.Sprintf("leg %d: amount is too small to trade any shares at this price", i+1), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	sort.SliceStable(legs, func(i, j int) bool { return legs[i].Action == "sell" && legs[j].Action != "sell" })
+
+	after := cash
+	for _, l := range legs {
+		cost := costOf(l.Shares, prices[l.StockID])
+		if l.Action == "sell" {
+			after += cost - tradeFee(cost)
+		} else {
+			after -= cost + tradeFee(cost)
+		}
+	}
+	if after < 0 {
+		tx.Rollback()
+		http.Error(w, "insufficient funds, the batch needs "+(-after).String()+" more", http.StatusBadRequest)
+		return
+	}
+
+	fills := []tradeFill{}
+	var bought, sold, fees Money
+	for _, l := range legs {
+		if l.Shares <= 0 {
+			continue
+		}
+		fill, err := executeTrade(tx, userID, l, prices[l.StockID])
+		if err != nil {
+			tx.Rollback()
+			if _, ok := err.(tradeRejected); ok {
+				err = tradeRejected(fmt.Sprintf("%s %s %s: %s", l.Action, l.Shares, l.StockID, err))
+			}
+			writeTradeError(w, err)
+			return
+		}
+		fills = append(fills, fill)
+		fees += fill.Fee
+		if fill.Action == "buy" {
+			bought += fill.Total
+		} else {
+			sold += fill.Total
+		}
+	}
+	if len(fills) == 0 {
+		tx.Rollback()
+		http.Error(w, "nothing to trade, the portfolio is already at its targets", http.StatusBadRequest)
+		return
+	}
+	if err := tx.QueryRow("SELECT cash FROM users WHERE id = ?", userID).Scan(&cash); err != nil {
+		tx.Rollback()
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		http.Error(w, "db commit error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"status": "ok", "fills": fills, "bought": bought, "sold": sold, "fees": fees, "cash": cash})
+}
+
+func batchHoldings(tx *sql.Tx, userID int64) (map[string]Qty, error) {
+	rows, err := tx.Query("SELECT stock_id, shares FROM portfolio WHERE user_id = ? AND shares > 0", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	held := map[string]Qty{}
+	for rows.Next() {
+		var id string
+		var shares Qty
+		if err := rows.Scan(&id, &shares); err != nil {
+			return nil, err
+		}
+		held[id] = shares
+	}
+	return held, rows.Err()
+}
+
+func priceSnapshot() map[string]float64 {
+	stocksLock.Lock()
+	defer stocksLock.Unlock()
+	prices := make(map[string]float64, len(stocks))
+	for _, s := range stocks {
+		prices[s.ID] = s.Price
+	}
+	return prices
+}
+
+// the trades that bring each target stock to its share of net worth. buys are sized in cash and scaled down
+// together when sells plus cash cant cover them with fees, stocks not in targets are left alone
+func targetLegs(targets []batchTarget, cash Money, held map[string]Qty, prices map[string]float64) []tradeLeg {
+	networth := cash
+	for id, q := range held {
+		networth += costOf(q, prices[id])
+	}
+
+	var legs []tradeLeg
+	available, needed := cash, Money(0)
+	for _, t := range targets {
+		price := prices[t.StockID]
+		want := moneyFromFloat(networth.Float() * t.Percent / 100)
+		have := costOf(held[t.StockID], price)
+		switch {
+		case want < have:
+			q := qtyForAmount(have-want, price)
+			if t.Percent == 0 || q > held[t.StockID] {
+				q = held[t.StockID]
+			}
+			if q > 0 {
+				legs = append(legs, tradeLeg{StockID: t.StockID, Action: "sell", Shares: q})
+				cost := costOf(q, price)
+				available += cost - tradeFee(cost)
+			}
+		case want > have:
+			legs = append(legs, tradeLeg{StockID: t.StockID, Action: "buy", Amount: want - have})
+			needed += want - have
+		}
+	}
+	if needed > available && available > 0 {
+		for i := range legs {
+			if legs[i].Action == "buy" {
+				legs[i].Amount = Money(mulDiv(int64(legs[i].Amount), int64(available), int64(needed), false))
+			}
+		}
+	}
+	return legs
+}
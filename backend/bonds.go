@@ -0,0 +1,811 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// "rates" in config.json. everything is per hour, competitions run hours or weeks so yearly rates would round to nothing
+type RateSettings struct {
+	BondYield     float64 `json:"bond_yield"`     // every bond is discounted at this, so moving it moves bond prices
+	CashRate      float64 `json:"cash_rate"`      // paid on idle cash while the competition is open, 0 turns it off
+	CreditSeconds int     `json:"credit_seconds"` // how often cash interest gets credited
+}
+
+func defaultRateSettings() *RateSettings {
+	return &RateSettings{BondYield: 0.0005, CashRate: 0.0001, CreditSeconds: 300}
+}
+
+var (
+	rateSettings = defaultRateSettings()
+	rateLock     sync.Mutex
+)
+
+func currentRates() RateSettings {
+	rateLock.Lock()
+	defer rateLock.Unlock()
+	return *rateSettings
+}
+
+// the last admin rate change wins over config.json, runs after initDB
+func loadRates() {
+	var bond, cash float64
+	err := db.QueryRow("SELECT bond_yield, cash_rate FROM rate_changes ORDER BY id DESC LIMIT 1").Scan(&bond, &cash)
+	if err != nil {
+		return
+	}
+	rateLock.Lock()
+	rateSettings.BondYield = bond
+	rateSettings.CashRate = cash
+	rateLock.Unlock()
+}
+
+// a bill is a bond with no coupon, it only pays face at maturity
+type Bond struct {
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	Kind          string  `json:"kind"` // bill or bond
+	Face          float64 `json:"face"`
+	CouponRate    float64 `json:"coupon_rate"` // per hour, of face
+	CouponSeconds int     `json:"coupon_seconds,omitempty"`
+	IssuedAt      string  `json:"issued_at"`
+	MaturesAt     string  `json:"matures_at"`
+	Status        string  `json:"status"` // open or matured
+
+	// filled in for open bonds
+	Price          float64 `json:"price"`
+	Yield          float64 `json:"yield"`
+	AccruedPerUnit float64 `json:"accrued_interest"` // coupon earned since the last payment, already inside price
+	NextCouponAt   string  `json:"next_coupon_at,omitempty"`
+
+	issued, matures, lastCoupon time.Time
+}
+
+const bondColumns = "id, name, face, coupon_rate, coupon_seconds, issued_at, matures_at, last_coupon_at, status"
+
+func scanBond(row rowScanner) (Bond, error) {
+	var b Bond
+	var issued, matures, last string
+	if err := row.Scan(&b.ID, &b.Name, &b.Face, &b.CouponRate, &b.CouponSeconds, &issued, &matures, &last, &b.Status); err != nil {
+		return b, err
+	}
+	b.issued = parseDBTimeToLocal(issued)
+	b.matures = parseDBTimeToLocal(matures)
+	b.lastCoupon = parseDBTimeToLocal(last)
+	b.IssuedAt = b.issued.Format(time.RFC3339)
+	b.MaturesAt = b.matures.Format(time.RFC3339)
+	b.Kind = bondKind(b.CouponRate, b.CouponSeconds)
+	return b, nil
+}
+
+func bondKind(couponRate float64, couponSeconds int) string {
+	if couponRate <= 0 || couponSeconds <= 0 {
+		return "bill"
+	}
+	return "bond"
+}
+
+func getBond(id string) (Bond, error) {
+	return scanBond(db.QueryRow("SELECT "+bondColumns+" FROM bonds WHERE id = ?", strings.ToUpper(strings.TrimSpace(id))))
+}
+
+func (b *Bond) coupon(d time.Duration) float64 {
+	return b.Face * b.CouponRate * d.Hours()
+}
+
+type cashFlow struct {
+	at     time.Time
+	amount float64
+}
+
+// coupons still to be paid after now plus face at maturity. the last coupon is cut short if the
+// schedule doesnt land on maturity exactly
+func (b *Bond) cashFlows(now time.Time) []cashFlow {
+	var out []cashFlow
+	prev := b.lastCoupon
+	if b.Kind == "bond" {
+		step := time.Duration(b.CouponSeconds) * time.Second
+		for next := prev.Add(step); next.Before(b.matures); next = next.Add(step) {
+			if next.After(now) {
+				out = append(out, cashFlow{next, b.coupon(step)})
+			}
+			prev = next
+		}
+	}
+	final := b.Face
+	if b.Kind == "bond" {
+		final += b.coupon(b.matures.Sub(prev))
+	}
+	return append(out, cashFlow{b.matures, final})
+}
+
+// present value of what's left at the current yield, continuously compounded per hour
+func priceBond(b *Bond, now time.Time) {
+	if b.Status != "open" {
+		return
+	}
+	y := currentRates().BondYield
+	pv := 0.0
+	for _, f := range b.cashFlows(now) {
+		t := f.at.Sub(now).Hours()
+		if t < 0 {
+			t = 0
+		}
+		pv += f.amount * math.Exp(-y*t)
+	}
+	b.Price = roundToFour(pv)
+	b.Yield = y
+	if b.Kind == "bond" {
+		if now.After(b.lastCoupon) {
+			b.AccruedPerUnit = roundToFour(b.coupon(now.Sub(b.lastCoupon)))
+		}
+		next := b.lastCoupon.Add(time.Duration(b.CouponSeconds) * time.Second)
+		if next.After(b.matures) {
+			next = b.matures
+		}
+		b.NextCouponAt = next.Format(time.RFC3339)
+	}
+}
+
+type BondRequest struct {
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	Face          float64 `json:"face"`
+	CouponRate    float64 `json:"coupon_rate"`
+	CouponSeconds int     `json:"coupon_seconds"`
+	MaturesAt     string  `json:"matures_at"` // a time, or "end" for the end of the competition
+	TermSeconds   int     `json:"term_seconds"`
+}
+
+func issueBond(req BondRequest, now time.Time) (Bond, error) {
+	req.ID = strings.ToUpper(strings.TrimSpace(req.ID))
+	req.Name = strings.TrimSpace(req.Name)
+	if !validSymbol(req.ID) {
+		return Bond{}, errors.New("id must be 1-8 letters or digits")
+	}
+	if symbolTaken(req.ID) {
+		return Bond{}, errors.New("symbol " + req.ID + " is already in use")
+	}
+	if req.Name == "" {
+		req.Name = req.ID
+	}
+	if req.Face == 0 {
+		req.Face = 100
+	}
+	if req.Face <= 0 || req.CouponRate < 0 || req.CouponSeconds < 0 {
+		return Bond{}, errors.New("face, coupon_rate and coupon_seconds cant be negative")
+	}
+	if req.CouponRate > 0 && req.CouponSeconds == 0 {
+		return Bond{}, errors.New("a coupon needs coupon_seconds")
+	}
+
+	var matures time.Time
+	switch {
+	case strings.EqualFold(strings.TrimSpace(req.MaturesAt), "end"):
+		matures = compEnd
+	case req.MaturesAt != "":
+		t, err := parseCompTime(req.MaturesAt)
+		if err != nil {
+			return Bond{}, err
+		}
+		matures = t
+	case req.TermSeconds > 0:
+		matures = now.Add(time.Duration(req.TermSeconds) * time.Second)
+	default:
+		return Bond{}, errors.New("need matures_at or term_seconds")
+	}
+	if !matures.After(now.Add(time.Minute)) {
+		return Bond{}, errors.New("maturity has to be in the future")
+	}
+
+	_, err := db.Exec("INSERT INTO bonds (id, name, face, coupon_rate, coupon_seconds, issued_at, matures_at, last_coupon_at, status) VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'open')",
+		req.ID, req.Name, req.Face, req.CouponRate, req.CouponSeconds, toDBTime(now), toDBTime(matures), toDBTime(now))
+	if err != nil {
+		return Bond{}, err
+	}
+	b, err := getBond(req.ID)
+	if err == nil {
+		priceBond(&b, now)
+	}
+	return b, err
+}
+
+// seeds data/bonds.json the first time, issued when the competition starts (or now if it already has)
+func initBonds() {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM bonds").Scan(&count); err != nil || count > 0 {
+		return
+	}
+	data, err := os.ReadFile("data/bonds.json")
+	if err != nil {
+		return
+	}
+	var arr []BondRequest
+	if err := json.Unmarshal(data, &arr); err != nil {
+		log.Printf("Failed to parse bonds.json: %v", err)
+		return
+	}
+	issue := time.Now().UTC()
+	if compStart.After(issue) {
+		issue = compStart
+	}
+	for _, req := range arr {
+		if _, err := issueBond(req, issue); err != nil {
+			log.Printf("Skipping bond %q: %v", req.ID, err)
+		}
+	}
+	log.Printf("Seeded %d bonds", len(arr))
+}
+
+// pays every holder amount per unit, matured also closes the positions
+func payBondHolders(tx *sql.Tx, b Bond, perUnit float64, action string) error {
+	rows, err := tx.Query("SELECT user_id, quantity FROM bond_positions WHERE bond_id = ? AND quantity > 0", b.ID)
+	if err != nil {
+		return err
+	}
+	type pos struct{ userID, qty int64 }
+	var positions []pos
+	for rows.Next() {
+		var p pos
+		if err := rows.Scan(&p.userID, &p.qty); err == nil {
+			positions = append(positions, p)
+		}
+	}
+	rows.Close()
+
+	for _, p := range positions {
+		amount := moneyFromFloat(float64(p.qty) * perUnit)
+		if _, err := tx.Exec("UPDATE users SET cash = cash + ? WHERE id = ?", amount, p.userID); err != nil {
+			return err
+		}
+		if _, err := recordTransaction(tx, txnRow{UserID: p.userID, StockID: b.ID, Action: action, Shares: wholeShares(p.qty), Price: perUnit, Amount: amount}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pays coupons that came due and redeems the bond at maturity
+func processBond(b Bond, now time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if b.Kind == "bond" {
+		step := time.Duration(b.CouponSeconds) * time.Second
+		for next := b.lastCoupon.Add(step); next.Before(b.matures) && !next.After(now); next = next.Add(step) {
+			if err := payBondHolders(tx, b, b.coupon(step), "coupon"); err != nil {
+				tx.Rollback()
+				return err
+			}
+			b.lastCoupon = next
+		}
+	}
+	status := "open"
+	if !b.matures.After(now) {
+		final := b.Face
+		if b.Kind == "bond" {
+			final += b.coupon(b.matures.Sub(b.lastCoupon))
+		}
+		if err := payBondHolders(tx, b, final, "bond_redeem"); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM bond_positions WHERE bond_id = ?", b.ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		b.lastCoupon = b.matures
+		status = "matured"
+	}
+	if _, err := tx.Exec("UPDATE bonds SET last_coupon_at = ?, status = ? WHERE id = ?", toDBTime(b.lastCoupon), status, b.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// credits interest on every positive cash balance in competition compID for the time since the last credit
+func creditCashInterest(compID int64, rate float64, since time.Duration) error {
+	f := rate * since.Hours()
+	if f <= 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	rows, err := tx.Query("SELECT id, cash FROM users WHERE cash > 0 AND competition_id = ?", compID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	type bal struct {
+		id   int64
+		cash Money
+	}
+	var list []bal
+	for rows.Next() {
+		var b bal
+		if err := rows.Scan(&b.id, &b.cash); err == nil {
+			list = append(list, b)
+		}
+	}
+	rows.Close()
+
+	for _, b := range list {
+		interest := moneyFromFloat(b.cash.Float() * f)
+		if interest <= 0 {
+			continue
+		}
+		if _, err := tx.Exec("UPDATE users SET cash = cash + ? WHERE id = ?", interest, b.id); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := recordTransaction(tx, txnRow{UserID: b.id, StockID: ledgerCash, Action: "interest", Price: interest.Float(), Amount: interest}); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// coupons, maturities and cash interest, started from main
+func bondsWatcher() {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	lastCredit := map[int64]time.Time{} // per competition, from when the watcher first sees it
+	for now := range ticker.C {
+		rows, err := db.Query("SELECT " + bondColumns + " FROM bonds WHERE status = 'open'")
+		if err == nil {
+			var open []Bond
+			for rows.Next() {
+				if b, err := scanBond(rows); err == nil {
+					open = append(open, b)
+				}
+			}
+			rows.Close()
+			for _, b := range open {
+				next := b.matures
+				if b.Kind == "bond" {
+					if c := b.lastCoupon.Add(time.Duration(b.CouponSeconds) * time.Second); c.Before(next) {
+						next = c
+					}
+				}
+				if next.After(now) {
+					continue
+				}
+				if err := processBond(b, now); err != nil {
+					log.Printf("bond %s payment failed: %v", b.ID, err)
+				}
+			}
+		}
+
+		rates := currentRates()
+		if rates.CreditSeconds <= 0 {
+			continue
+		}
+		comps, err := listCompetitions()
+		if err != nil {
+			log.Printf("cash interest failed: %v", err)
+			continue
+		}
+		for _, c := range comps {
+			last, seen := lastCredit[c.ID]
+			if !seen {
+				lastCredit[c.ID] = now
+				continue
+			}
+			if now.Sub(last) < time.Duration(rates.CreditSeconds)*time.Second {
+				continue
+			}
+			// interest only runs while the account's competition is open, otherwise sitting out would win
+			if c.open(now) && rates.CashRate > 0 {
+				if err := creditCashInterest(c.ID, rates.CashRate, now.Sub(last)); err != nil {
+					// try again next second, the time since last still counts
+					log.Printf("cash interest for %s failed: %v", c.Slug, err)
+					continue
+				}
+			}
+			lastCredit[c.ID] = now
+		}
+	}
+}
+
+// value of a user's bonds at the current yield
+func bondPositionsValue(q querier, userID int64) float64 {
+	rows, err := q.Query("SELECT "+prefixColumns("b.", bondColumns)+", p.quantity FROM bond_positions p JOIN bonds b ON b.id = p.bond_id WHERE p.user_id = ? AND b.status = 'open'", userID)
+	if err != nil {
+		return 0
+	}
+	defer rows.Close()
+	total := 0.0
+	now := time.Now()
+	for rows.Next() {
+		var b Bond
+		var issued, matures, last string
+		var qty int64
+		if err := rows.Scan(&b.ID, &b.Name, &b.Face, &b.CouponRate, &b.CouponSeconds, &issued, &matures, &last, &b.Status, &qty); err != nil {
+			continue
+		}
+		b.matures = parseDBTimeToLocal(matures)
+		b.lastCoupon = parseDBTimeToLocal(last)
+		b.Kind = bondKind(b.CouponRate, b.CouponSeconds)
+		priceBond(&b, now)
+		total += float64(qty) * b.Price
+	}
+	return total
+}
+
+func listBonds(status string) ([]Bond, error) {
+	q := "SELECT " + bondColumns + " FROM bonds"
+	var args []interface{}
+	if status != "" {
+		q += " WHERE status = ?"
+		args = append(args, status)
+	}
+	rows, err := db.Query(q+" ORDER BY matures_at ASC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Bond{}
+	now := time.Now()
+	for rows.Next() {
+		b, err := scanBond(rows)
+		if err != nil {
+			return nil, err
+		}
+		priceBond(&b, now)
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// GET /api/bonds, open bonds and bills at their current price
+func bondsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	list, err := listBonds("open")
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	rates := currentRates()
+	writeJSON(w, map[string]interface{}{"bonds": list, "bond_yield": rates.BondYield, "cash_rate": rates.CashRate})
+}
+
+type BondPosition struct {
+	Bond
+	Quantity     int64   `json:"quantity"`
+	AvgPrice     float64 `json:"avg_price"`
+	MarketValue  float64 `json:"market_value"`
+	UnrealizedPL float64 `json:"unrealized_pl"`
+}
+
+// GET /api/bonds/positions, the signed in user's bonds
+func bondPositionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	userID, err := parseUserIDFromRequest(r)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	rows, err := db.Query("SELECT "+prefixColumns("b.", bondColumns)+", p.quantity, p.avg_price FROM bond_positions p JOIN bonds b ON b.id = p.bond_id WHERE p.user_id = ? ORDER BY b.matures_at ASC", userID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := []BondPosition{}
+	for rows.Next() {
+		var p BondPosition
+		var issued, matures, last string
+		if err := rows.Scan(&p.ID, &p.Name, &p.Face, &p.CouponRate, &p.CouponSeconds, &issued, &matures, &last, &p.Status, &p.Quantity, &p.AvgPrice); err != nil {
+			rows.Close()
+			http.Error(w, "db scan error", http.StatusInternalServerError)
+			return
+		}
+		p.issued = parseDBTimeToLocal(issued)
+		p.matures = parseDBTimeToLocal(matures)
+		p.lastCoupon = parseDBTimeToLocal(last)
+		p.IssuedAt = p.issued.Format(time.RFC3339)
+		p.MaturesAt = p.matures.Format(time.RFC3339)
+		p.Kind = bondKind(p.CouponRate, p.CouponSeconds)
+		out = append(out, p)
+	}
+	rows.Close()
+
+	now := time.Now()
+	for i := range out {
+		priceBond(&out[i].Bond, now)
+		out[i].MarketValue = roundToTwo(float64(out[i].Quantity) * out[i].Price)
+		out[i].UnrealizedPL = roundToTwo(out[i].MarketValue - float64(out[i].Quantity)*out[i].AvgPrice)
+	}
+	writeJSON(w, out)
+}
+
+func tradeBond(userID int64, id, action string, qty int64) (Bond, error) {
+	if qty <= 0 || checkCount(qty) != nil {
+		return Bond{}, errOutOfRange
+	}
+	defer lockAccount(userID)()
+	b, err := getBond(id)
+	if err == sql.ErrNoRows {
+		return b, errors.New("unknown bond")
+	} else if err != nil {
+		return b, err
+	}
+	now := time.Now()
+	if b.Status != "open" || !b.matures.After(now) {
+		return b, errors.New("bond has matured")
+	}
+	priceBond(&b, now)
+	total := costOf(wholeShares(qty), b.Price)
+	if total <= 0 || total == math.MaxInt64 {
+		return b, errOutOfRange
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return b, err
+	}
+	// like options only the trade rate rule applies
+	comp, err := accountCompetition(tx, userID)
+	if err != nil {
+		tx.Rollback()
+		return b, err
+	}
+	if err := comp.checkOpen(now); err != nil {
+		tx.Rollback()
+		return b, err
+	}
+	if err := checkTradeRate(tx, comp.rules(), userID); err != nil {
+		tx.Rollback()
+		return b, err
+	}
+	var held int64
+	var avg float64
+	err = tx.QueryRow("SELECT quantity, avg_price FROM bond_positions WHERE user_id = ? AND bond_id = ?", userID, b.ID).Scan(&held, &avg)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return b, err
+	}
+
+	switch action {
+	case "buy":
+		var cash Money
+		if err := tx.QueryRow("SELECT cash FROM users WHERE id = ?", userID).Scan(&cash); err != nil {
+			tx.Rollback()
+			return b, errors.New("user not found")
+		}
+		if cash < total {
+			tx.Rollback()
+			return b, errors.New("insufficient funds")
+		}
+		newAvg := (float64(held)*avg + total.Float()) / float64(held+qty)
+		_, err = tx.Exec(`INSERT INTO bond_positions (user_id, bond_id, quantity, avg_price) VALUES (?, ?, ?, ?)
+			ON CONFLICT(user_id, bond_id) DO UPDATE SET quantity = excluded.quantity, avg_price = excluded.avg_price`, userID, b.ID, held+qty, newAvg)
+		if err == nil {
+			_, err = tx.Exec("UPDATE users SET cash = cash - ? WHERE id = ?", total, userID)
+		}
+	case "sell":
+		if held < qty {
+			tx.Rollback()
+			return b, errors.New("not enough bonds")
+		}
+		if held == qty {
+			_, err = tx.Exec("DELETE FROM bond_positions WHERE user_id = ? AND bond_id = ?", userID, b.ID)
+		} else {
+			_, err = tx.Exec("UPDATE bond_positions SET quantity = ? WHERE user_id = ? AND bond_id = ?", held-qty, userID, b.ID)
+		}
+		if err == nil {
+			_, err = tx.Exec("UPDATE users SET cash = cash + ? WHERE id = ?", total, userID)
+		}
+	default:
+		tx.Rollback()
+		return b, errors.New("action must be buy or sell")
+	}
+	if err != nil {
+		tx.Rollback()
+		return b, err
+	}
+	if _, err := recordTransaction(tx, txnRow{UserID: userID, StockID: b.ID, Action: "bond_" + action, Shares: wholeShares(qty), Price: b.Price, Amount: total}); err != nil {
+		tx.Rollback()
+		return b, err
+	}
+	return b, tx.Commit()
+}
+
+// POST /api/bonds/trade {"id": "TBILL1D", "action": "buy", "quantity": 10}
+func bondTradeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := parseUserIDFromRequest(r)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	var req struct {
+		ID       string `json:"id"`
+		Action   string `json:"action"`
+		Quantity int64  `json:"quantity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if req.Quantity <= 0 {
+		http.Error(w, "quantity must be > 0", http.StatusBadRequest)
+		return
+	}
+	if err := checkCount(req.Quantity); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := tradeBond(userID, req.ID, strings.ToLower(strings.TrimSpace(req.Action)), req.Quantity)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var cash Money
+	_ = db.QueryRow("SELECT cash FROM users WHERE id = ?", userID).Scan(&cash)
+	writeJSON(w, map[string]interface{}{
+		"status":   "ok",
+		"bond":     b,
+		"action":   req.Action,
+		"quantity": req.Quantity,
+		"price":    b.Price,
+		"total":    costOf(wholeShares(req.Quantity), b.Price),
+		"cash":     cash,
+	})
+}
+
+// GET/POST /api/admin/bonds, everything ever issued or issue a new one
+func adminBondsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		list, err := listBonds(r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, list)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req BondRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	b, err := issueBond(req, time.Now().UTC())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	publishEvent("listing", map[string]interface{}{
+		"type":   "listing",
+		"action": "bond_issued",
+		"bond":   b,
+		"time":   time.Now().Local().Format(time.RFC3339),
+	})
+	writeJSON(w, b)
+}
+
+// GET /api/rates, current rates and every change so far
+func ratesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	rows, err := db.Query("SELECT bond_yield, cash_rate, note, created_at FROM rate_changes ORDER BY id ASC")
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	history := []map[string]interface{}{}
+	for rows.Next() {
+		var bond, cash float64
+		var note, created sql.NullString
+		if err := rows.Scan(&bond, &cash, &note, &created); err != nil {
+			http.Error(w, "db scan error", http.StatusInternalServerError)
+			return
+		}
+		history = append(history, map[string]interface{}{
+			"bond_yield": bond,
+			"cash_rate":  cash,
+			"note":       nullToString(note),
+			"time":       parseDBTimeToLocal(created.String).Format(time.RFC3339),
+		})
+	}
+	rates := currentRates()
+	writeJSON(w, map[string]interface{}{"bond_yield": rates.BondYield, "cash_rate": rates.CashRate, "credit_seconds": rates.CreditSeconds, "changes": history})
+}
+
+// POST /api/admin/rates {"bond_yield": 0.0008, "cash_rate": 0.0002, "note": "hike"}, leave one out to keep it
+func adminRatesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		BondYield *float64 `json:"bond_yield"`
+		CashRate  *float64 `json:"cash_rate"`
+		Note      string   `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if req.BondYield == nil && req.CashRate == nil {
+		http.Error(w, "need bond_yield or cash_rate", http.StatusBadRequest)
+		return
+	}
+	if (req.BondYield != nil && (*req.BondYield < 0 || *req.BondYield > 1)) || (req.CashRate != nil && (*req.CashRate < 0 || *req.CashRate > 1)) {
+		http.Error(w, "rates are per hour and must be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+
+	rateLock.Lock()
+	prev := *rateSettings
+	if req.BondYield != nil {
+		rateSettings.BondYield = *req.BondYield
+	}
+	if req.CashRate != nil {
+		rateSettings.CashRate = *req.CashRate
+	}
+	now := *rateSettings
+	rateLock.Unlock()
+
+	_, _ = db.Exec("INSERT INTO rate_changes (bond_yield, cash_rate, note) VALUES (?, ?, ?)", now.BondYield, now.CashRate, strings.TrimSpace(req.Note))
+	_, _ = db.Exec("INSERT INTO admin_actions (stock_id, action, magnitude, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)", "*", "rates", now.BondYield-prev.BondYield)
+
+	bonds, _ := listBonds("open")
+	payload := map[string]interface{}{
+		"type":       "rates",
+		"bond_yield": now.BondYield,
+		"cash_rate":  now.CashRate,
+		"previous":   map[string]interface{}{"bond_yield": prev.BondYield, "cash_rate": prev.CashRate},
+		"note":       strings.TrimSpace(req.Note),
+		"bonds":      bonds,
+		"time":       time.Now().Local().Format(time.RFC3339),
+	}
+	publishEvent("rates", payload)
+	writeJSON(w, payload)
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// trips when a price moves more than Threshold within WindowSeconds, trading then stops for HaltSeconds
+type BreakerRule struct {
+	Threshold     float64 `json:"threshold"` // 0.1 = 10%
+	WindowSeconds int     `json:"window_seconds"`
+	HaltSeconds   int     `json:"halt_seconds"`
+}
+
+// "circuit_breakers" in config.json, anything left out keeps the defaults below
+type BreakerSettings struct {
+	Enabled   bool                   `json:"enabled"`
+	Stock     BreakerRule            `json:"stock"`     // every stock unless overridden
+	Market    BreakerRule            `json:"market"`    // equal weighted move of the whole market, halts everything
+	Overrides map[string]BreakerRule `json:"overrides"` // per symbol
+}
+
+func defaultBreakerSettings() *BreakerSettings {
+	return &BreakerSettings{
+		Enabled:   true,
+		Stock:     BreakerRule{Threshold: 0.10, WindowSeconds: 300, HaltSeconds: 300},
+		Market:    BreakerRule{Threshold: 0.07, WindowSeconds: 300, HaltSeconds: 600},
+		Overrides: map[string]BreakerRule{},
+	}
+}
+
+type Halt struct {
+	Symbol string    `json:"symbol"` // "*" is a market-wide halt
+	Reason string    `json:"reason"`
+	Manual bool      `json:"manual"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until,omitempty"` // zero means until an admin resumes it
+}
+
+const marketHaltSymbol = "*"
+
+var (
+	breakers = defaultBreakerSettings()
+
+	haltLock sync.Mutex
+	halts    = map[string]*Halt{}
+	// after a resume only prices from then on count, otherwise the move that tripped it would trip it again
+	breakerResetAt = map[string]time.Time{}
+)
+
+func (s *BreakerSettings) ruleFor(symbol string) BreakerRule {
+	if r, ok := s.Overrides[strings.ToUpper(symbol)]; ok {
+		return r
+	}
+	return s.Stock
+}
+
+// why a trade in symbol would be refused, empty if it can trade
+func haltMessage(symbol string) string {
+	h := haltFor(symbol)
+	if h == nil {
+		return ""
+	}
+	msg := "trading in " + symbol + " is halted: " + h.Reason
+	if h.Symbol == marketHaltSymbol {
+		msg = "market-wide trading halt: " + h.Reason
+	}
+	if !h.Until.IsZero() {
+		msg += " (until " + h.Until.Local().Format("15:04:05") + ")"
+	}
+	return msg
+}
+
+// the halt blocking a symbol (its own or the market-wide one), nil if it can trade
+func haltFor(symbol string) *Halt {
+	haltLock.Lock()
+	defer haltLock.Unlock()
+	if h, ok := halts[marketHaltSymbol]; ok {
+		c := *h
+		return &c
+	}
+	if h, ok := halts[strings.ToUpper(symbol)]; ok {
+		c := *h
+		return &c
+	}
+	return nil
+}
+
+func haltedSymbols() map[string]bool {
+	haltLock.Lock()
+	defer haltLock.Unlock()
+	out := make(map[string]bool, len(halts))
+	for s := range halts {
+		out[s] = true
+	}
+	return out
+}
+
+func listHalts() []Halt {
+	haltLock.Lock()
+	defer haltLock.Unlock()
+	out := make([]Halt, 0, len(halts))
+	for _, h := range halts {
+		out = append(out, *h)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Since.Before(out[j].Since) })
+	return out
+}
+
+// copies the halt state onto stocks so price messages carry it
+func refreshHaltFlags() {
+	halted := haltedSymbols()
+	stocksLock.Lock()
+	for i := range stocks {
+		stocks[i].Halted = halted[marketHaltSymbol] || halted[stocks[i].ID]
+	}
+	stocksLock.Unlock()
+}
+
+func haltSymbol(symbol, reason string, d time.Duration, manual bool) Halt {
+	symbol = strings.ToUpper(strings.TrimSpace(symbol))
+	now := time.Now()
+	h := &Halt{Symbol: symbol, Reason: reason, Manual: manual, Since: now}
+	if d > 0 {
+		h.Until = now.Add(d)
+	}
+	haltLock.Lock()
+	halts[symbol] = h
+	out := *h
+	haltLock.Unlock()
+
+	refreshHaltFlags()
+	log.Printf("Trading halted: %s (%s)", symbol, reason)
+	publishEvent("halt", map[string]interface{}{
+		"type":   "halt",
+		"halt":   out,
+		"symbol": symbol,
+		"time":   now.Local().Format(time.RFC3339),
+	})
+	return out
+}
+
+func resumeSymbol(symbol, reason string) bool {
+	symbol = strings.ToUpper(strings.TrimSpace(symbol))
+	now := time.Now()
+	haltLock.Lock()
+	_, ok := halts[symbol]
+	delete(halts, symbol)
+	breakerResetAt[symbol] = now
+	haltLock.Unlock()
+	if !ok {
+		return false
+	}
+
+	refreshHaltFlags()
+	log.Printf("Trading resumed: %s (%s)", symbol, reason)
+	publishEvent("resume", map[string]interface{}{
+		"type":   "resume",
+		"symbol": symbol,
+		"reason": reason,
+		"time":   now.Local().Format(time.RFC3339),
+	})
+	return true
+}
+
+// biggest move between any price in the window and now, plus the price the window started at
+func windowMove(rh []RawTickEvent, current float64, from time.Time) (float64, float64) {
+	move, start := 0.0, 0.0
+	for i := len(rh) - 1; i >= 0 && !rh[i].Time.Before(from); i-- {
+		if rh[i].Price <= 0 {
+			continue
+		}
+		start = rh[i].Price
+		if m := math.Abs(current/rh[i].Price - 1); m > move {
+			move = m
+		}
+	}
+	return move, start
+}
+
+func checkBreakers() {
+	if !breakers.Enabled {
+		return
+	}
+	now := time.Now()
+
+	stocksLock.Lock()
+	current := make([]Stock, len(stocks))
+	copy(current, stocks)
+	stocksLock.Unlock()
+
+	halted := haltedSymbols()
+	haltLock.Lock()
+	resets := make(map[string]time.Time, len(breakerResetAt))
+	for k, v := range breakerResetAt {
+		resets[k] = v
+	}
+	haltLock.Unlock()
+
+	type trip struct {
+		symbol string
+		move   float64
+		rule   BreakerRule
+	}
+	var trips []trip
+	marketSum, marketN := 0.0, 0
+
+	tickLock.Lock()
+	for _, s := range current {
+		rh := rawTickHistory[s.ID]
+
+		rule := breakers.ruleFor(s.ID)
+		if rule.Threshold > 0 && rule.WindowSeconds > 0 && !halted[s.ID] && !halted[marketHaltSymbol] {
+			from := now.Add(-time.Duration(rule.WindowSeconds) * time.Second)
+			if r := resets[s.ID]; r.After(from) {
+				from = r
+			}
+			if move, _ := windowMove(rh, s.Price, from); move > rule.Threshold {
+				trips = append(trips, trip{s.ID, move, rule})
+			}
+		}
+
+		if m := breakers.Market; m.Threshold > 0 && m.WindowSeconds > 0 {
+			from := now.Add(-time.Duration(m.WindowSeconds) * time.Second)
+			if r := resets[marketHaltSymbol]; r.After(from) {
+				from = r
+			}
+			if _, start := windowMove(rh, s.Price, from); start > 0 {
+				marketSum += s.Price/start - 1
+				marketN++
+			}
+		}
+	}
+	tickLock.Unlock()
+
+	for _, t := range trips {
+		haltSymbol(t.symbol, fmt.Sprintf("moved %.1f%% within %ds", t.move*100, t.rule.WindowSeconds), time.Duration(t.rule.HaltSeconds)*time.Second, false)
+	}
+	if m := breakers.Market; marketN > 0 && !halted[marketHaltSymbol] {
+		if avg := marketSum / float64(marketN); math.Abs(avg) > m.Threshold {
+			haltSymbol(marketHaltSymbol, fmt.Sprintf("market moved %.1f%% within %ds", avg*100, m.WindowSeconds), time.Duration(m.HaltSeconds)*time.Second, false)
+		}
+	}
+}
+
+// lifts halts whose cool-down is over and checks for new ones, started from main
+func breakerWatcher() {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		var expired []string
+		haltLock.Lock()
+		for s, h := range halts {
+			if !h.Until.IsZero() && now.After(h.Until) {
+				expired = append(expired, s)
+			}
+		}
+		haltLock.Unlock()
+		for _, s := range expired {
+			resumeSymbol(s, "cool-down over")
+		}
+		checkBreakers()
+	}
+}
+
+// GET /api/halts, whats halted right now and the breaker settings
+func haltsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"halts":    listHalts(),
+		"breakers": breakers,
+	})
+}
+
+// POST /api/admin/halt {"symbol": "APEX", "minutes": 10, "reason": "..."}, "*" halts the whole market, no minutes = until resumed
+func adminHaltHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Symbol  string  `json:"symbol"`
+		Minutes float64 `json:"minutes"`
+		Reason  string  `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	symbol := strings.ToUpper(strings.TrimSpace(req.Symbol))
+	if symbol != marketHaltSymbol {
+		if _, err := getStockPrice(symbol); err != nil {
+			http.Error(w, "stock not found", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Minutes < 0 {
+		http.Error(w, "minutes must be >= 0", http.StatusBadRequest)
+		return
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		reason = "halted by admin"
+	}
+	h := haltSymbol(symbol, reason, time.Duration(req.Minutes*float64(time.Minute)), true)
+	writeJSON(w, map[string]interface{}{"status": "halted", "halt": h})
+}
+
+// POST /api/admin/resume {"symbol": "APEX"}
+func adminResumeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Symbol string `json:"symbol"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if !resumeSymbol(req.Symbol, "resumed by admin") {
+		http.Error(w, "symbol is not halted", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"status": "resumed", "symbol": strings.ToUpper(strings.TrimSpace(req.Symbol))})
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// a competition (season) has its own schedule, stock universe, starting cash, rules and leaderboard.
+// every login is in "main", which comes from config.json. enrolling in another one makes a separate
+// account row in users (owner_id points at the login), so portfolios, transactions and the ledger
+// stay apart and every handler keeps working on an account id
+type Competition struct {
+	ID           int64         `json:"id"`
+	Slug         string        `json:"slug"`
+	Name         string        `json:"name"`
+	Start        time.Time     `json:"start"`
+	End          time.Time     `json:"end"`
+	StartingCash Money         `json:"starting_cash"`
+	Stocks       []string      `json:"stocks,omitempty"` // empty means every listed stock
+	Rules        *TradingRules `json:"rules,omitempty"`  // nil means the rules in config.json
+}
+
+const mainCompetition = "main"
+
+var (
+	errUnknownCompetition = errors.New("unknown competition")
+	errNotEnrolled        = errors.New("not enrolled in this competition")
+	slugPattern           = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+)
+
+func (c Competition) open(now time.Time) bool {
+	return now.After(c.Start) && now.Before(c.End)
+}
+
+// orders are only taken while the competition is running
+func (c Competition) checkOpen(now time.Time) error {
+	if !now.After(c.Start) {
+		return tradeRejected(c.Name + " hasnt started yet")
+	}
+	if !now.Before(c.End) {
+		return tradeRejected(c.Name + " is over")
+	}
+	return nil
+}
+
+func (c Competition) allows(symbol string) bool {
+	if len(c.Stocks) == 0 {
+		return true
+	}
+	for _, s := range c.Stocks {
+		if s == symbol {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Competition) rules() *TradingRules {
+	if c.Rules != nil {
+		return c.Rules
+	}
+	return tradingRules
+}
+
+// db or tx
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// db or tx, for reads that walk rows
+type querier interface {
+	rowQuerier
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+const competitionColumns = "id, slug, name, start_at, end_at, starting_cash, COALESCE(stocks, ''), COALESCE(rules, '')"
+
+func scanCompetition(row interface{ Scan(...interface{}) error }) (Competition, error) {
+	var c Competition
+	var start, end, stocks, rules string
+	if err := row.Scan(&c.ID, &c.Slug, &c.Name, &start, &end, &c.StartingCash, &stocks, &rules); err != nil {
+		return c, err
+	}
+	c.Start = parseDBTimeToLocal(start).UTC()
+	c.End = parseDBTimeToLocal(end).UTC()
+	if stocks != "" {
+		c.Stocks = strings.Split(stocks, ",")
+	}
+	if rules != "" {
+		c.Rules = &TradingRules{}
+		if err := json.Unmarshal([]byte(rules), c.Rules); err != nil {
+			c.Rules = nil
+		}
+	}
+	return c, nil
+}
+
+func getCompetition(slug string) (Competition, error) {
+	c, err := scanCompetition(db.QueryRow("SELECT "+competitionColumns+" FROM competitions WHERE slug = ?", slug))
+	if err == sql.ErrNoRows {
+		return c, errUnknownCompetition
+	}
+	return c, err
+}
+
+// the competition an account belongs to
+func accountCompetition(q rowQuerier, userID int64) (Competition, error) {
+	c, err := scanCompetition(q.QueryRow("SELECT "+competitionColumns+" FROM competitions WHERE id = (SELECT competition_id FROM users WHERE id = ?)", userID))
+	if err == sql.ErrNoRows {
+		return getCompetition(mainCompetition)
+	}
+	return c, err
+}
+
+// keeps "main" in step with config.json and puts every account from before competitions into it
+func seedCompetitions() {
+	_, err := db.Exec(`INSERT INTO competitions (slug, name, start_at, end_at, starting_cash) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(slug) DO UPDATE SET start_at = excluded.start_at, end_at = excluded.end_at`,
+		mainCompetition, "Main competition", toDBTime(compStart), toDBTime(compEnd), startingCash)
+	if err != nil {
+		log.Fatal("Failed to seed competitions:", err)
+	}
+	if _, err := db.Exec("UPDATE users SET competition_id = (SELECT id FROM competitions WHERE slug = ?) WHERE competition_id IS NULL", mainCompetition); err != nil {
+		log.Fatal("Failed to seed competitions:", err)
+	}
+}
+
+// the competition a request is about: ?competition=, the X-Competition header or the stocksim_competition
+// cookie, main otherwise
+func competitionFromRequest(r *http.Request) (Competition, error) {
+	slug := r.URL.Query().Get("competition")
+	if slug == "" {
+		slug = r.Header.Get("X-Competition")
+	}
+	if slug == "" {
+		if c, err := r.Cookie("stocksim_competition"); err == nil {
+			slug = c.Value
+		}
+	}
+	slug = strings.ToLower(strings.TrimSpace(slug))
+	if slug == "" {
+		slug = mainCompetition
+	}
+	return getCompetition(slug)
+}
+
+// the login's account in comp. id can be the login or any of its accounts
+func accountFor(id int64, comp Competition) (int64, error) {
+	var account int64
+	err := db.QueryRow(`SELECT u.id FROM users u, users me WHERE me.id = ? AND u.competition_id = ?
+		AND (u.id = COALESCE(me.owner_id, me.id) OR u.owner_id = COALESCE(me.owner_id, me.id))`, id, comp.ID).Scan(&account)
+	if err == sql.ErrNoRows {
+		return 0, errNotEnrolled
+	}
+	return account, err
+}
+
+func accountInContext(r *http.Request, id int64) (int64, error) {
+	comp, err := competitionFromRequest(r)
+	if err != nil {
+		return 0, err
+	}
+	return accountFor(id, comp)
+}
+
+// for handlers that found no account for the request
+func writeAuthError(w http.ResponseWriter, err error) {
+	switch err {
+	case errUnknownCompetition:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errNotEnrolled:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+	}
+}
+
+// the competition id listings and leaderboards filter on, 0 for an unknown competition so they come back empty
+func competitionIDFromRequest(r *http.Request) int64 {
+	comp, err := competitionFromRequest(r)
+	if err != nil {
+		return 0
+	}
+	return comp.ID
+}
+
+// enrolment accounts are stored as "name@slug" since school_code is unique, listings show the login's name.
+// goes in a query where users is aliased u
+const accountNameSQL = "COALESCE((SELECT o.school_code FROM users o WHERE o.id = u.owner_id), u.school_code)"
+
+func listCompetitions() ([]Competition, error) {
+	rows, err := db.Query("SELECT " + competitionColumns + " FROM competitions ORDER BY start_at ASC, id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Competition{}
+	for rows.Next() {
+		c, err := scanCompetition(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+type CompetitionOut struct {
+	Competition
+	Open      bool   `json:"open"`
+	Players   int    `json:"players"`
+	Enrolled  bool   `json:"enrolled"`
+	AccountID *int64 `json:"account_id,omitempty"`
+}
+
+// GET /api/competitions, every competition with whether the caller is in it
+func competitionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	comps, err := listCompetitions()
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	login, loginErr := loginFromRequest(r)
+	now := time.Now()
+	out := make([]CompetitionOut, 0, len(comps))
+	for _, c := range comps {
+		o := CompetitionOut{Competition: c, Open: c.open(now)}
+		_ = db.QueryRow("SELECT COUNT(*) FROM users WHERE competition_id = ?", c.ID).Scan(&o.Players)
+		if loginErr == nil {
+			if id, err := accountFor(login, c); err == nil {
+				o.Enrolled, o.AccountID = true, &id
+			}
+		}
+		out = append(out, o)
+	}
+	writeJSON(w, out)
+}
+
+// POST /api/competitions/enrol {"competition": "spring"}, opens an account in it with its starting cash
+// and makes it the current competition
+func enrolCompetitionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	login, err := loginFromRequest(r)
+	if err != nil {
+		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		Competition string `json:"competition"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	comp, err := getCompetition(strings.ToLower(strings.TrimSpace(req.Competition)))
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	if !comp.End.After(time.Now()) {
+		http.Error(w, comp.Name+" has ended", http.StatusBadRequest)
+		return
+	}
+	account, err := accountFor(login, comp)
+	if err == errNotEnrolled {
+		account, err = openAccount(login, comp)
+	}
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	setCompetitionCookie(w, comp.Slug)
+	writeJSON(w, map[string]interface{}{"status": "ok", "competition": comp.Slug, "account_id": account, "cash": comp.StartingCash})
+}
+
+func openAccount(login int64, comp Competition) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	var owner int64
+	var name string
+	if err := tx.QueryRow("SELECT COALESCE(owner_id, id) FROM users WHERE id = ?", login).Scan(&owner); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := tx.QueryRow("SELECT school_code FROM users WHERE id = ?", owner).Scan(&name); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	res, err := tx.Exec("INSERT INTO users (school_code, cash, starting_cash, competition_id, owner_id) VALUES (?, ?, ?, ?, ?)",
+		name+"@"+comp.Slug, comp.StartingCash, comp.StartingCash, comp.ID, owner)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	id, _ := res.LastInsertId()
+	if err := postJournal(tx, "opening", id, 0, cashLegs("capital", userAccount(id), comp.StartingCash)); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// POST /api/competitions/select {"competition": "spring"}, switches which competition the site shows
+func selectCompetitionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	login, err := loginFromRequest(r)
+	if err != nil {
+		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		Competition string `json:"competition"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	comp, err := getCompetition(strings.ToLower(strings.TrimSpace(req.Competition)))
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	account, err := accountFor(login, comp)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	setCompetitionCookie(w, comp.Slug)
+	writeJSON(w, map[string]interface{}{"status": "ok", "competition": comp.Slug, "account_id": account})
+}
+
+func setCompetitionCookie(w http.ResponseWriter, slug string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "stocksim_competition",
+		Value:    slug,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  time.Now().Add(30 * 24 * time.Hour),
+	})
+}
+
+// GET /api/admin/competitions lists them, POST creates or updates one by slug:
+// {"slug": "spring", "name": "Spring season", "start": "03/01/26 09:00", "end": "03/31/26 17:00",
+// "starting_cash": 25000, "stocks": ["APEX", "NOVA"], "rules": {"max_stock_percent": 0.3}}
+func adminCompetitionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		comps, err := listCompetitions()
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, comps)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Slug         string        `json:"slug"`
+		Name         string        `json:"name"`
+		Start        string        `json:"start"`
+		End          string        `json:"end"`
+		StartingCash Money         `json:"starting_cash"`
+		Stocks       []string      `json:"stocks"`
+		Rules        *TradingRules `json:"rules"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, badJSON(err), http.StatusBadRequest)
+		return
+	}
+	req.Slug = strings.ToLower(strings.TrimSpace(req.Slug))
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Slug == mainCompetition {
+		http.Error(w, "the main competition comes from config.json", http.StatusBadRequest)
+		return
+	}
+	if !slugPattern.MatchString(req.Slug) {
+		http.Error(w, "slug must be lowercase letters, digits and dashes (up to 32)", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		req.Name = req.Slug
+	}
+	start, errStart := parseCompTime(req.Start)
+	end, errEnd := parseCompTime(req.End)
+	if errStart != nil || errEnd != nil || !end.After(start) {
+		http.Error(w, "start and end required, end after start", http.StatusBadRequest)
+		return
+	}
+	if req.StartingCash <= 0 {
+		req.StartingCash = startingCash
+	}
+	for i, s := range req.Stocks {
+		req.Stocks[i] = strings.ToUpper(strings.TrimSpace(s))
+		if _, err := getStockPrice(req.Stocks[i]); err != nil {
+			http.Error(w, "unknown stock "+req.Stocks[i], http.StatusBadRequest)
+			return
+		}
+	}
+	var rules interface{}
+	if req.Rules != nil {
+		b, _ := json.Marshal(req.Rules)
+		rules = string(b)
+	}
+	_, err := db.Exec(`INSERT INTO competitions (slug, name, start_at, end_at, starting_cash, stocks, rules) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(slug) DO UPDATE SET name = excluded.name, start_at = excluded.start_at, end_at = excluded.end_at,
+		starting_cash = excluded.starting_cash, stocks = excluded.stocks, rules = excluded.rules`,
+		req.Slug, req.Name, toDBTime(start), toDBTime(end), req.StartingCash, strings.Join(req.Stocks, ","), rules)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	_, _ = db.Exec("INSERT INTO admin_actions (stock_id, action, magnitude, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)",
+		"", fmt.Sprintf("competition %s saved", req.Slug), req.StartingCash.Float())
+	comp, err := getCompetition(req.Slug)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, comp)
+}
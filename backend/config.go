@@ -17,47 +17,14 @@ func loadConfig() {
 		log.Fatalf("Failed to read config.json: %v", err)
 	}
 
-	var cfg Config
+	cfg := Config{CircuitBreakers: defaultBreakerSettings(), Options: defaultOptionSettings(), Rates: defaultRateSettings(), Fees: defaultFeeSettings()}
 	if err := json.Unmarshal(file, &cfg); err != nil {
 		log.Fatalf("Failed to parse config.json: %v", err)
 	}
 
-	loc, err := time.LoadLocation("Asia/Kolkata")
-	if err != nil {
-		loc = time.FixedZone("IST", 5*3600+30*60)
-	}
-
-	parseTime := func(value string) (time.Time, error) {
-		v := strings.TrimSpace(value)
-		if v == "" {
-			return time.Time{}, fmt.Errorf("empty time value")
-		}
-
-		layouts := []string{
-			"01/02/06 15:04",
-			"01/02/2006 15:04",
-			"2006-01-02 15:04",
-			"2006-01-02T15:04:05",
-			"2006-01-02T15:04:05Z07:00",
-			time.RFC3339,
-		}
-		// interesting sometimes its failing, adding fallbacks..
-		for _, layout := range layouts {
-			if t, err := time.ParseInLocation(layout, v, loc); err == nil {
-				return t, nil
-			}
-		}
-		if t, err := time.Parse(time.RFC3339, v); err == nil {
-
-			return t.In(loc), nil
-		}
-
-		return time.Time{}, fmt.Errorf("unsupported time format: %s", value)
-	}
-
 	var errStart, errEnd error
-	compStart, errStart = parseTime(cfg.Start)
-	compEnd, errEnd = parseTime(cfg.End)
+	compStart, errStart = parseCompTime(cfg.Start)
+	compEnd, errEnd = parseCompTime(cfg.End)
 
 	if errStart != nil || errEnd != nil {
 		log.Fatalf("Failed to parse start/end time: %v %v", errStart, errEnd)
@@ -68,6 +35,71 @@ func loadConfig() {
 
 	log.Printf("Competition Start (UTC): %s", compStart.Format(time.RFC3339))
 	log.Printf("Competition End   (UTC): %s", compEnd.Format(time.RFC3339))
+
+	if cfg.CircuitBreakers != nil {
+		breakers = cfg.CircuitBreakers
+	}
+	if cfg.Options != nil {
+		optionSettings = cfg.Options
+	}
+	if cfg.Rates != nil {
+		rateSettings = cfg.Rates
+	}
+	if cfg.Fees != nil {
+		feeSettings = cfg.Fees
+	}
+	if cfg.Rules != nil {
+		tradingRules = cfg.Rules
+	}
+	if cfg.LotMethod != "" {
+		defaultLotMethod = lotMethod(cfg.LotMethod)
+	}
+	if cfg.IdempotencyTTL > 0 {
+		idempotencyTTL = time.Duration(cfg.IdempotencyTTL * float64(time.Hour))
+	}
+	if cfg.MaxSlippage > 0 {
+		defaultMaxSlippage = cfg.MaxSlippage
+	}
+	shareDecimals = cfg.ShareDecimals
+	if shareDecimals < 0 {
+		shareDecimals = 0
+	} else if shareDecimals > maxQtyPlaces {
+		shareDecimals = maxQtyPlaces
+	}
+}
+
+// parses times written by admins (config, news schedule), anything without a zone is IST
+func parseCompTime(value string) (time.Time, error) {
+	loc, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		loc = time.FixedZone("IST", 5*3600+30*60)
+	}
+
+	v := strings.TrimSpace(value)
+	if v == "" {
+		return time.Time{}, fmt.Errorf("empty time value")
+	}
+
+	layouts := []string{
+		"01/02/06 15:04",
+		"01/02/2006 15:04",
+		"2006-01-02 15:04",
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04:05Z07:00",
+		time.RFC3339,
+	}
+	// interesting sometimes its failing, adding fallbacks..
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+
+		return t.In(loc), nil
+	}
+
+	return time.Time{}, fmt.Errorf("unsupported time format: %s", value)
 }
 
 // loads list of stocks
@@ -91,16 +123,50 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	now := time.Now().UTC()
-	open := now.After(compStart) && now.Before(compEnd)
+	// another competition reports its own schedule, main keeps the config.json times below
+	if comp, err := competitionFromRequest(r); err == nil && comp.Slug != mainCompetition {
+		now := time.Now().UTC()
+		writeJSON(w, map[string]interface{}{
+			"competition": comp.Slug,
+			"start":       comp.Start.Format(time.RFC3339),
+			"end":         comp.End.Format(time.RFC3339),
+			"now":         now.Format(time.RFC3339),
+			"open":        comp.open(now),
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(competitionStatus())
+}
+
+func isCompetitionOpen(now time.Time) bool {
+	return now.After(compStart) && now.Before(compEnd)
+}
 
-	resp := map[string]interface{}{
+func competitionStatus() map[string]interface{} {
+	now := time.Now().UTC()
+	return map[string]interface{}{
 		"start": compStart.Format(time.RFC3339),
 		"end":   compEnd.Format(time.RFC3339),
 		"now":   now.Format(time.RFC3339),
-		"open":  open,
+		"open":  isCompetitionOpen(now),
 	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+// pushes a status event to streaming clients whenever the competition opens or closes
+func statusWatcher() {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	wasOpen := isCompetitionOpen(time.Now().UTC())
+	for range ticker.C {
+		open := isCompetitionOpen(time.Now().UTC())
+		if open == wasOpen {
+			continue
+		}
+		wasOpen = open
+		status := competitionStatus()
+		status["type"] = "status"
+		publishEvent("status", status)
+	}
 }
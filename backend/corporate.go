@@ -0,0 +1,609 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dividends, splits and buybacks, scheduled by admins and run by corporateActionWatcher
+type CorporateAction struct {
+	ID          int64   `json:"id"`
+	StockID     string  `json:"stock_id"`
+	Kind        string  `json:"kind"`               // dividend, split or buyback
+	Amount      float64 `json:"amount,omitempty"`   // dividend per share, or the buyback price
+	RatioTo     int64   `json:"ratio_to,omitempty"` // split is ratio_to-for-ratio_from, 2:1 doubles the shares
+	RatioFrom   int64   `json:"ratio_from,omitempty"`
+	Fraction    float64 `json:"fraction,omitempty"` // part of every holding bought back, 0.1 = 10%
+	Impact      float64 `json:"impact,omitempty"`   // buyback only, price move started once it's done
+	RecordAt    string  `json:"record_at"`          // holders as of this moment are entitled, splits and buybacks happen here
+	PayAt       string  `json:"pay_at,omitempty"`   // dividend only, when the cash arrives
+	Status      string  `json:"status"`             // scheduled, recorded (dividend waiting to pay), done or cancelled
+	Holders     int     `json:"holders"`
+	Total       float64 `json:"total"` // cash paid out
+	Note        string  `json:"note,omitempty"`
+	ProcessedAt string  `json:"processed_at,omitempty"`
+	CreatedAt   string  `json:"created_at"`
+}
+
+// trades hold a read lock, anything that rewrites holdings takes the write lock so no trade
+// can price against pre-split shares or slip in between a record date snapshot
+var corpActionLock sync.RWMutex
+
+const corporateActionColumns = "id, stock_id, kind, amount, ratio_to, ratio_from, fraction, impact, record_at, pay_at, status, holders, total, note, processed_at, created_at"
+
+func scanCorporateAction(row rowScanner) (CorporateAction, error) {
+	var a CorporateAction
+	var amount, fraction, impact, total sql.NullFloat64
+	var ratioTo, ratioFrom, holders sql.NullInt64
+	var recordAt, payAt, note, processed, created sql.NullString
+	if err := row.Scan(&a.ID, &a.StockID, &a.Kind, &amount, &ratioTo, &ratioFrom, &fraction, &impact, &recordAt, &payAt, &a.Status, &holders, &total, &note, &processed, &created); err != nil {
+		return a, err
+	}
+	a.Amount = amount.Float64
+	a.RatioTo = ratioTo.Int64
+	a.RatioFrom = ratioFrom.Int64
+	a.Fraction = fraction.Float64
+	a.Impact = impact.Float64
+	a.Holders = int(holders.Int64)
+	a.Total = roundToTwo(total.Float64)
+	a.Note = nullToString(note)
+	if recordAt.Valid {
+		a.RecordAt = parseDBTimeToLocal(recordAt.String).Format(time.RFC3339)
+	}
+	if payAt.Valid {
+		a.PayAt = parseDBTimeToLocal(payAt.String).Format(time.RFC3339)
+	}
+	if processed.Valid {
+		a.ProcessedAt = parseDBTimeToLocal(processed.String).Format(time.RFC3339)
+	}
+	a.CreatedAt = nullToString(created)
+	return a, nil
+}
+
+func getCorporateAction(id int64) (CorporateAction, error) {
+	return scanCorporateAction(db.QueryRow("SELECT "+corporateActionColumns+" FROM corporate_actions WHERE id = ?", id))
+}
+
+// what an admin posts to /api/admin/corporate-actions
+type CorporateActionRequest struct {
+	StockID         string  `json:"stock_id"`
+	Kind            string  `json:"kind"`
+	Amount          float64 `json:"amount"`
+	Ratio           string  `json:"ratio"` // "2:1" forward, "1:4" reverse
+	Fraction        float64 `json:"fraction"`
+	Impact          float64 `json:"impact"`
+	RecordAt        string  `json:"record_at,omitempty"`
+	DelaySeconds    int     `json:"delay_seconds,omitempty"`
+	PayAt           string  `json:"pay_at,omitempty"`
+	PayDelaySeconds int     `json:"pay_delay_seconds,omitempty"` // after the record date
+	Note            string  `json:"note"`
+}
+
+func parseSplitRatio(s string) (int64, int64, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 {
+		return 0, 0, errors.New(`ratio must look like "2:1"`)
+	}
+	var to, from int64
+	if _, err := fmt.Sscan(parts[0], &to); err != nil {
+		return 0, 0, errors.New(`ratio must look like "2:1"`)
+	}
+	if _, err := fmt.Sscan(parts[1], &from); err != nil {
+		return 0, 0, errors.New(`ratio must look like "2:1"`)
+	}
+	if to <= 0 || from <= 0 || to > 1000 || from > 1000 || to == from {
+		return 0, 0, errors.New("ratio sides must be 1-1000 and differ")
+	}
+	return to, from, nil
+}
+
+func scheduleCorporateAction(req CorporateActionRequest, now time.Time) (int64, error) {
+	req.StockID = strings.ToUpper(strings.TrimSpace(req.StockID))
+	req.Kind = strings.ToLower(strings.TrimSpace(req.Kind))
+	if _, err := getStockPrice(req.StockID); err != nil {
+		return 0, errors.New("stock not found")
+	}
+	if isBasket(req.StockID) {
+		return 0, errors.New("baskets follow their components, corporate actions go on the stocks")
+	}
+
+	recordAt := now
+	if strings.TrimSpace(req.RecordAt) != "" {
+		t, err := parseCompTime(req.RecordAt)
+		if err != nil {
+			return 0, err
+		}
+		recordAt = t
+	} else if req.DelaySeconds > 0 {
+		recordAt = now.Add(time.Duration(req.DelaySeconds) * time.Second)
+	}
+
+	var amount, fraction, impact, ratioTo, ratioFrom, payAt interface{}
+	switch req.Kind {
+	case "dividend":
+		if req.Amount <= 0 {
+			return 0, errors.New("amount (per share) must be > 0")
+		}
+		pay := recordAt
+		if strings.TrimSpace(req.PayAt) != "" {
+			t, err := parseCompTime(req.PayAt)
+			if err != nil {
+				return 0, err
+			}
+			pay = t
+		} else if req.PayDelaySeconds > 0 {
+			pay = recordAt.Add(time.Duration(req.PayDelaySeconds) * time.Second)
+		}
+		if pay.Before(recordAt) {
+			return 0, errors.New("pay_at cant be before record_at")
+		}
+		amount, payAt = req.Amount, toDBTime(pay)
+	case "split":
+		to, from, err := parseSplitRatio(req.Ratio)
+		if err != nil {
+			return 0, err
+		}
+		ratioTo, ratioFrom = to, from
+	case "buyback":
+		if req.Amount <= 0 {
+			return 0, errors.New("amount (buyback price) must be > 0")
+		}
+		if req.Fraction <= 0 || req.Fraction > 1 {
+			return 0, errors.New("fraction must be between 0 and 1")
+		}
+		amount, fraction, impact = req.Amount, req.Fraction, clampImpact(req.Impact)
+	default:
+		return 0, errors.New("kind must be dividend, split or buyback")
+	}
+
+	res, err := db.Exec("INSERT INTO corporate_actions (stock_id, kind, amount, ratio_to, ratio_from, fraction, impact, record_at, pay_at, status, note) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 'scheduled', ?)",
+		req.StockID, req.Kind, amount, ratioTo, ratioFrom, fraction, impact, toDBTime(recordAt), payAt, strings.TrimSpace(req.Note))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+type holding struct {
+	userID int64
+	shares Qty
+	avg    float64
+}
+
+func holdersOf(tx *sql.Tx, stockID string) ([]holding, error) {
+	rows, err := tx.Query("SELECT user_id, shares, avg_price FROM portfolio WHERE stock_id = ? AND shares > 0 ORDER BY user_id ASC", stockID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []holding
+	for rows.Next() {
+		var h holding
+		if err := rows.Scan(&h.userID, &h.shares, &h.avg); err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+// record date of a dividend: snapshot who is entitled to what, the price goes ex-dividend right after
+func recordDividend(a CorporateAction) error {
+	corpActionLock.Lock()
+	defer corpActionLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	holders, err := holdersOf(tx, a.StockID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, h := range holders {
+		if _, err := tx.Exec("INSERT INTO corporate_entitlements (action_id, user_id, shares, amount) VALUES (?, ?, ?, ?)", a.ID, h.userID, h.shares, costOf(h.shares, a.Amount).Float()); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE corporate_actions SET status = 'recorded', holders = ? WHERE id = ?", len(holders), a.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// the cash leaves the company, so does that much of the share price
+	if price, err := getStockPrice(a.StockID); err == nil && price-a.Amount >= 0.01 {
+		applyPriceMoves(map[string]float64{a.StockID: math.Log((price - a.Amount) / price)}, 1, nil)
+	}
+	return nil
+}
+
+func payDividend(a CorporateAction) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	rows, err := tx.Query("SELECT user_id, shares, amount FROM corporate_entitlements WHERE action_id = ? AND paid_at IS NULL", a.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	type due struct {
+		userID int64
+		shares Qty
+		amount float64
+	}
+	var dues []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.userID, &d.shares, &d.amount); err == nil {
+			dues = append(dues, d)
+		}
+	}
+	rows.Close()
+
+	total := 0.0
+	for _, d := range dues {
+		// entitlements are in dollars, cash and the ledger are in cents
+		amount := moneyFromFloat(d.amount)
+		if _, err := tx.Exec("UPDATE users SET cash = cash + ? WHERE id = ?", amount, d.userID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := recordTransaction(tx, txnRow{UserID: d.userID, StockID: a.StockID, Action: "dividend", Shares: d.shares, Price: a.Amount, Amount: amount}); err != nil {
+			tx.Rollback()
+			return err
+		}
+		total += d.amount
+	}
+	if _, err := tx.Exec("UPDATE corporate_entitlements SET paid_at = CURRENT_TIMESTAMP WHERE action_id = ? AND paid_at IS NULL", a.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("UPDATE corporate_actions SET status = 'done', total = ?, processed_at = CURRENT_TIMESTAMP WHERE id = ?", total, a.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// scales every stored price of a stock by f, used by splits so charts dont show a fake crash
+func scalePriceHistory(stockID string, f float64) {
+	stocksLock.Lock()
+	for i := range stocks {
+		if stocks[i].ID == stockID {
+			stocks[i].Price *= f
+			stocks[i].Change *= f
+			// market cap stays the same, so indices dont see the split either
+			stocks[i].SharesOutstanding = int64(math.Round(sharesOutstanding(stocks[i]) / f))
+		}
+	}
+	changed := splitBasketComponents(stockID, f)
+	tickLock.Lock()
+	buf := tickBuffer[stockID]
+	for i := range buf {
+		buf[i].Open *= f
+		buf[i].High *= f
+		buf[i].Low *= f
+		buf[i].Close *= f
+	}
+	rh := rawTickHistory[stockID]
+	for i := range rh {
+		rh[i].Price *= f
+	}
+	if cur, ok := currentMinuteData[stockID]; ok {
+		cur.Open *= f
+		cur.High *= f
+		cur.Low *= f
+		cur.Close *= f
+	}
+	tickLock.Unlock()
+	updated := make([]Stock, len(stocks))
+	copy(updated, stocks)
+	stocksLock.Unlock()
+	saveBaskets(changed)
+	broadcastPrices(updated)
+}
+
+// ratio_to-for-ratio_from: shares go up, avg_price and the whole price history go down by the same factor.
+// fractional shares left over by a reverse split are paid out as cash at the new price
+func runSplit(a CorporateAction) error {
+	corpActionLock.Lock()
+	defer corpActionLock.Unlock()
+
+	price, err := getStockPrice(a.StockID)
+	if err != nil {
+		return err
+	}
+	f := float64(a.RatioFrom) / float64(a.RatioTo)
+	newPrice := price * f
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	holders, err := holdersOf(tx, a.StockID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	total := 0.0
+	for _, h := range holders {
+		exact := h.shares.mulFrac(a.RatioTo, a.RatioFrom)
+		shares := exact.floor()
+		cashInLieu := costOf(exact-shares, newPrice)
+		avg := h.avg * f
+		if err := splitLots(tx, h.userID, a.StockID, f, shares); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := syncHolding(tx, h.userID, a.StockID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if cashInLieu > 0 {
+			if _, err := tx.Exec("UPDATE users SET cash = cash + ? WHERE id = ?", cashInLieu, h.userID); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		// shares/price are the position after the split, amount is any cash in lieu
+		if _, err := recordTransaction(tx, txnRow{UserID: h.userID, StockID: a.StockID, Action: "split", Shares: shares, Price: avg, Amount: cashInLieu}); err != nil {
+			tx.Rollback()
+			return err
+		}
+		total += cashInLieu.Float()
+	}
+	if err := adjustOptionsForSplit(tx, a.StockID, f); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("UPDATE corporate_actions SET status = 'done', holders = ?, total = ?, amount = ?, processed_at = CURRENT_TIMESTAMP WHERE id = ?", len(holders), total, newPrice, a.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	scalePriceHistory(a.StockID, f)
+	return nil
+}
+
+// the company buys the same fraction of every holding at the buyback price
+func runBuyback(a CorporateAction) error {
+	corpActionLock.Lock()
+	defer corpActionLock.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	holders, err := holdersOf(tx, a.StockID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	total, sellers := 0.0, 0
+	for _, h := range holders {
+		sold := qtyFromFloat(h.shares.Float()*a.Fraction + 1e-9).floor()
+		if sold <= 0 {
+			continue
+		}
+		proceeds := costOf(sold, a.Amount)
+		if _, err := tx.Exec("UPDATE users SET cash = cash + ? WHERE id = ?", proceeds, h.userID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		txnID, err := recordTransaction(tx, txnRow{UserID: h.userID, StockID: a.StockID, Action: "buyback", Shares: sold, Price: a.Amount, Amount: proceeds})
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := closeLots(tx, h.userID, a.StockID, sold, a.Amount, 0, "", txnID, "buyback"); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := syncHolding(tx, h.userID, a.StockID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		total += proceeds.Float()
+		sellers++
+	}
+	if _, err := tx.Exec("UPDATE corporate_actions SET status = 'done', holders = ?, total = ?, processed_at = CURRENT_TIMESTAMP WHERE id = ?", sellers, total, a.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	submitMarketEvent("buyback", fmt.Sprintf("%s buyback", a.StockID), []string{a.StockID}, a.Impact)
+	return nil
+}
+
+func runCorporateAction(a CorporateAction) error {
+	var err error
+	switch {
+	case a.Kind == "dividend" && a.Status == "scheduled":
+		if err = recordDividend(a); err == nil {
+			a.Status = "recorded"
+			if t := parseDBTimeToLocal(a.PayAt); !t.After(time.Now()) {
+				err = payDividend(a)
+			}
+		}
+	case a.Kind == "dividend" && a.Status == "recorded":
+		err = payDividend(a)
+	case a.Kind == "split":
+		err = runSplit(a)
+	case a.Kind == "buyback":
+		err = runBuyback(a)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if done, err := getCorporateAction(a.ID); err == nil {
+		publishEvent("corporate_action", map[string]interface{}{
+			"type":   "corporate_action",
+			"action": done,
+			"time":   time.Now().Local().Format(time.RFC3339),
+		})
+	}
+	return nil
+}
+
+// runs record dates, splits, buybacks and dividend payments once they're due, started from main
+func corporateActionWatcher() {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		ts := toDBTime(now)
+		rows, err := db.Query("SELECT "+corporateActionColumns+" FROM corporate_actions WHERE (status = 'scheduled' AND record_at <= ?) OR (status = 'recorded' AND pay_at <= ?) ORDER BY record_at ASC, id ASC", ts, ts)
+		if err != nil {
+			continue
+		}
+		var due []CorporateAction
+		for rows.Next() {
+			if a, err := scanCorporateAction(rows); err == nil {
+				due = append(due, a)
+			}
+		}
+		rows.Close()
+		for _, a := range due {
+			if err := runCorporateAction(a); err != nil {
+				log.Printf("corporate action %d (%s %s) failed: %v", a.ID, a.Kind, a.StockID, err)
+			}
+		}
+	}
+}
+
+// GET /api/admin/corporate-actions?stock=&status=, POST schedules one:
+// {"stock_id": "APEX", "kind": "dividend", "amount": 0.5, "delay_seconds": 60, "pay_delay_seconds": 300}
+// {"stock_id": "APEX", "kind": "split", "ratio": "2:1", "record_at": "2025-01-01 10:00"}
+// {"stock_id": "APEX", "kind": "buyback", "amount": 55, "fraction": 0.1, "impact": 0.03}
+func adminCorporateActionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		list, err := listCorporateActions(r.URL.Query().Get("stock"), r.URL.Query().Get("status"))
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, list)
+
+	case http.MethodPost:
+		var req CorporateActionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		id, err := scheduleCorporateAction(req, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a, _ := getCorporateAction(id)
+		_, _ = db.Exec("INSERT INTO admin_actions (stock_id, action, magnitude, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)", a.StockID, a.Kind, a.Amount)
+		publishEvent("corporate_action", map[string]interface{}{
+			"type":   "corporate_action",
+			"action": a,
+			"time":   time.Now().Local().Format(time.RFC3339),
+		})
+		writeJSON(w, a)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func listCorporateActions(stock, status string) ([]CorporateAction, error) {
+	f := &newsFilter{}
+	if s := strings.ToUpper(strings.TrimSpace(stock)); s != "" {
+		f.add("stock_id = ?", s)
+	}
+	if s := strings.ToLower(strings.TrimSpace(status)); s != "" {
+		f.add("status = ?", s)
+	}
+	rows, err := db.Query("SELECT "+corporateActionColumns+" FROM corporate_actions"+f.sql()+" ORDER BY record_at DESC, id DESC LIMIT 200", f.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []CorporateAction{}
+	for rows.Next() {
+		a, err := scanCorporateAction(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// POST /api/admin/corporate-actions/cancel {"id": 3}, only before the record date
+func cancelCorporateActionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	res, err := db.Exec("UPDATE corporate_actions SET status = 'cancelled', processed_at = CURRENT_TIMESTAMP WHERE id = ? AND status = 'scheduled'", req.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "action not found or already past its record date", http.StatusBadRequest)
+		return
+	}
+	a, _ := getCorporateAction(req.ID)
+	writeJSON(w, a)
+}
+
+// GET /api/corporate-actions?stock=APEX, upcoming and past actions for everyone to see
+func corporateActionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	list, err := listCorporateActions(r.URL.Query().Get("stock"), r.URL.Query().Get("status"))
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, list)
+}
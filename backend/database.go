@@ -3,6 +3,7 @@ package main
 import (
 	"database/sql"
 	"log"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
@@ -11,12 +12,17 @@ var db *sql.DB
 
 func initDB() {
 	var err error
-	db, err = sql.Open("sqlite", "stocksim.db")
+	// background writers (watchers, interest) overlap with trades now, wait for the lock instead of failing right away
+	db, err = sql.Open("sqlite", "stocksim.db?_pragma=busy_timeout(5000)&_txlock=immediate")
 	if err != nil {
 		log.Fatal("Failed to open database:", err)
 	}
 
 	createTables()
+	migrateLots()
+	migrateMoney()
+	migrateEscrow()
+	migrateLedger()
 	log.Println("Database initialized")
 }
 
@@ -25,7 +31,7 @@ func createTables() {
     CREATE TABLE IF NOT EXISTS users (
         id INTEGER PRIMARY KEY AUTOINCREMENT,
         school_code TEXT UNIQUE NOT NULL,
-        cash REAL DEFAULT 10000.0,
+        cash INTEGER DEFAULT 1000000, -- cents
         team_id INTEGER,
         created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
         FOREIGN KEY(team_id) REFERENCES teams(id)
@@ -46,6 +52,7 @@ func createTables() {
         stock_id TEXT,
         shares INTEGER,
         avg_price REAL,
+        cost INTEGER, -- cents, what the open lots cost including fees
         FOREIGN KEY(user_id) REFERENCES users(id)
     );`
 
@@ -83,9 +90,404 @@ func createTables() {
         created_at DATETIME DEFAULT CURRENT_TIMESTAMP
     );`
 
-	for _, table := range []string{teams, users, portfolio, transactions, news, adminActions} {
+	// queued news, the scheduler publishes these once fire_at passes (fire_at = publish_at + jitter)
+	newsSchedule := `
+	CREATE TABLE IF NOT EXISTS news_schedule (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		title TEXT,
+		content TEXT,
+		affected_stock TEXT,
+		affected_sector TEXT,
+		source TEXT,
+		impact REAL,
+		impact_jitter REAL DEFAULT 0,
+		publish_at DATETIME,
+		fire_at DATETIME,
+		jitter_seconds INTEGER DEFAULT 0,
+		repeat_every_seconds INTEGER DEFAULT 0,
+		repeat_remaining INTEGER DEFAULT 0,
+		occurrence INTEGER DEFAULT 1,
+		origin TEXT DEFAULT 'admin',
+		status TEXT DEFAULT 'pending',
+		news_id INTEGER,
+		error TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// seeded from data/newswebsites.json on first run, see seedNewsSources
+	newsSources := `
+	CREATE TABLE IF NOT EXISTS news_sources (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE COLLATE NOCASE NOT NULL,
+		credibility REAL DEFAULT 1.0,
+		bias REAL DEFAULT 0.0,
+		reach REAL DEFAULT 1.0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// admin uploaded crash/rally/bubble scripts, definition is the stages as json
+	scenarios := `
+	CREATE TABLE IF NOT EXISTS scenarios (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT UNIQUE COLLATE NOCASE NOT NULL,
+		description TEXT,
+		definition TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// stocks listed, renamed or delisted at runtime, replayed over data/stocks.json on startup
+	stockListings := `
+	CREATE TABLE IF NOT EXISTS stock_listings (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		sector TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'listed',
+		price REAL NOT NULL,
+		listed_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		delisted_at DATETIME,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	ipos := `
+	CREATE TABLE IF NOT EXISTS ipos (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		stock_id TEXT NOT NULL,
+		name TEXT NOT NULL,
+		sector TEXT NOT NULL,
+		price REAL NOT NULL,
+		shares_offered INTEGER NOT NULL,
+		status TEXT NOT NULL DEFAULT 'open',
+		closes_at DATETIME NOT NULL,
+		allocated_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// escrow is the cash taken at subscribe time, whatever isnt allocated goes back as refunded. both in cents
+	ipoSubscriptions := `
+	CREATE TABLE IF NOT EXISTS ipo_subscriptions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		ipo_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		shares_requested INTEGER NOT NULL,
+		shares_allocated INTEGER,
+		escrow INTEGER NOT NULL DEFAULT 0,
+		refunded INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(ipo_id, user_id),
+		FOREIGN KEY(ipo_id) REFERENCES ipos(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);`
+
+	// dividends, splits and buybacks. amount is the dividend per share or buyback price (the post-split price once a split ran)
+	corporateActions := `
+	CREATE TABLE IF NOT EXISTS corporate_actions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		stock_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		amount REAL,
+		ratio_to INTEGER,
+		ratio_from INTEGER,
+		fraction REAL,
+		impact REAL,
+		record_at DATETIME NOT NULL,
+		pay_at DATETIME,
+		status TEXT NOT NULL DEFAULT 'scheduled',
+		holders INTEGER DEFAULT 0,
+		total REAL DEFAULT 0,
+		note TEXT,
+		processed_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// who held what on a dividend's record date
+	corporateEntitlements := `
+	CREATE TABLE IF NOT EXISTS corporate_entitlements (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		action_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		shares INTEGER NOT NULL,
+		amount REAL NOT NULL,
+		paid_at DATETIME,
+		FOREIGN KEY(action_id) REFERENCES corporate_actions(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);`
+
+	// etfs, components is a json list of {stock_id, units} per basket unit
+	baskets := `
+	CREATE TABLE IF NOT EXISTS baskets (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		description TEXT,
+		components TEXT NOT NULL,
+		cash REAL DEFAULT 0,
+		status TEXT NOT NULL DEFAULT 'active',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// european options, strike/multiplier get adjusted by splits so the symbol keeps the listing strike
+	optionContracts := `
+	CREATE TABLE IF NOT EXISTS option_contracts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		symbol TEXT UNIQUE NOT NULL,
+		stock_id TEXT NOT NULL,
+		type TEXT NOT NULL,
+		strike REAL NOT NULL,
+		multiplier REAL NOT NULL DEFAULT 100,
+		expires_at DATETIME NOT NULL,
+		status TEXT NOT NULL DEFAULT 'open',
+		settle_price REAL,
+		settled_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// long only, avg_price is the premium per share
+	optionPositions := `
+	CREATE TABLE IF NOT EXISTS option_positions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		contract_id INTEGER NOT NULL,
+		contracts INTEGER NOT NULL,
+		avg_price REAL NOT NULL,
+		UNIQUE(user_id, contract_id),
+		FOREIGN KEY(user_id) REFERENCES users(id),
+		FOREIGN KEY(contract_id) REFERENCES option_contracts(id)
+	);`
+
+	// bonds and bills, last_coupon_at moves forward as coupons get paid
+	bonds := `
+	CREATE TABLE IF NOT EXISTS bonds (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		face REAL NOT NULL DEFAULT 100,
+		coupon_rate REAL NOT NULL DEFAULT 0,
+		coupon_seconds INTEGER NOT NULL DEFAULT 0,
+		issued_at DATETIME NOT NULL,
+		matures_at DATETIME NOT NULL,
+		last_coupon_at DATETIME NOT NULL,
+		status TEXT NOT NULL DEFAULT 'open',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	bondPositions := `
+	CREATE TABLE IF NOT EXISTS bond_positions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		bond_id TEXT NOT NULL,
+		quantity INTEGER NOT NULL,
+		avg_price REAL NOT NULL,
+		UNIQUE(user_id, bond_id),
+		FOREIGN KEY(user_id) REFERENCES users(id),
+		FOREIGN KEY(bond_id) REFERENCES bonds(id)
+	);`
+
+	// every admin rate move, the newest one is the current rate
+	rateChanges := `
+	CREATE TABLE IF NOT EXISTS rate_changes (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		bond_yield REAL NOT NULL,
+		cash_rate REAL NOT NULL,
+		note TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// one row per buy (or ipo allocation), shares counts down as lots get sold
+	taxLots := `
+	CREATE TABLE IF NOT EXISTS tax_lots (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		stock_id TEXT NOT NULL,
+		shares INTEGER NOT NULL,
+		original_shares INTEGER NOT NULL,
+		cost_price REAL NOT NULL,
+		source TEXT NOT NULL DEFAULT 'buy',
+		opened_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		closed_at DATETIME,
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);`
+
+	// the realized side, one row per lot a sell (or buyback/delist) touched
+	lotSales := `
+	CREATE TABLE IF NOT EXISTS lot_sales (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		lot_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		stock_id TEXT NOT NULL,
+		transaction_id INTEGER,
+		shares INTEGER NOT NULL,
+		cost_price REAL NOT NULL,
+		sale_price REAL NOT NULL,
+		fee REAL NOT NULL DEFAULT 0,
+		realized_pl REAL NOT NULL,
+		reason TEXT NOT NULL,
+		sold_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(lot_id) REFERENCES tax_lots(id),
+		FOREIGN KEY(user_id) REFERENCES users(id)
+	);`
+
+	// double entry ledger, a journal per movement with entries that sum to zero per asset (see ledger.go)
+	ledgerJournals := `
+	CREATE TABLE IF NOT EXISTS ledger_journals (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		user_id INTEGER,
+		transaction_id INTEGER,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	ledgerEntries := `
+	CREATE TABLE IF NOT EXISTS ledger_entries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		journal_id INTEGER NOT NULL,
+		account TEXT NOT NULL,
+		asset TEXT NOT NULL,
+		amount INTEGER NOT NULL, -- cents for CASH, millionths of a share otherwise
+		FOREIGN KEY(journal_id) REFERENCES ledger_journals(id)
+	);`
+
+	// stored trade responses for Idempotency-Key replays, status 0 while the request is still running
+	idempotencyKeys := `
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		user_id INTEGER NOT NULL,
+		key TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		status INTEGER NOT NULL DEFAULT 0,
+		content_type TEXT,
+		body TEXT,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY(user_id, key)
+	);`
+
+	// seasons, each with its own accounts in users (see competitions.go)
+	competitions := `
+	CREATE TABLE IF NOT EXISTS competitions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		slug TEXT UNIQUE NOT NULL,
+		name TEXT NOT NULL,
+		start_at DATETIME NOT NULL,
+		end_at DATETIME NOT NULL,
+		starting_cash INTEGER NOT NULL, -- cents
+		stocks TEXT, -- comma separated, empty is every stock
+		rules TEXT, -- json TradingRules, empty is config.json's
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	for _, table := range []string{teams, users, portfolio, transactions, news, adminActions, newsSchedule, newsSources, scenarios, stockListings, ipos, ipoSubscriptions, corporateActions, corporateEntitlements, baskets, optionContracts, optionPositions, bonds, bondPositions, rateChanges, taxLots, lotSales, ledgerJournals, ledgerEntries, idempotencyKeys, competitions} {
 		if _, err := db.Exec(table); err != nil {
 			log.Fatal("Failed to create table:", err)
 		}
 	}
+
+	// columns added after the first release, CREATE TABLE IF NOT EXISTS wont add them to old dbs
+	ensureColumn("news", "urgency", "TEXT")
+	ensureColumn("news_schedule", "urgency", "TEXT")
+	ensureColumn("news_schedule", "competition", "TEXT")
+	ensureColumn("news", "kind", "TEXT DEFAULT 'news'")
+	ensureColumn("news", "status", "TEXT DEFAULT 'published'")
+	ensureColumn("news", "credibility", "REAL")
+	ensureColumn("news", "rumor_state", "TEXT")
+	ensureColumn("news", "confirm_impact", "REAL")
+	ensureColumn("news", "deny_impact", "REAL")
+	ensureColumn("news", "applied_impact", "REAL")
+	ensureColumn("news", "note", "TEXT")
+	ensureColumn("news", "updated_at", "DATETIME")
+	ensureColumn("news", "schedule_id", "INTEGER") // the news_schedule row that published it, NULL for anything posted by hand
+	// cash that moved, in cents. the trade value for buys/sells, the whole cash effect for everything else
+	ensureColumn("transactions", "amount", "INTEGER")
+	ensureColumn("transactions", "fee", "INTEGER")
+	ensureColumn("transactions", "realized_pl", "INTEGER")
+	ensureColumn("portfolio", "cost", "INTEGER")
+	ensureColumn("users", "competition_id", "INTEGER")
+	ensureColumn("users", "owner_id", "INTEGER")      // the login an enrolment account belongs to, NULL for logins
+	ensureColumn("users", "starting_cash", "INTEGER") // cents, NULL is the default 10000
+
+	// a scheduled item publishes at most once, even when a crash puts it back in the queue
+	if _, err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS news_schedule_id ON news(schedule_id)"); err != nil {
+		log.Fatal("Failed to create news index:", err)
+	}
+
+	// the ledger only ever grows
+	for _, stmt := range []string{
+		`CREATE INDEX IF NOT EXISTS ledger_entries_account ON ledger_entries(account, asset)`,
+		`CREATE TRIGGER IF NOT EXISTS ledger_entries_no_update BEFORE UPDATE ON ledger_entries BEGIN SELECT RAISE(ABORT, 'ledger entries are immutable'); END;`,
+		`CREATE TRIGGER IF NOT EXISTS ledger_entries_no_delete BEFORE DELETE ON ledger_entries BEGIN SELECT RAISE(ABORT, 'ledger entries are immutable'); END;`,
+		`CREATE TRIGGER IF NOT EXISTS ledger_journals_no_update BEFORE UPDATE ON ledger_journals BEGIN SELECT RAISE(ABORT, 'ledger journals are immutable'); END;`,
+		`CREATE TRIGGER IF NOT EXISTS ledger_journals_no_delete BEFORE DELETE ON ledger_journals BEGIN SELECT RAISE(ABORT, 'ledger journals are immutable'); END;`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatal("Failed to create ledger guards:", err)
+		}
+	}
+	ensureColumn("stock_listings", "shares_outstanding", "INTEGER")
+
+	createNewsSearchIndex()
+}
+
+// fts5 index over news title/content, kept in sync by triggers so nothing else has to know about it
+func createNewsSearchIndex() {
+	var existing int
+	_ = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'news_fts'").Scan(&existing)
+
+	stmts := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS news_fts USING fts5(title, content, content='news', content_rowid='id');`,
+		`CREATE TRIGGER IF NOT EXISTS news_fts_insert AFTER INSERT ON news BEGIN
+			INSERT INTO news_fts(rowid, title, content) VALUES (new.id, new.title, new.content);
+		END;`,
+		`CREATE TRIGGER IF NOT EXISTS news_fts_delete AFTER DELETE ON news BEGIN
+			INSERT INTO news_fts(news_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+		END;`,
+		`CREATE TRIGGER IF NOT EXISTS news_fts_update AFTER UPDATE OF title, content ON news BEGIN
+			INSERT INTO news_fts(news_fts, rowid, title, content) VALUES ('delete', old.id, old.title, old.content);
+			INSERT INTO news_fts(rowid, title, content) VALUES (new.id, new.title, new.content);
+		END;`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatal("Failed to create news search index:", err)
+		}
+	}
+
+	// dbs from before the index existed already have news in them
+	if existing == 0 {
+		if _, err := db.Exec("INSERT INTO news_fts(news_fts) VALUES ('rebuild')"); err != nil {
+			log.Println("Failed to build news search index:", err)
+		}
+	}
+}
+
+func ensureColumn(table, column, def string) {
+	rows, err := db.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		log.Fatal("Failed to read table info:", err)
+	}
+	found := false
+	for rows.Next() {
+		var cid int
+		var name, ctype string
+		var notNull, pk int
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
+			log.Fatal("Failed to scan table info:", err)
+		}
+		if name == column {
+			found = true
+		}
+	}
+	rows.Close()
+	if found {
+		return
+	}
+	if _, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + def); err != nil {
+		log.Fatal("Failed to add column:", err)
+	}
+}
+
+// same layout sqlite uses for CURRENT_TIMESTAMP, so stored times compare as plain strings
+const dbTimeLayout = "2006-01-02 15:04:05"
+
+func toDBTime(t time.Time) string {
+	return t.UTC().Format(dbTimeLayout)
 }
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// one scheduled price path (news, admin tank/spike, ...). the engine owns every running one
+type MarketEvent struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"` // news, admin, scenario or revert
+	Label     string    `json:"label"`
+	Stocks    []string  `json:"stocks"`
+	Impact    float64   `json:"impact"` // total move over the whole path, 0.1 = +10%
+	Duration  float64   `json:"duration_seconds"`
+	Elapsed   float64   `json:"elapsed_seconds"` // negative while waiting for a delayed start
+	Progress  float64   `json:"progress"`
+	Status    string    `json:"status"` // waiting, running, halted, done or cancelled
+	Paused    bool      `json:"paused"`
+	Run       int64     `json:"run,omitempty"` // scenario run that queued it
+	CreatedAt time.Time `json:"created_at"`
+
+	logTarget float64            // ln(1+impact)
+	applied   float64            // how much of logTarget is already on the price
+	noise     map[string]float64 // per stock wiggle on top of the eased path, back to 0 by the end
+}
+
+var (
+	engineLock     sync.Mutex
+	engineNextID   int64
+	engineEvents   []*MarketEvent
+	engineFinished []*MarketEvent
+	maxFinished    = 50
+
+	engineTick     = 250 * time.Millisecond
+	lastEngineTick = map[string]time.Time{} // per stock, so chart ticks arent appended every frame, guarded by stocksLock
+)
+
+func easeInOut(x float64) float64 {
+	return 0.5 - 0.5*math.Cos(math.Pi*x)
+}
+
+func randNorm() float64 {
+	u1 := rand.Float64()
+	u2 := rand.Float64()
+	if u1 < 1e-12 {
+		u1 = 1e-12
+	}
+	return math.Sqrt(-2.0*math.Log(u1)) * math.Cos(2.0*math.Pi*u2)
+}
+
+// same pacing the old hand animation had, bigger moves take longer
+func eventDuration(impact float64) time.Duration {
+	steps := int(40 + math.Round(math.Abs(impact)*160))
+	if steps < 30 {
+		steps = 30
+	}
+	if steps > 900 {
+		steps = 900
+	}
+	return time.Duration(steps) * 900 * time.Millisecond
+}
+
+// everything needed to queue one price path
+type eventSpec struct {
+	Kind     string
+	Label    string
+	Stocks   []string
+	Impact   float64
+	Duration time.Duration
+	Delay    time.Duration // engine time, doesnt count down while paused
+	Run      int64
+}
+
+// queues a price path on the given stocks, returns the event id (0 if there is nothing to move)
+func submitMarketEvent(kind, label string, ids []string, impact float64) int64 {
+	return submitEvent(eventSpec{Kind: kind, Label: label, Stocks: ids, Impact: impact, Duration: eventDuration(impact)})
+}
+
+func submitEvent(spec eventSpec) int64 {
+	impact, d := spec.Impact, spec.Duration
+	if len(spec.Stocks) == 0 || impact == 0 {
+		return 0
+	}
+	// as said in before comment T-T
+	if impact > 4.0 {
+		impact = 4.0
+	} else if impact < -4.0 {
+		impact = -4.0
+	}
+	multiplier := 1.0 + impact
+	if multiplier <= 0.0001 {
+		multiplier = 0.0001
+	}
+	if d < time.Second {
+		d = time.Second
+	}
+
+	engineLock.Lock()
+	defer engineLock.Unlock()
+	engineNextID++
+	ev := &MarketEvent{
+		ID:        engineNextID,
+		Kind:      spec.Kind,
+		Label:     spec.Label,
+		Stocks:    append([]string{}, spec.Stocks...),
+		Impact:    impact,
+		Duration:  d.Seconds(),
+		Elapsed:   -spec.Delay.Seconds(),
+		Status:    "running",
+		Run:       spec.Run,
+		CreatedAt: time.Now(),
+		logTarget: math.Log(multiplier),
+		noise:     map[string]float64{},
+	}
+	if ev.Elapsed < 0 {
+		ev.Status = "waiting"
+	}
+	engineEvents = append(engineEvents, ev)
+	return ev.ID
+}
+
+// stops an event where it is. with revert the part already applied is walked back by a short new event
+func cancelMarketEvent(id int64, revert bool) (*MarketEvent, bool) {
+	engineLock.Lock()
+	var ev *MarketEvent
+	for i, e := range engineEvents {
+		if e.ID == id {
+			ev = e
+			engineEvents = append(engineEvents[:i], engineEvents[i+1:]...)
+			break
+		}
+	}
+	if ev == nil {
+		engineLock.Unlock()
+		return nil, false
+	}
+	ev.Status = "cancelled"
+	finishEvent(ev)
+	applied := ev.applied
+	noise := ev.noise
+	ev.noise = map[string]float64{}
+	out := *ev
+	engineLock.Unlock()
+
+	// noise is per stock, take it off right away so a cancelled event leaves nothing half-done behind
+	if len(noise) > 0 {
+		applyPriceMoves(noise, -1, nil)
+	}
+	if revert && applied != 0 {
+		submitEvent(eventSpec{Kind: "revert", Label: "revert #" + strconv.FormatInt(id, 10), Stocks: out.Stocks, Impact: math.Exp(-applied) - 1, Duration: 5 * time.Second})
+	}
+	return &out, true
+}
+
+// pauses or resumes every unfinished event of a scenario run, returns how many changed
+func setRunPaused(run int64, paused bool) int {
+	engineLock.Lock()
+	defer engineLock.Unlock()
+	n := 0
+	for _, ev := range engineEvents {
+		if ev.Run == run && ev.Paused != paused {
+			ev.Paused = paused
+			n++
+		}
+	}
+	return n
+}
+
+// ids of the unfinished events a scenario run still has queued
+func runEventIDs(run int64) []int64 {
+	engineLock.Lock()
+	defer engineLock.Unlock()
+	var ids []int64
+	for _, ev := range engineEvents {
+		if ev.Run == run {
+			ids = append(ids, ev.ID)
+		}
+	}
+	return ids
+}
+
+// takes a delisted stock out of every event, events left with no stocks are cancelled
+func dropStockFromEvents(id string) {
+	engineLock.Lock()
+	defer engineLock.Unlock()
+	running := engineEvents[:0]
+	for _, ev := range engineEvents {
+		// a fresh slice, listMarketEvents hands out copies that still share the old one
+		kept := make([]string, 0, len(ev.Stocks))
+		for _, s := range ev.Stocks {
+			if s != id {
+				kept = append(kept, s)
+			}
+		}
+		ev.Stocks = kept
+		delete(ev.noise, id)
+		if len(kept) == 0 {
+			ev.Status = "cancelled"
+			finishEvent(ev)
+			continue
+		}
+		running = append(running, ev)
+	}
+	engineEvents = running
+}
+
+// caller holds engineLock
+func finishEvent(ev *MarketEvent) {
+	engineFinished = append(engineFinished, ev)
+	if len(engineFinished) > maxFinished {
+		engineFinished = engineFinished[len(engineFinished)-maxFinished:]
+	}
+}
+
+func listMarketEvents(includeFinished bool) []MarketEvent {
+	engineLock.Lock()
+	defer engineLock.Unlock()
+	all := engineEvents
+	if includeFinished {
+		all = append(append([]*MarketEvent{}, engineEvents...), engineFinished...)
+	}
+	out := make([]MarketEvent, 0, len(all))
+	for _, e := range all {
+		c := *e
+		c.Elapsed = math.Round(c.Elapsed*100) / 100
+		c.Progress = math.Round(c.Progress*1000) / 1000
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID > out[j].ID })
+	return out
+}
+
+// advances every running event by dt and returns the log move each stock gets this frame.
+// events on the same stock just add up, so nothing overwrites anything
+func stepMarketEvents(dt float64) (map[string]float64, map[string]float64) {
+	halted := haltedSymbols()
+	engineLock.Lock()
+	defer engineLock.Unlock()
+
+	moves := map[string]float64{}
+	sizes := map[string]float64{} // biggest impact touching each stock, drives tick volume
+	running := engineEvents[:0]
+	for _, ev := range engineEvents {
+		// halted stocks dont trade, so their events wait it out instead of jumping once trading resumes
+		if ev.Paused || touchesHalted(ev, halted) {
+			if !ev.Paused && ev.Elapsed >= 0 {
+				ev.Status = "halted"
+			}
+			running = append(running, ev)
+			continue
+		}
+		ev.Elapsed += dt
+		if ev.Elapsed < 0 {
+			running = append(running, ev)
+			continue
+		}
+		ev.Status = "running"
+		frac := ev.Elapsed / ev.Duration
+		done := frac >= 1
+		if done {
+			frac = 1
+		}
+		ev.Progress = frac
+
+		target := ev.logTarget * easeInOut(frac)
+		delta := target - ev.applied
+		ev.applied = target
+
+		noiseScale := 0.0006 + 0.006*math.Abs(ev.Impact)
+		for _, id := range ev.Stocks {
+			prev := ev.noise[id]
+			next := 0.0
+			if !done {
+				// mean reverting so it wanders around the path instead of drifting off it, and shrinks toward the end
+				next = prev*0.85 + randNorm()*noiseScale*0.35*(1-frac)
+			}
+			ev.noise[id] = next
+			moves[id] += delta + next - prev
+			if a := math.Abs(ev.Impact); a > sizes[id] {
+				sizes[id] = a
+			}
+		}
+
+		if done {
+			ev.Status = "done"
+			finishEvent(ev)
+			continue
+		}
+		running = append(running, ev)
+	}
+	engineEvents = running
+	return moves, sizes
+}
+
+func touchesHalted(ev *MarketEvent, halted map[string]bool) bool {
+	if halted[marketHaltSymbol] {
+		return true
+	}
+	for _, id := range ev.Stocks {
+		if halted[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// multiplies prices by exp(sign*move). relative to the current price, so the random walk in priceTicker keeps going underneath
+func applyPriceMoves(moves map[string]float64, sign float64, sizes map[string]float64) {
+	if len(moves) == 0 {
+		return
+	}
+	now := time.Now()
+	stocksLock.Lock()
+	for i := range stocks {
+		m, ok := moves[stocks[i].ID]
+		if !ok || stocks[i].Kind == basketKind {
+			continue
+		}
+		prev := stocks[i].Price
+		price := prev * math.Exp(sign*m)
+		if price < 0.01 {
+			price = 0.01
+		}
+		stocks[i].Price = price
+		stocks[i].Change = price - prev
+
+		if now.Sub(lastEngineTick[stocks[i].ID]) > 1200*time.Millisecond || math.Abs(price-prev) > prev*0.002 {
+			vol := int64(400 + rand.Intn(3000) + int(math.Round(700.0*sizes[stocks[i].ID])))
+			appendTick(stocks[i].ID, price, vol)
+			lastEngineTick[stocks[i].ID] = now
+		}
+	}
+	repriceBaskets()
+	updated := make([]Stock, len(stocks))
+	copy(updated, stocks)
+	stocksLock.Unlock()
+	// broadcast it for the world to fear
+	broadcastPrices(updated)
+}
+
+// single goroutine that moves every running event forward, started from main
+func marketEngine() {
+	ticker := time.NewTicker(engineTick)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for now := range ticker.C {
+		dt := now.Sub(last).Seconds()
+		last = now
+		moves, sizes := stepMarketEvents(dt)
+		applyPriceMoves(moves, 1, sizes)
+	}
+}
+
+// GET /api/admin/events, ?all=1 also lists the last finished/cancelled ones
+func marketEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	all := r.URL.Query().Get("all") == "1" || r.URL.Query().Get("all") == "true"
+	writeJSON(w, listMarketEvents(all))
+}
+
+// POST /api/admin/events/cancel {"id": 3, "revert": true}
+func cancelMarketEventHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID     int64 `json:"id"`
+		Revert bool  `json:"revert"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	ev, ok := cancelMarketEvent(req.ID, req.Revert)
+	if !ok {
+		http.Error(w, "event not found or already finished", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"status": "cancelled", "event": ev})
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// namespace for the structured stock/sector/impact fields in the xml feeds
+const feedNamespace = "urn:stocksim:news"
+
+const feedTitle = "StockSim News"
+
+// bullish/bearish plus how big, same buckets as urgency
+func impactCategory(impact float64) string {
+	direction := "neutral"
+	if impact > 0 {
+		direction = "bullish"
+	} else if impact < 0 {
+		direction = "bearish"
+	}
+	return direction + "-" + urgencyForImpact(impact)
+}
+
+func feedBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if p := r.Header.Get("X-Forwarded-Proto"); p != "" {
+		scheme = strings.TrimSpace(strings.Split(p, ",")[0])
+	}
+	return scheme + "://" + r.Host
+}
+
+func newsItemURL(base string, n NewsOut) string {
+	return base + "/news.html#news-" + strconv.FormatInt(n.ID, 10)
+}
+
+// sector of the article, a stock article carries its stock's sector in category
+func newsSector(n NewsOut) string {
+	if n.AffectedSector != "" {
+		return n.AffectedSector
+	}
+	return n.Category
+}
+
+// same filters as /api/news, retracted articles are left out unless ?status= asks for them
+func loadFeedNews(w http.ResponseWriter, r *http.Request) ([]NewsOut, bool) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return nil, false
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	f, err := newsFilterFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if r.URL.Query().Get("status") == "" {
+		f.add("COALESCE(status, 'published') != 'retracted'")
+	}
+	items, err := queryNews(f, parseNewsLimit(r.URL.Query().Get("limit")))
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return items, true
+}
+
+func writeXMLFeed(w http.ResponseWriter, contentType string, v interface{}) {
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	_, _ = w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	_ = enc.Encode(v)
+}
+
+type rssFeed struct {
+	XMLName  xml.Name   `xml:"rss"`
+	Version  string     `xml:"version,attr"`
+	Stocksim string     `xml:"xmlns:stocksim,attr"`
+	Channel  rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title          string   `xml:"title"`
+	Link           string   `xml:"link"`
+	GUID           rssGUID  `xml:"guid"`
+	PubDate        string   `xml:"pubDate"`
+	Description    string   `xml:"description"`
+	Categories     []string `xml:"category"`
+	Stock          string   `xml:"stocksim:stock,omitempty"`
+	Sector         string   `xml:"stocksim:sector,omitempty"`
+	Impact         float64  `xml:"stocksim:impact"`
+	ImpactCategory string   `xml:"stocksim:impactCategory"`
+	Status         string   `xml:"stocksim:status"`
+}
+
+// /api/news.rss
+func newsRSSHandler(w http.ResponseWriter, r *http.Request) {
+	items, ok := loadFeedNews(w, r)
+	if !ok {
+		return
+	}
+	base := feedBaseURL(r)
+
+	feed := rssFeed{
+		Version:  "2.0",
+		Stocksim: feedNamespace,
+		Channel: rssChannel{
+			Title:         feedTitle,
+			Link:          base + "/news.html",
+			Description:   "Market news from the StockSim competition",
+			LastBuildDate: time.Now().Format(time.RFC1123Z),
+		},
+	}
+	for _, n := range items {
+		var cats []string
+		if n.AffectedStock != "" {
+			cats = append(cats, n.AffectedStock)
+		}
+		if s := newsSector(n); s != "" {
+			cats = append(cats, s)
+		}
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:          n.Title,
+			Link:           newsItemURL(base, n),
+			GUID:           rssGUID{IsPermaLink: "false", Value: "stocksim-news-" + strconv.FormatInt(n.ID, 10)},
+			PubDate:        parseDBTimeToLocal(n.PublishedAt).Format(time.RFC1123Z),
+			Description:    n.Content,
+			Categories:     cats,
+			Stock:          n.AffectedStock,
+			Sector:         newsSector(n),
+			Impact:         n.Impact,
+			ImpactCategory: impactCategory(n.Impact),
+			Status:         n.Status,
+		})
+	}
+	writeXMLFeed(w, "application/rss+xml", feed)
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Stocksim string      `xml:"xmlns:stocksim,attr"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomCategory struct {
+	Term   string `xml:"term,attr"`
+	Scheme string `xml:"scheme,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID             string         `xml:"id"`
+	Title          string         `xml:"title"`
+	Updated        string         `xml:"updated"`
+	Published      string         `xml:"published"`
+	Link           atomLink       `xml:"link"`
+	Author         *atomAuthor    `xml:"author,omitempty"`
+	Summary        string         `xml:"summary"`
+	Categories     []atomCategory `xml:"category"`
+	Stock          string         `xml:"stocksim:stock,omitempty"`
+	Sector         string         `xml:"stocksim:sector,omitempty"`
+	Impact         float64        `xml:"stocksim:impact"`
+	ImpactCategory string         `xml:"stocksim:impactCategory"`
+	Status         string         `xml:"stocksim:status"`
+}
+
+// /api/news.atom
+func newsAtomHandler(w http.ResponseWriter, r *http.Request) {
+	items, ok := loadFeedNews(w, r)
+	if !ok {
+		return
+	}
+	base := feedBaseURL(r)
+
+	updated := time.Now()
+	if len(items) > 0 {
+		updated = parseDBTimeToLocal(items[0].PublishedAt)
+	}
+	feed := atomFeed{
+		Stocksim: feedNamespace,
+		ID:       feedNamespace + ":feed",
+		Title:    feedTitle,
+		Updated:  updated.Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: base + "/news.html", Rel: "alternate", Type: "text/html"},
+			{Href: base + "/api/news.atom", Rel: "self", Type: "application/atom+xml"},
+		},
+	}
+	for _, n := range items {
+		pub := parseDBTimeToLocal(n.PublishedAt).Format(time.RFC3339)
+		var cats []atomCategory
+		if n.AffectedStock != "" {
+			cats = append(cats, atomCategory{Term: n.AffectedStock, Scheme: feedNamespace + ":stock"})
+		}
+		if s := newsSector(n); s != "" {
+			cats = append(cats, atomCategory{Term: s, Scheme: feedNamespace + ":sector"})
+		}
+		cats = append(cats, atomCategory{Term: impactCategory(n.Impact), Scheme: feedNamespace + ":impact"})
+
+		e := atomEntry{
+			ID:             feedNamespace + ":" + strconv.FormatInt(n.ID, 10),
+			Title:          n.Title,
+			Updated:        pub,
+			Published:      pub,
+			Link:           atomLink{Href: newsItemURL(base, n), Rel: "alternate", Type: "text/html"},
+			Summary:        n.Content,
+			Categories:     cats,
+			Stock:          n.AffectedStock,
+			Sector:         newsSector(n),
+			Impact:         n.Impact,
+			ImpactCategory: impactCategory(n.Impact),
+			Status:         n.Status,
+		}
+		if n.Source != "" {
+			e.Author = &atomAuthor{Name: n.Source}
+		}
+		feed.Entries = append(feed.Entries, e)
+	}
+	writeXMLFeed(w, "application/atom+xml", feed)
+}
+
+// /api/news.json, JSON Feed 1.1. stock/sector/impact go in the _stocksim extension object
+func newsJSONFeedHandler(w http.ResponseWriter, r *http.Request) {
+	items, ok := loadFeedNews(w, r)
+	if !ok {
+		return
+	}
+	base := feedBaseURL(r)
+
+	out := make([]map[string]interface{}, 0, len(items))
+	for _, n := range items {
+		tags := []string{}
+		if n.AffectedStock != "" {
+			tags = append(tags, n.AffectedStock)
+		}
+		if s := newsSector(n); s != "" {
+			tags = append(tags, s)
+		}
+		item := map[string]interface{}{
+			"id":             strconv.FormatInt(n.ID, 10),
+			"url":            newsItemURL(base, n),
+			"title":          n.Title,
+			"content_text":   n.Content,
+			"date_published": parseDBTimeToLocal(n.PublishedAt).Format(time.RFC3339),
+			"tags":           tags,
+			"_stocksim": map[string]interface{}{
+				"stock":           n.AffectedStock,
+				"sector":          newsSector(n),
+				"impact":          n.Impact,
+				"impact_category": impactCategory(n.Impact),
+				"urgency":         n.Urgency,
+				"kind":            n.Kind,
+				"status":          n.Status,
+			},
+		}
+		if n.Source != "" {
+			item["authors"] = []map[string]string{{"name": n.Source}}
+		}
+		out = append(out, item)
+	}
+
+	w.Header().Set("Content-Type", "application/feed+json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(map[string]interface{}{
+		"version":       "https://jsonfeed.org/version/1.1",
+		"title":         feedTitle,
+		"home_page_url": base + "/news.html",
+		"feed_url":      base + "/api/news.json",
+		"items":         out,
+	})
+}
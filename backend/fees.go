@@ -0,0 +1,47 @@
+package main
+
+import "math"
+
+// "fees" in config.json, charged on every stock/etf trade
+type FeeSettings struct {
+	Mode    string    `json:"mode"` // none, flat, percent or tiered
+	Flat    float64   `json:"flat"`
+	Percent float64   `json:"percent"` // 0.001 = 0.1% of the trade value
+	Tiers   []FeeTier `json:"tiers"`
+	Minimum float64   `json:"minimum"` // no trade pays less than this, unless mode is none
+}
+
+// the first tier a trade's value fits under sets its fee, up_to 0 means no limit
+type FeeTier struct {
+	UpTo    float64 `json:"up_to"`
+	Percent float64 `json:"percent"`
+	Flat    float64 `json:"flat"`
+}
+
+func defaultFeeSettings() *FeeSettings {
+	return &FeeSettings{Mode: "none"}
+}
+
+var feeSettings = defaultFeeSettings()
+
+// commission on a trade worth value
+func tradeFee(value Money) Money {
+	notional := value.Float()
+	fee := 0.0
+	switch feeSettings.Mode {
+	case "flat":
+		fee = feeSettings.Flat
+	case "percent":
+		fee = notional * feeSettings.Percent
+	case "tiered":
+		for _, t := range feeSettings.Tiers {
+			if t.UpTo <= 0 || notional <= t.UpTo {
+				fee = t.Flat + notional*t.Percent
+				break
+			}
+		}
+	default:
+		return 0
+	}
+	return moneyFromFloat(math.Max(fee, feeSettings.Minimum))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// a retried or double clicked trade sends the same Idempotency-Key header, the first response is kept
+// against (user, key) and replayed instead of trading again. "idempotency_ttl_hours" in config.json
+var idempotencyTTL = 24 * time.Hour
+
+// a pending key not refreshed for this long belongs to a request that never finished (crash, restart), it
+// can be reused. a running request keeps refreshing its key, however long it waits on a lock
+const idempotencyPendingTimeout = time.Minute
+
+const maxIdempotencyKeyLen = 255
+
+// keeps a copy of what the handler writes so it can be stored
+type idempotentWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (iw *idempotentWriter) WriteHeader(code int) {
+	iw.status = code
+	iw.ResponseWriter.WriteHeader(code)
+}
+
+func (iw *idempotentWriter) Write(b []byte) (int, error) {
+	iw.body.Write(b)
+	return iw.ResponseWriter.Write(b)
+}
+
+func requestHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// claims key for the user before the handler runs. false means a response was already written: the stored
+// one for a replay, or an error when the key is in flight or was used for a different request
+func claimIdempotencyKey(w http.ResponseWriter, userID int64, key string, body []byte) bool {
+	if len(key) > maxIdempotencyKeyLen {
+		http.Error(w, "Idempotency-Key is too long", http.StatusBadRequest)
+		return false
+	}
+	now := time.Now()
+	_, _ = db.Exec("DELETE FROM idempotency_keys WHERE created_at < ? OR (status = 0 AND created_at < ?)",
+		toDBTime(now.Add(-idempotencyTTL)), toDBTime(now.Add(-idempotencyPendingTimeout)))
+
+	hash := requestHash(body)
+	res, err := db.Exec("INSERT OR IGNORE INTO idempotency_keys (user_id, key, request_hash, status, created_at) VALUES (?, ?, ?, 0, ?)",
+		userID, key, hash, toDBTime(now))
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return false
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return true
+	}
+
+	var storedHash, contentType string
+	var status int
+	var stored []byte
+	err = db.QueryRow("SELECT request_hash, status, COALESCE(content_type, ''), COALESCE(body, '') FROM idempotency_keys WHERE user_id = ? AND key = ?", userID, key).
+		Scan(&storedHash, &status, &contentType, &stored)
+	if err == sql.ErrNoRows {
+		// expired between the insert and the select, let the client retry
+		http.Error(w, "Idempotency-Key expired, try again", http.StatusConflict)
+		return false
+	} else if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return false
+	}
+	if storedHash != hash {
+		http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+		return false
+	}
+	if status == 0 {
+		http.Error(w, "a request with this Idempotency-Key is still running", http.StatusConflict)
+		return false
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(status)
+	w.Write(stored)
+	return false
+}
+
+// pushes the pending key's created_at forward until the returned func is called, so a request queued behind
+// the account or corporate action lock never looks abandoned and a retry cant run the trade a second time
+func holdIdempotencyKey(userID int64, key string) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(idempotencyPendingTimeout / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_, _ = db.Exec("UPDATE idempotency_keys SET created_at = ? WHERE user_id = ? AND key = ? AND status = 0", toDBTime(time.Now()), userID, key)
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// stores the response for replays. server errors arent kept so the same key can be retried
+func finishIdempotencyKey(userID int64, key string, iw *idempotentWriter) {
+	if iw.status >= http.StatusInternalServerError {
+		_, _ = db.Exec("DELETE FROM idempotency_keys WHERE user_id = ? AND key = ?", userID, key)
+		return
+	}
+	_, _ = db.Exec("UPDATE idempotency_keys SET status = ?, content_type = ?, body = ? WHERE user_id = ? AND key = ?",
+		iw.status, iw.Header().Get("Content-Type"), iw.body.String(), userID, key)
+}
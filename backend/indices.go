@@ -0,0 +1,284 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// computed market/sector indices, not tradable. ^MARKET is cap weighted, ^MARKET-EW equal weighted,
+// every sector gets the same pair (^TECHNOLOGY, ^TECHNOLOGY-EW, ...)
+type IndexValue struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	Method    string  `json:"method"` // cap or equal
+	Sector    string  `json:"sector,omitempty"`
+	Value     float64 `json:"value"`
+	Change    float64 `json:"change"`     // since the last update
+	ChangePct float64 `json:"change_pct"` // since the server started
+	Members   int     `json:"members"`
+}
+
+type indexState struct {
+	IndexValue
+	base float64
+	last map[string]float64 // market cap of every member at the last update
+}
+
+const indexBase = 1000.0
+
+// stocks without shares_outstanding in stocks.json count as this many for cap weighting
+const defaultSharesOutstanding = 1000000
+
+var (
+	indexLock    sync.Mutex
+	indexStates  = map[string]*indexState{}
+	indexHistory = map[string][]Tick{}
+)
+
+func sharesOutstanding(s Stock) float64 {
+	if s.SharesOutstanding > 0 {
+		return float64(s.SharesOutstanding)
+	}
+	return defaultSharesOutstanding
+}
+
+func sectorIndexID(sector string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(sector) {
+		if (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			b.WriteRune(c)
+		}
+	}
+	return "^" + b.String()
+}
+
+type indexDef struct {
+	id, name, method, sector string
+}
+
+// every index the current universe has, with the stocks in it. baskets arent members, they'd count their components twice
+func indexMembers(data []Stock) map[indexDef][]Stock {
+	out := map[indexDef][]Stock{}
+	for _, s := range data {
+		if s.Kind == basketKind {
+			continue
+		}
+		sector := strings.TrimSpace(s.Sector)
+		defs := []indexDef{
+			{"^MARKET", "Market (cap weighted)", "cap", ""},
+			{"^MARKET-EW", "Market (equal weighted)", "equal", ""},
+		}
+		if sector != "" {
+			id := sectorIndexID(sector)
+			defs = append(defs, indexDef{id, sector + " (cap weighted)", "cap", sector}, indexDef{id + "-EW", sector + " (equal weighted)", "equal", sector})
+		}
+		for _, d := range defs {
+			out[d] = append(out[d], s)
+		}
+	}
+	return out
+}
+
+// chain links every index onto the new prices, only stocks present last time count for the move
+// so listings/delistings dont make the index jump. splits keep market cap the same so they dont either
+func updateIndices(data []Stock) []IndexValue {
+	now := time.Now().Local()
+	indexLock.Lock()
+	defer indexLock.Unlock()
+
+	seen := map[string]bool{}
+	for def, members := range indexMembers(data) {
+		st, ok := indexStates[def.id]
+		if !ok {
+			st = &indexState{IndexValue: IndexValue{ID: def.id, Value: indexBase}, base: indexBase}
+			indexStates[def.id] = st
+		}
+		st.Name, st.Method, st.Sector = def.name, def.method, def.sector
+		seen[def.id] = true
+
+		caps := make(map[string]float64, len(members))
+		num, den, ratios, n := 0.0, 0.0, 0.0, 0
+		for _, s := range members {
+			c := s.Price * sharesOutstanding(s)
+			caps[s.ID] = c
+			if prev, ok := st.last[s.ID]; ok && prev > 0 {
+				num += c
+				den += prev
+				ratios += c / prev
+				n++
+			}
+		}
+		prevValue := st.Value
+		if n > 0 {
+			if def.method == "cap" {
+				st.Value *= num / den
+			} else {
+				st.Value *= ratios / float64(n)
+			}
+		}
+		st.last = caps
+		st.Members = len(members)
+		st.Change = st.Value - prevValue
+		appendIndexTick(def.id, st.Value, now)
+	}
+	// a sector that lost all its stocks keeps its last value, just with no members
+	for id, st := range indexStates {
+		if !seen[id] {
+			st.Members = 0
+			st.Change = 0
+			st.last = nil
+		}
+	}
+	return indexSnapshotLocked()
+}
+
+// caller holds indexLock
+func indexSnapshotLocked() []IndexValue {
+	out := make([]IndexValue, 0, len(indexStates))
+	for _, st := range indexStates {
+		v := st.IndexValue
+		v.ChangePct = roundToTwo((st.Value/st.base - 1) * 100)
+		v.Value = roundToFour(v.Value)
+		v.Change = roundToFour(v.Change)
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		// market first, then sectors alphabetically
+		mi, mj := strings.HasPrefix(out[i].ID, "^MARKET"), strings.HasPrefix(out[j].ID, "^MARKET")
+		if mi != mj {
+			return mi
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+func indexSnapshot() []IndexValue {
+	indexLock.Lock()
+	defer indexLock.Unlock()
+	return indexSnapshotLocked()
+}
+
+// caller holds indexLock. one bar per minute, same as the stock charts
+func appendIndexTick(id string, value float64, now time.Time) {
+	buf := indexHistory[id]
+	if n := len(buf); n > 0 && buf[n-1].Time.Unix()/60 == now.Unix()/60 {
+		last := &buf[n-1]
+		last.High = math.Max(last.High, value)
+		last.Low = math.Min(last.Low, value)
+		last.Close = value
+		return
+	}
+	buf = append(buf, Tick{Time: now, Open: value, High: value, Low: value, Close: value})
+	if len(buf) > maxTicksPerStock {
+		buf = buf[len(buf)-maxTicksPerStock:]
+	}
+	indexHistory[id] = buf
+}
+
+// backfills index history from the generated stock history so the charts arent empty on start,
+// every index is at indexBase right now and the past is scaled from there. runs after initTicks
+func initIndices() {
+	stocksLock.Lock()
+	data := make([]Stock, len(stocks))
+	copy(data, stocks)
+	stocksLock.Unlock()
+
+	tickLock.Lock()
+	bars := map[string]map[int64]Tick{}
+	for _, s := range data {
+		m := map[int64]Tick{}
+		for _, t := range tickBuffer[s.ID] {
+			m[t.Time.Unix()/60] = t
+		}
+		bars[s.ID] = m
+	}
+	tickLock.Unlock()
+
+	indexLock.Lock()
+	for def, members := range indexMembers(data) {
+		minutes := map[int64]time.Time{}
+		for _, s := range members {
+			for k, t := range bars[s.ID] {
+				minutes[k] = t.Time
+			}
+		}
+		keys := make([]int64, 0, len(minutes))
+		for k := range minutes {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+		var hist []Tick
+		for _, k := range keys {
+			// high/low of a sum isnt the sum of highs, but it's close enough for a chart
+			var o, h, l, c, den float64
+			n := 0
+			for _, s := range members {
+				t, ok := bars[s.ID][k]
+				if !ok || s.Price <= 0 {
+					continue
+				}
+				w := sharesOutstanding(s)
+				if def.method == "equal" {
+					w = 1 / s.Price
+				}
+				o += t.Open * w
+				h += t.High * w
+				l += t.Low * w
+				c += t.Close * w
+				den += s.Price * w
+				n++
+			}
+			if n == 0 || den <= 0 {
+				continue
+			}
+			f := indexBase / den
+			hist = append(hist, Tick{Time: minutes[k], Open: o * f, High: h * f, Low: l * f, Close: c * f})
+		}
+		indexHistory[def.id] = hist
+	}
+	indexLock.Unlock()
+	updateIndices(data)
+}
+
+// GET /api/indices, current value of every index
+func indicesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	writeJSON(w, indexSnapshot())
+}
+
+// /api/history?stock=^MARKET ends up here
+func indexHistoryOut(id string, points int) ([]map[string]interface{}, bool) {
+	indexLock.Lock()
+	buf, ok := indexHistory[id]
+	cpy := make([]Tick, len(buf))
+	copy(cpy, buf)
+	indexLock.Unlock()
+	if !ok {
+		return nil, false
+	}
+	if len(cpy) > points {
+		cpy = cpy[len(cpy)-points:]
+	}
+	out := make([]map[string]interface{}, 0, len(cpy))
+	for _, t := range cpy {
+		out = append(out, map[string]interface{}{
+			"time":   t.Time.Format(time.RFC3339),
+			"open":   roundToFour(t.Open),
+			"high":   roundToFour(t.High),
+			"low":    roundToFour(t.Low),
+			"close":  roundToFour(t.Close),
+			"volume": 0,
+			"price":  roundToFour(t.Close),
+		})
+	}
+	return out, true
+}
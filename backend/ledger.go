@@ -0,0 +1,376 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// double entry: every cash or share movement is a journal whose entries add up to zero per asset.
+// user:<id> and escrow:<id> belong to users, the other side is market (trades), fees, issuer:<symbol>
+// (ipos, dividends, buybacks, splits), treasury (bonds), bank (interest) or capital (starting cash,
+// admin adjustments). entries are never changed or deleted, triggers refuse it
+const ledgerCash = "CASH"
+
+type ledgerLeg struct {
+	account string
+	asset   string // CASH or a stock symbol
+	amount  int64  // cents for CASH, millionths of a share for stocks
+}
+
+func userAccount(id int64) string     { return fmt.Sprintf("user:%d", id) }
+func escrowAccount(id int64) string   { return fmt.Sprintf("escrow:%d", id) }
+func issuerAccount(sym string) string { return "issuer:" + sym }
+
+func cashLegs(from, to string, m Money) []ledgerLeg {
+	return []ledgerLeg{{from, ledgerCash, -int64(m)}, {to, ledgerCash, int64(m)}}
+}
+
+func shareLegs(from, to, symbol string, q Qty) []ledgerLeg {
+	return []ledgerLeg{{from, symbol, -int64(q)}, {to, symbol, int64(q)}}
+}
+
+// writes one journal, refusing anything that doesnt balance. zero legs are dropped
+func postJournal(tx *sql.Tx, kind string, userID, transactionID int64, groups ...[]ledgerLeg) error {
+	var legs []ledgerLeg
+	sums := map[string]int64{}
+	for _, g := range groups {
+		for _, l := range g {
+			if l.amount == 0 {
+				continue
+			}
+			legs = append(legs, l)
+			sums[l.asset] += l.amount
+		}
+	}
+	for asset, sum := range sums {
+		if sum != 0 {
+			return fmt.Errorf("%s journal doesnt balance: %s off by %d", kind, asset, sum)
+		}
+	}
+	if len(legs) == 0 {
+		return nil
+	}
+	var txnID interface{}
+	if transactionID > 0 {
+		txnID = transactionID
+	}
+	res, err := tx.Exec("INSERT INTO ledger_journals (kind, user_id, transaction_id) VALUES (?, ?, ?)", kind, userID, txnID)
+	if err != nil {
+		return err
+	}
+	journalID, _ := res.LastInsertId()
+	for _, l := range legs {
+		if _, err := tx.Exec("INSERT INTO ledger_entries (journal_id, account, asset, amount) VALUES (?, ?, ?, ?)", journalID, l.account, l.asset, l.amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ledgerBalance(tx *sql.Tx, account, asset string) int64 {
+	var n sql.NullInt64
+	_ = tx.QueryRow("SELECT SUM(amount) FROM ledger_entries WHERE account = ? AND asset = ?", account, asset).Scan(&n)
+	return n.Int64
+}
+
+// a transactions row, amount and fee are what moved in cash
+type txnRow struct {
+	UserID  int64
+	StockID string
+	Action  string
+	Shares  Qty
+	Price   float64
+	Amount  Money
+	Fee     Money
+}
+
+// the journal behind a transactions row
+func ledgerLegsFor(tx *sql.Tx, t txnRow) [][]ledgerLeg {
+	user := userAccount(t.UserID)
+	issuer := issuerAccount(t.StockID)
+	switch t.Action {
+	case "buy":
+		return [][]ledgerLeg{cashLegs(user, "market", t.Amount), cashLegs(user, "fees", t.Fee), shareLegs("market", user, t.StockID, t.Shares)}
+	case "sell":
+		return [][]ledgerLeg{cashLegs("market", user, t.Amount), cashLegs(user, "fees", t.Fee), shareLegs(user, "market", t.StockID, t.Shares)}
+	case "ipo":
+		return [][]ledgerLeg{cashLegs(escrowAccount(t.UserID), issuer, t.Amount), shareLegs(issuer, user, t.StockID, t.Shares)}
+	case "delist":
+		return [][]ledgerLeg{cashLegs("market", user, t.Amount), shareLegs(user, "market", t.StockID, t.Shares)}
+	case "buyback":
+		return [][]ledgerLeg{cashLegs(issuer, user, t.Amount), shareLegs(user, issuer, t.StockID, t.Shares)}
+	case "split":
+		// the row holds the position after the split, the issuer makes up the difference
+		held := Qty(ledgerBalance(tx, user, t.StockID))
+		return [][]ledgerLeg{shareLegs(issuer, user, t.StockID, t.Shares-held), cashLegs(issuer, user, t.Amount)}
+	case "dividend":
+		return [][]ledgerLeg{cashLegs(issuer, user, t.Amount)}
+	case "option_buy":
+		return [][]ledgerLeg{cashLegs(user, "market", t.Amount)}
+	case "bond_buy":
+		return [][]ledgerLeg{cashLegs(user, "treasury", t.Amount)}
+	case "bond_sell", "coupon", "bond_redeem":
+		return [][]ledgerLeg{cashLegs("treasury", user, t.Amount)}
+	case "interest":
+		return [][]ledgerLeg{cashLegs("bank", user, t.Amount)}
+	case "adjustment":
+		return [][]ledgerLeg{cashLegs("capital", user, t.Amount)}
+	}
+	// option sells and settlements
+	return [][]ledgerLeg{cashLegs("market", user, t.Amount)}
+}
+
+// inserts the transactions row and posts its journal in the same db transaction
+func recordTransaction(tx *sql.Tx, t txnRow) (int64, error) {
+	res, err := tx.Exec("INSERT INTO transactions(user_id, stock_id, action, shares, price, amount, fee) VALUES(?,?,?,?,?,?,?)",
+		t.UserID, t.StockID, t.Action, t.Shares, t.Price, t.Amount, t.Fee)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := res.LastInsertId()
+	if err := postJournal(tx, t.Action, t.UserID, id, ledgerLegsFor(tx, t)...); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// dbs from before the ledger: opening balances, ipo escrow and then every transactions row in order.
+// whatever old float drift there was shows up in the reconciliation afterwards
+func migrateLedger() {
+	var journals, users int
+	_ = db.QueryRow("SELECT COUNT(*) FROM ledger_journals").Scan(&journals)
+	_ = db.QueryRow("SELECT COUNT(*) FROM users").Scan(&users)
+	if journals > 0 || users == 0 {
+		return
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal("Failed to backfill ledger:", err)
+	}
+	fail := func(err error) {
+		tx.Rollback()
+		log.Fatal("Failed to backfill ledger:", err)
+	}
+
+	var ids []int64
+	rows, err := tx.Query("SELECT id FROM users ORDER BY id ASC")
+	if err != nil {
+		fail(err)
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	for _, id := range ids {
+		if err := postJournal(tx, "opening", id, 0, cashLegs("capital", userAccount(id), startingCash)); err != nil {
+			fail(err)
+		}
+	}
+
+	type sub struct {
+		userID           int64
+		escrow, refunded Money
+	}
+	var subs []sub
+	rows, err = tx.Query("SELECT user_id, escrow, COALESCE(refunded, 0) FROM ipo_subscriptions ORDER BY id ASC")
+	if err != nil {
+		fail(err)
+	}
+	for rows.Next() {
+		var s sub
+		if err := rows.Scan(&s.userID, &s.escrow, &s.refunded); err == nil {
+			subs = append(subs, s)
+		}
+	}
+	rows.Close()
+	for _, s := range subs {
+		if err := postJournal(tx, "ipo_escrow", s.userID, 0, cashLegs(userAccount(s.userID), escrowAccount(s.userID), s.escrow)); err != nil {
+			fail(err)
+		}
+		if err := postJournal(tx, "ipo_refund", s.userID, 0, cashLegs(escrowAccount(s.userID), userAccount(s.userID), s.refunded)); err != nil {
+			fail(err)
+		}
+	}
+
+	type row struct {
+		id int64
+		t  txnRow
+	}
+	var txns []row
+	rows, err = tx.Query("SELECT id, user_id, stock_id, action, shares, price, amount, fee FROM transactions ORDER BY id ASC")
+	if err != nil {
+		fail(err)
+	}
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.t.UserID, &r.t.StockID, &r.t.Action, &r.t.Shares, &r.t.Price, &r.t.Amount, &r.t.Fee); err != nil {
+			rows.Close()
+			fail(err)
+		}
+		txns = append(txns, r)
+	}
+	rows.Close()
+	for _, r := range txns {
+		if err := postJournal(tx, r.t.Action, r.t.UserID, r.id, ledgerLegsFor(tx, r.t)...); err != nil {
+			fail(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal("Failed to backfill ledger:", err)
+	}
+	log.Printf("Backfilled the ledger for %d users from %d transactions", len(ids), len(txns))
+}
+
+type LedgerEntry struct {
+	JournalID     int64   `json:"journal_id"`
+	Kind          string  `json:"kind"`
+	TransactionID *int64  `json:"transaction_id,omitempty"`
+	Account       string  `json:"account"`
+	Asset         string  `json:"asset"`
+	Amount        float64 `json:"amount"` // dollars for CASH, shares otherwise
+	CreatedAt     string  `json:"created_at"`
+}
+
+// GET /api/admin/ledger?user_id=1 (&limit=200), a user's entries with balances per asset
+func adminLedgerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	var userID int64
+	if _, err := fmt.Sscan(r.URL.Query().Get("user_id"), &userID); err != nil || userID <= 0 {
+		http.Error(w, "user_id required", http.StatusBadRequest)
+		return
+	}
+	limit := 200
+	if l := r.URL.Query().Get("limit"); l != "" {
+		fmt.Sscan(l, &limit)
+	}
+	accounts := []interface{}{userAccount(userID), escrowAccount(userID)}
+
+	rows, err := db.Query(`SELECT j.id, j.kind, j.transaction_id, e.account, e.asset, e.amount, j.created_at
+		FROM ledger_entries e JOIN ledger_journals j ON j.id = e.journal_id
+		WHERE e.account IN (?, ?) ORDER BY e.id DESC LIMIT ?`, append(accounts, limit)...)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	entries := []LedgerEntry{}
+	for rows.Next() {
+		var e LedgerEntry
+		var txnID sql.NullInt64
+		var amount int64
+		if err := rows.Scan(&e.JournalID, &e.Kind, &txnID, &e.Account, &e.Asset, &amount, &e.CreatedAt); err != nil {
+			http.Error(w, "db scan error", http.StatusInternalServerError)
+			return
+		}
+		if txnID.Valid {
+			e.TransactionID = &txnID.Int64
+		}
+		e.Amount = ledgerAmount(e.Asset, amount)
+		entries = append(entries, e)
+	}
+
+	balances := map[string]map[string]float64{}
+	brows, err := db.Query("SELECT account, asset, SUM(amount) FROM ledger_entries WHERE account IN (?, ?) GROUP BY account, asset", accounts...)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer brows.Close()
+	for brows.Next() {
+		var account, asset string
+		var sum int64
+		if err := brows.Scan(&account, &asset, &sum); err != nil || sum == 0 {
+			continue
+		}
+		if balances[account] == nil {
+			balances[account] = map[string]float64{}
+		}
+		balances[account][asset] = ledgerAmount(asset, sum)
+	}
+	writeJSON(w, map[string]interface{}{"user_id": userID, "balances": balances, "entries": entries})
+}
+
+func ledgerAmount(asset string, amount int64) float64 {
+	if asset == ledgerCash {
+		return Money(amount).Float()
+	}
+	return Qty(amount).Float()
+}
+
+// POST /api/admin/adjust {"user_id": 1, "amount": -25.5, "note": "refund double charge"}, the only way
+// to change someones cash by hand. goes through the ledger like everything else
+func adminAdjustHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		UserID int64  `json:"user_id"`
+		Amount Money  `json:"amount"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, badJSON(err), http.StatusBadRequest)
+		return
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if req.Amount == 0 || req.Note == "" {
+		http.Error(w, "amount and note required", http.StatusBadRequest)
+		return
+	}
+	cash, err := adjustCash(req.UserID, req.Amount)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, _ = db.Exec("INSERT INTO admin_actions (stock_id, action, magnitude, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)",
+		ledgerCash, fmt.Sprintf("adjust user %d: %s", req.UserID, req.Note), req.Amount.Float())
+	writeJSON(w, map[string]interface{}{"status": "ok", "user_id": req.UserID, "amount": req.Amount, "cash": cash})
+}
+
+func adjustCash(userID int64, amount Money) (Money, error) {
+	defer lockAccount(userID)()
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	var cash Money
+	if err := tx.QueryRow("SELECT cash FROM users WHERE id = ?", userID).Scan(&cash); err != nil {
+		tx.Rollback()
+		return 0, errors.New("user not found")
+	}
+	if cash+amount < 0 {
+		tx.Rollback()
+		return 0, errors.New("cash cant go below zero")
+	}
+	if _, err := tx.Exec("UPDATE users SET cash = cash + ? WHERE id = ?", amount, userID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if _, err := recordTransaction(tx, txnRow{UserID: userID, StockID: ledgerCash, Action: "adjustment", Amount: amount}); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return cash + amount, tx.Commit()
+}
@@ -0,0 +1,972 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// universe changes made at runtime, replayed over data/stocks.json on startup
+type StockListing struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	Sector     string  `json:"sector"`
+	Status     string  `json:"status"` // listed or delisted
+	Price      float64 `json:"price"`  // listing price, or the cash-out price once delisted
+	ListedAt   string  `json:"listed_at,omitempty"`
+	DelistedAt string  `json:"delisted_at,omitempty"`
+}
+
+type IPO struct {
+	ID              int64   `json:"id"`
+	StockID         string  `json:"stock_id"`
+	Name            string  `json:"name"`
+	Sector          string  `json:"sector"`
+	Price           float64 `json:"price"`
+	SharesOffered   int64   `json:"shares_offered"`
+	SharesRequested int64   `json:"shares_requested"`
+	Subscribers     int     `json:"subscribers"`
+	Status          string  `json:"status"` // open, allocated or cancelled
+	ClosesAt        string  `json:"closes_at"`
+	AllocatedAt     string  `json:"allocated_at,omitempty"`
+	MySubscription  *int64  `json:"my_subscription,omitempty"`
+	MyAllocation    *int64  `json:"my_allocation,omitempty"`
+}
+
+func validSymbol(id string) bool {
+	if len(id) == 0 || len(id) > 8 {
+		return false
+	}
+	for _, c := range id {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// a symbol can only ever be used once, so old transactions never point at the wrong company
+func symbolTaken(id string) bool {
+	if _, err := getStockPrice(id); err == nil {
+		return true
+	}
+	var n int
+	_ = db.QueryRow("SELECT (SELECT COUNT(*) FROM stock_listings WHERE id = ?) + (SELECT COUNT(*) FROM ipos WHERE stock_id = ? AND status != 'cancelled') + (SELECT COUNT(*) FROM baskets WHERE id = ?) + (SELECT COUNT(*) FROM bonds WHERE id = ?)", id, id, id, id).Scan(&n)
+	return n > 0
+}
+
+// adds a stock to the live universe with a one point history, stocksLock -> tickLock like everywhere else
+func addStockToUniverse(s Stock) {
+	now := time.Now().Local()
+	stocksLock.Lock()
+	tickLock.Lock()
+	stocks = append(stocks, s)
+	tickBuffer[s.ID] = []Tick{{Time: now, Open: s.Price, High: s.Price, Low: s.Price, Close: s.Price}}
+	rawTickHistory[s.ID] = []RawTickEvent{{Price: s.Price, Time: now}}
+	tickLock.Unlock()
+	updated := make([]Stock, len(stocks))
+	copy(updated, stocks)
+	stocksLock.Unlock()
+	broadcastPrices(updated)
+}
+
+// takes a stock out of memory everywhere it lives, returns its last price
+func removeStockFromUniverse(id string) (float64, bool) {
+	dropStockFromEvents(id)
+	haltLock.Lock()
+	delete(halts, id)
+	delete(breakerResetAt, id)
+	haltLock.Unlock()
+
+	stocksLock.Lock()
+	tickLock.Lock()
+	last, found := 0.0, false
+	for i := range stocks {
+		if stocks[i].ID == id {
+			last, found = stocks[i].Price, true
+			stocks = append(stocks[:i], stocks[i+1:]...)
+			break
+		}
+	}
+	delete(lastEngineTick, id)
+	delete(tickBuffer, id)
+	delete(rawTickHistory, id)
+	delete(currentMinuteData, id)
+	tickLock.Unlock()
+	updated := make([]Stock, len(stocks))
+	copy(updated, stocks)
+	stocksLock.Unlock()
+	if found {
+		broadcastPrices(updated)
+	}
+	return last, found
+}
+
+// replays stock_listings over the json universe, runs before initTicks
+func applyStockListings() {
+	rows, err := db.Query("SELECT id, name, sector, status, price, COALESCE(shares_outstanding, 0) FROM stock_listings ORDER BY listed_at ASC")
+	if err != nil {
+		log.Printf("Failed to load stock listings: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l StockListing
+		var shares int64
+		if err := rows.Scan(&l.ID, &l.Name, &l.Sector, &l.Status, &l.Price, &shares); err != nil {
+			continue
+		}
+		idx := -1
+		for i := range stocks {
+			if stocks[i].ID == l.ID {
+				idx = i
+				break
+			}
+		}
+		switch {
+		case l.Status == "delisted" && idx >= 0:
+			stocks = append(stocks[:idx], stocks[idx+1:]...)
+		case l.Status == "listed" && idx >= 0:
+			stocks[idx].Name = l.Name
+			stocks[idx].Sector = l.Sector
+			if shares > 0 {
+				stocks[idx].SharesOutstanding = shares
+			}
+		case l.Status == "listed":
+			stocks = append(stocks, Stock{ID: l.ID, Name: l.Name, Sector: l.Sector, Price: l.Price, SharesOutstanding: shares})
+		}
+	}
+	log.Printf("Stock universe: %d stocks", len(stocks))
+}
+
+// stock_listings keeps one row per symbol that differs from data/stocks.json
+func saveListing(tx *sql.Tx, s Stock, status string, price float64) error {
+	_, err := tx.Exec(`INSERT INTO stock_listings (id, name, sector, status, price, shares_outstanding) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET name = excluded.name, sector = excluded.sector, status = excluded.status, price = excluded.price, shares_outstanding = excluded.shares_outstanding,
+		delisted_at = CASE WHEN excluded.status = 'delisted' THEN CURRENT_TIMESTAMP ELSE delisted_at END, updated_at = CURRENT_TIMESTAMP`,
+		s.ID, s.Name, s.Sector, status, price, s.SharesOutstanding)
+	return err
+}
+
+func listNewStock(s Stock) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := saveListing(tx, s, "listed", s.Price); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	addStockToUniverse(s)
+	publishEvent("listing", map[string]interface{}{
+		"type":   "listing",
+		"action": "listed",
+		"stock":  s,
+		"time":   time.Now().Local().Format(time.RFC3339),
+	})
+	return nil
+}
+
+type listingRequest struct {
+	ID                string  `json:"id"`
+	Name              string  `json:"name"`
+	Sector            string  `json:"sector"`
+	Price             float64 `json:"price"`
+	SharesOutstanding int64   `json:"shares_outstanding"`
+}
+
+func (req *listingRequest) validate() error {
+	req.ID = strings.ToUpper(strings.TrimSpace(req.ID))
+	req.Name = strings.TrimSpace(req.Name)
+	req.Sector = strings.TrimSpace(req.Sector)
+	if !validSymbol(req.ID) {
+		return errors.New("id must be 1-8 letters/digits")
+	}
+	if req.Name == "" || req.Sector == "" {
+		return errors.New("name and sector required")
+	}
+	if req.Price < 0.01 || req.Price > 1e6 {
+		return errors.New("price must be between 0.01 and 1000000")
+	}
+	if req.SharesOutstanding < 0 {
+		return errors.New("shares_outstanding must be >= 0")
+	}
+	if symbolTaken(req.ID) {
+		return errors.New("symbol already in use")
+	}
+	return nil
+}
+
+// POST /api/admin/listings {"id","name","sector","price"} lists straight away, GET shows every runtime change
+func adminListingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := db.Query("SELECT id, name, sector, status, price, listed_at, delisted_at FROM stock_listings ORDER BY listed_at DESC")
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		out := []StockListing{}
+		for rows.Next() {
+			var l StockListing
+			var listed, delisted sql.NullString
+			if err := rows.Scan(&l.ID, &l.Name, &l.Sector, &l.Status, &l.Price, &listed, &delisted); err != nil {
+				http.Error(w, "db scan error", http.StatusInternalServerError)
+				return
+			}
+			l.ListedAt = nullToString(listed)
+			l.DelistedAt = nullToString(delisted)
+			out = append(out, l)
+		}
+		writeJSON(w, out)
+
+	case http.MethodPost:
+		var req listingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s := Stock{ID: req.ID, Name: req.Name, Sector: req.Sector, Price: req.Price, SharesOutstanding: req.SharesOutstanding}
+		if err := listNewStock(s); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]interface{}{"status": "listed", "stock": s})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// POST /api/admin/listings/update {"id": "APEX", "name": "...", "sector": "..."}
+func updateListingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID     string  `json:"id"`
+		Name   *string `json:"name"`
+		Sector *string `json:"sector"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	id := strings.ToUpper(strings.TrimSpace(req.ID))
+
+	stocksLock.Lock()
+	idx := -1
+	for i := range stocks {
+		if stocks[i].ID == id {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		stocksLock.Unlock()
+		http.Error(w, "stock not found", http.StatusNotFound)
+		return
+	}
+	s := stocks[idx]
+	stocksLock.Unlock()
+
+	if req.Name != nil {
+		s.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Sector != nil {
+		if s.Kind == basketKind {
+			http.Error(w, "baskets dont have a sector", http.StatusBadRequest)
+			return
+		}
+		s.Sector = strings.TrimSpace(*req.Sector)
+	}
+	if s.Name == "" || s.Sector == "" {
+		http.Error(w, "name and sector cant be empty", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "db tx error", http.StatusInternalServerError)
+		return
+	}
+	if err := saveListing(tx, s, "listed", s.Price); err != nil {
+		tx.Rollback()
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "db commit error", http.StatusInternalServerError)
+		return
+	}
+
+	stocksLock.Lock()
+	for i := range stocks {
+		if stocks[i].ID == id {
+			stocks[i].Name = s.Name
+			stocks[i].Sector = s.Sector
+			s = stocks[i]
+		}
+	}
+	updated := make([]Stock, len(stocks))
+	copy(updated, stocks)
+	stocksLock.Unlock()
+	broadcastPrices(updated)
+	publishEvent("listing", map[string]interface{}{
+		"type":   "listing",
+		"action": "updated",
+		"stock":  s,
+		"time":   time.Now().Local().Format(time.RFC3339),
+	})
+	writeJSON(w, map[string]interface{}{"status": "updated", "stock": s})
+}
+
+// POST /api/admin/listings/delist {"id": "APEX", "final_price": 12.5}, holders are paid final_price (default the last price) per share
+func delistHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID         string   `json:"id"`
+		FinalPrice *float64 `json:"final_price"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	id := strings.ToUpper(strings.TrimSpace(req.ID))
+	// like a split, no trade can land between reading the holders and the stock leaving the universe
+	corpActionLock.Lock()
+	defer corpActionLock.Unlock()
+
+	var s Stock
+	found := false
+	stocksLock.Lock()
+	for _, st := range stocks {
+		if st.ID == id {
+			s, found = st, true
+			break
+		}
+	}
+	stocksLock.Unlock()
+	if !found {
+		http.Error(w, "stock not found", http.StatusNotFound)
+		return
+	}
+	final := s.Price
+	if req.FinalPrice != nil {
+		if *req.FinalPrice < 0 {
+			http.Error(w, "final_price must be >= 0", http.StatusBadRequest)
+			return
+		}
+		final = *req.FinalPrice
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "db tx error", http.StatusInternalServerError)
+		return
+	}
+	rows, err := tx.Query("SELECT user_id, shares FROM portfolio WHERE stock_id = ?", id)
+	if err != nil {
+		tx.Rollback()
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	type holder struct {
+		userID int64
+		shares Qty
+	}
+	var holders []holder
+	for rows.Next() {
+		var h holder
+		if err := rows.Scan(&h.userID, &h.shares); err == nil {
+			holders = append(holders, h)
+		}
+	}
+	rows.Close()
+
+	paid := 0.0
+	for _, h := range holders {
+		amount := costOf(h.shares, final)
+		if _, err := tx.Exec("UPDATE users SET cash = cash + ? WHERE id = ?", amount, h.userID); err != nil {
+			tx.Rollback()
+			http.Error(w, "db update error", http.StatusInternalServerError)
+			return
+		}
+		txnID, err := recordTransaction(tx, txnRow{UserID: h.userID, StockID: id, Action: "delist", Shares: h.shares, Price: final, Amount: amount})
+		if err != nil {
+			tx.Rollback()
+			http.Error(w, "db insert error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := closeLots(tx, h.userID, id, h.shares, final, 0, "", txnID, "delist"); err != nil {
+			tx.Rollback()
+			http.Error(w, "db update error", http.StatusInternalServerError)
+			return
+		}
+		paid += amount.Float()
+	}
+	if _, err := tx.Exec("DELETE FROM portfolio WHERE stock_id = ?", id); err != nil {
+		tx.Rollback()
+		http.Error(w, "db delete error", http.StatusInternalServerError)
+		return
+	}
+	// dividends already recorded still pay out, anything not yet on its record date goes away with the stock
+	if _, err := tx.Exec("UPDATE corporate_actions SET status = 'cancelled', note = 'delisted', processed_at = CURRENT_TIMESTAMP WHERE stock_id = ? AND status = 'scheduled'", id); err != nil {
+		tx.Rollback()
+		http.Error(w, "db update error", http.StatusInternalServerError)
+		return
+	}
+	if err := saveListing(tx, s, "delisted", final); err != nil {
+		tx.Rollback()
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "db commit error", http.StatusInternalServerError)
+		return
+	}
+
+	if s.Kind == basketKind {
+		removeBasket(id)
+	} else {
+		dropBasketComponent(id, final)
+		settleOptionsOn(id, final)
+	}
+	removeStockFromUniverse(id)
+	publishEvent("listing", map[string]interface{}{
+		"type":        "listing",
+		"action":      "delisted",
+		"stock":       s,
+		"final_price": final,
+		"time":        time.Now().Local().Format(time.RFC3339),
+	})
+	_, _ = db.Exec("INSERT INTO admin_actions (stock_id, action, magnitude, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)", id, "delist", final)
+	writeJSON(w, map[string]interface{}{"status": "delisted", "id": id, "final_price": final, "holders": len(holders), "paid_out": roundToTwo(paid)})
+}
+
+const ipoColumns = `id, stock_id, name, sector, price, shares_offered, status, closes_at, allocated_at,
+	(SELECT COALESCE(SUM(shares_requested), 0) FROM ipo_subscriptions s WHERE s.ipo_id = ipos.id),
+	(SELECT COUNT(*) FROM ipo_subscriptions s WHERE s.ipo_id = ipos.id AND s.shares_requested > 0)`
+
+func scanIPO(row rowScanner) (IPO, error) {
+	var ipo IPO
+	var closes, allocated sql.NullString
+	err := row.Scan(&ipo.ID, &ipo.StockID, &ipo.Name, &ipo.Sector, &ipo.Price, &ipo.SharesOffered, &ipo.Status, &closes, &allocated, &ipo.SharesRequested, &ipo.Subscribers)
+	ipo.ClosesAt = nullToString(closes)
+	ipo.AllocatedAt = nullToString(allocated)
+	return ipo, err
+}
+
+func getIPO(id int64) (IPO, error) {
+	return scanIPO(db.QueryRow("SELECT "+ipoColumns+" FROM ipos WHERE id = ?", id))
+}
+
+// money parked in open ipo subscriptions still belongs to the user for networth
+func ipoEscrow(q rowQuerier, userID int64) Money {
+	var escrow Money
+	_ = q.QueryRow("SELECT COALESCE(SUM(s.escrow), 0) FROM ipo_subscriptions s JOIN ipos i ON i.id = s.ipo_id WHERE s.user_id = ? AND i.status = 'open'", userID).Scan(&escrow)
+	return escrow
+}
+
+// largest remainder split of offered shares, nobody gets more than they asked for
+func proRata(requested []int64, offered int64) []int64 {
+	alloc := make([]int64, len(requested))
+	var total int64
+	for _, r := range requested {
+		total += r
+	}
+	if total <= offered {
+		copy(alloc, requested)
+		return alloc
+	}
+	type rem struct {
+		i    int
+		frac float64
+	}
+	var rems []rem
+	var given int64
+	for i, r := range requested {
+		exact := float64(r) * float64(offered) / float64(total)
+		alloc[i] = int64(math.Floor(exact))
+		given += alloc[i]
+		rems = append(rems, rem{i, exact - float64(alloc[i])})
+	}
+	sort.SliceStable(rems, func(a, b int) bool { return rems[a].frac > rems[b].frac })
+	for k := 0; given < offered && k < len(rems); k++ {
+		if alloc[rems[k].i] < requested[rems[k].i] {
+			alloc[rems[k].i]++
+			given++
+		}
+	}
+	return alloc
+}
+
+// closes the book: hands out shares pro rata, refunds the rest of the escrow and lists the stock
+func allocateIPO(id int64) (IPO, error) {
+	ipo, err := getIPO(id)
+	if err != nil {
+		return ipo, err
+	}
+	if ipo.Status != "open" {
+		return ipo, errors.New("ipo is not open")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return ipo, err
+	}
+	res, err := tx.Exec("UPDATE ipos SET status = 'allocated', allocated_at = CURRENT_TIMESTAMP WHERE id = ? AND status = 'open'", id)
+	if err != nil {
+		tx.Rollback()
+		return ipo, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		return ipo, errors.New("ipo is not open")
+	}
+
+	rows, err := tx.Query("SELECT id, user_id, shares_requested, escrow FROM ipo_subscriptions WHERE ipo_id = ? AND shares_requested > 0 ORDER BY id ASC", id)
+	if err != nil {
+		tx.Rollback()
+		return ipo, err
+	}
+	type sub struct {
+		id, userID, requested int64
+		escrow                Money
+	}
+	var subs []sub
+	var requested []int64
+	for rows.Next() {
+		var s sub
+		if err := rows.Scan(&s.id, &s.userID, &s.requested, &s.escrow); err == nil {
+			subs = append(subs, s)
+			requested = append(requested, s.requested)
+		}
+	}
+	rows.Close()
+
+	alloc := proRata(requested, ipo.SharesOffered)
+	for i, s := range subs {
+		cost := costOf(wholeShares(alloc[i]), ipo.Price)
+		refund := s.escrow - cost
+		if _, err := tx.Exec("UPDATE ipo_subscriptions SET shares_allocated = ?, refunded = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", alloc[i], refund, s.id); err != nil {
+			tx.Rollback()
+			return ipo, err
+		}
+		if refund != 0 {
+			if _, err := tx.Exec("UPDATE users SET cash = cash + ? WHERE id = ?", refund, s.userID); err != nil {
+				tx.Rollback()
+				return ipo, err
+			}
+			if err := postJournal(tx, "ipo_refund", s.userID, 0, cashLegs(escrowAccount(s.userID), userAccount(s.userID), refund)); err != nil {
+				tx.Rollback()
+				return ipo, err
+			}
+		}
+		if alloc[i] == 0 {
+			continue
+		}
+		// a symbol is never reused so there is no existing position to merge with
+		if err := openLot(tx, s.userID, ipo.StockID, wholeShares(alloc[i]), ipo.Price, "ipo"); err != nil {
+			tx.Rollback()
+			return ipo, err
+		}
+		if err := syncHolding(tx, s.userID, ipo.StockID); err != nil {
+			tx.Rollback()
+			return ipo, err
+		}
+		if _, err := recordTransaction(tx, txnRow{UserID: s.userID, StockID: ipo.StockID, Action: "ipo", Shares: wholeShares(alloc[i]), Price: ipo.Price, Amount: cost}); err != nil {
+			tx.Rollback()
+			return ipo, err
+		}
+	}
+
+	stock := Stock{ID: ipo.StockID, Name: ipo.Name, Sector: ipo.Sector, Price: ipo.Price}
+	if err := saveListing(tx, stock, "listed", ipo.Price); err != nil {
+		tx.Rollback()
+		return ipo, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ipo, err
+	}
+
+	addStockToUniverse(stock)
+	ipo, _ = getIPO(id)
+	publishEvent("listing", map[string]interface{}{
+		"type":   "listing",
+		"action": "ipo_listed",
+		"stock":  stock,
+		"ipo":    ipo,
+		"time":   time.Now().Local().Format(time.RFC3339),
+	})
+	return ipo, nil
+}
+
+// hands every escrow back, used when an admin pulls an ipo
+func cancelIPO(id int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec("UPDATE ipos SET status = 'cancelled' WHERE id = ? AND status = 'open'", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		return errors.New("ipo is not open")
+	}
+	rows, err := tx.Query("SELECT user_id, escrow FROM ipo_subscriptions WHERE ipo_id = ?", id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	refunds := map[int64]Money{}
+	for rows.Next() {
+		var userID int64
+		var escrow Money
+		if err := rows.Scan(&userID, &escrow); err == nil {
+			refunds[userID] += escrow
+		}
+	}
+	rows.Close()
+	for userID, refund := range refunds {
+		if refund == 0 {
+			continue
+		}
+		if _, err := tx.Exec("UPDATE users SET cash = cash + ? WHERE id = ?", refund, userID); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := postJournal(tx, "ipo_refund", userID, 0, cashLegs(escrowAccount(userID), userAccount(userID), refund)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE ipo_subscriptions SET refunded = escrow, shares_allocated = 0, updated_at = CURRENT_TIMESTAMP WHERE ipo_id = ?", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// allocates ipos whose subscription window is over, started from main
+func ipoWatcher() {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		rows, err := db.Query("SELECT id FROM ipos WHERE status = 'open' AND closes_at <= ?", toDBTime(time.Now()))
+		if err != nil {
+			continue
+		}
+		var due []int64
+		for rows.Next() {
+			var id int64
+			if rows.Scan(&id) == nil {
+				due = append(due, id)
+			}
+		}
+		rows.Close()
+		for _, id := range due {
+			if _, err := allocateIPO(id); err != nil {
+				log.Printf("ipo %d allocation failed: %v", id, err)
+			}
+		}
+	}
+}
+
+// POST /api/admin/listings/ipo {"id","name","sector","price","shares_offered","subscribe_minutes"}
+func createIPOHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		listingRequest
+		SharesOffered    int64   `json:"shares_offered"`
+		SubscribeMinutes float64 `json:"subscribe_minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.SharesOffered <= 0 {
+		http.Error(w, "shares_offered must be > 0", http.StatusBadRequest)
+		return
+	}
+	if req.SubscribeMinutes <= 0 {
+		req.SubscribeMinutes = 30
+	}
+	closes := time.Now().Add(time.Duration(req.SubscribeMinutes * float64(time.Minute)))
+
+	res, err := db.Exec("INSERT INTO ipos (stock_id, name, sector, price, shares_offered, status, closes_at) VALUES (?, ?, ?, ?, ?, 'open', ?)",
+		req.ID, req.Name, req.Sector, req.Price, req.SharesOffered, toDBTime(closes))
+	if err != nil {
+		http.Error(w, "db insert error", http.StatusInternalServerError)
+		return
+	}
+	id, _ := res.LastInsertId()
+	ipo, _ := getIPO(id)
+	publishEvent("listing", map[string]interface{}{
+		"type":   "listing",
+		"action": "ipo_open",
+		"ipo":    ipo,
+		"time":   time.Now().Local().Format(time.RFC3339),
+	})
+	writeJSON(w, ipo)
+}
+
+// POST /api/admin/listings/ipo/allocate|cancel {"ipo_id": 1}
+func ipoControlHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if !requireAdmin(w, r) {
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			IPOID int64 `json:"ipo_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if _, err := getIPO(req.IPOID); err == sql.ErrNoRows {
+			http.Error(w, "ipo not found", http.StatusNotFound)
+			return
+		}
+
+		if action == "cancel" {
+			if err := cancelIPO(req.IPOID); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			ipo, _ := getIPO(req.IPOID)
+			publishEvent("listing", map[string]interface{}{
+				"type":   "listing",
+				"action": "ipo_cancelled",
+				"ipo":    ipo,
+				"time":   time.Now().Local().Format(time.RFC3339),
+			})
+			writeJSON(w, ipo)
+			return
+		}
+		ipo, err := allocateIPO(req.IPOID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, ipo)
+	}
+}
+
+// GET /api/ipos lists open and recent ipos (with your own subscription when signed in)
+func iposHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	rows, err := db.Query("SELECT " + ipoColumns + " FROM ipos ORDER BY id DESC LIMIT 50")
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := []IPO{}
+	for rows.Next() {
+		ipo, err := scanIPO(rows)
+		if err != nil {
+			rows.Close()
+			http.Error(w, "db scan error", http.StatusInternalServerError)
+			return
+		}
+		out = append(out, ipo)
+	}
+	rows.Close()
+
+	if userID, err := parseUserIDFromRequest(r); err == nil {
+		for i := range out {
+			var requested int64
+			var allocated sql.NullInt64
+			if err := db.QueryRow("SELECT shares_requested, shares_allocated FROM ipo_subscriptions WHERE ipo_id = ? AND user_id = ?", out[i].ID, userID).Scan(&requested, &allocated); err == nil {
+				req := requested
+				out[i].MySubscription = &req
+				if allocated.Valid {
+					a := allocated.Int64
+					out[i].MyAllocation = &a
+				}
+			}
+		}
+	}
+	writeJSON(w, out)
+}
+
+// POST /api/ipos/subscribe {"ipo_id": 1, "shares": 100}, the cost is held in escrow until allocation. shares 0 withdraws
+func subscribeIPOHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := parseUserIDFromRequest(r)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	var req struct {
+		IPOID  int64 `json:"ipo_id"`
+		Shares int64 `json:"shares"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if req.Shares < 0 {
+		http.Error(w, "shares must be >= 0", http.StatusBadRequest)
+		return
+	}
+	if err := checkCount(req.Shares); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer lockAccount(userID)()
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "db tx error", http.StatusInternalServerError)
+		return
+	}
+	var price float64
+	var status string
+	var offered int64
+	if err := tx.QueryRow("SELECT price, status, shares_offered FROM ipos WHERE id = ?", req.IPOID).Scan(&price, &status, &offered); err != nil {
+		tx.Rollback()
+		http.Error(w, "ipo not found", http.StatusNotFound)
+		return
+	}
+	if status != "open" {
+		tx.Rollback()
+		http.Error(w, "ipo is not open for subscription", http.StatusBadRequest)
+		return
+	}
+	// nobody can be allocated more than the whole offer, so theres no point escrowing for it
+	if req.Shares > offered {
+		tx.Rollback()
+		http.Error(w, fmt.Sprintf("only %d shares are on offer", offered), http.StatusBadRequest)
+		return
+	}
+
+	var held Money
+	err = tx.QueryRow("SELECT escrow FROM ipo_subscriptions WHERE ipo_id = ? AND user_id = ?", req.IPOID, userID).Scan(&held)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	// escrow is kept in cents on the subscription like cash
+	escrow := costOf(wholeShares(req.Shares), price)
+	if escrow < 0 {
+		tx.Rollback()
+		http.Error(w, errOutOfRange.Error(), http.StatusBadRequest)
+		return
+	}
+	diff := escrow - held
+
+	var cash Money
+	if err := tx.QueryRow("SELECT cash FROM users WHERE id = ?", userID).Scan(&cash); err != nil {
+		tx.Rollback()
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if diff > cash {
+		tx.Rollback()
+		http.Error(w, "insufficient funds, need "+(diff-cash).String()+" more", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE users SET cash = cash - ? WHERE id = ?", diff, userID); err != nil {
+		tx.Rollback()
+		http.Error(w, "db update error", http.StatusInternalServerError)
+		return
+	}
+	// a negative diff is a partial withdrawal, the legs just run the other way
+	if err := postJournal(tx, "ipo_escrow", userID, 0, cashLegs(userAccount(userID), escrowAccount(userID), diff)); err != nil {
+		tx.Rollback()
+		http.Error(w, "db insert error", http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.Exec(`INSERT INTO ipo_subscriptions (ipo_id, user_id, shares_requested, escrow) VALUES (?, ?, ?, ?)
+		ON CONFLICT(ipo_id, user_id) DO UPDATE SET shares_requested = excluded.shares_requested, escrow = excluded.escrow, updated_at = CURRENT_TIMESTAMP`,
+		req.IPOID, userID, req.Shares, escrow); err != nil {
+		tx.Rollback()
+		http.Error(w, "db insert error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "db commit error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"status": "ok", "ipo_id": req.IPOID, "shares": req.Shares, "escrow": escrow, "cash": cash - diff})
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// every buy opens a lot, sells close them in fifo or lifo order. portfolio.shares/avg_price are
+// just the open lots added up, syncHolding keeps them in step so the rest of the code can keep reading them
+var defaultLotMethod = "fifo"
+
+func lotMethod(m string) string {
+	switch strings.ToLower(strings.TrimSpace(m)) {
+	case "fifo":
+		return "fifo"
+	case "lifo":
+		return "lifo"
+	}
+	return defaultLotMethod
+}
+
+type TaxLot struct {
+	ID             int64   `json:"id"`
+	StockID        string  `json:"stock_id"`
+	Shares         Qty     `json:"shares"`
+	OriginalShares Qty     `json:"original_shares"`
+	CostPrice      float64 `json:"cost_price"` // per share, buy fee included
+	Source         string  `json:"source"`     // buy, ipo or migrated
+	OpenedAt       string  `json:"opened_at"`
+	CurrentPrice   float64 `json:"current_price"`
+	MarketValue    float64 `json:"market_value"`
+	UnrealizedPL   float64 `json:"unrealized_pl"`
+}
+
+type LotSale struct {
+	ID         int64   `json:"id"`
+	LotID      int64   `json:"lot_id"`
+	StockID    string  `json:"stock_id"`
+	Shares     Qty     `json:"shares"`
+	CostPrice  float64 `json:"cost_price"`
+	SalePrice  float64 `json:"sale_price"`
+	Fee        float64 `json:"fee"`
+	RealizedPL float64 `json:"realized_pl"`
+	Reason     string  `json:"reason"` // sell, buyback or delist
+	SoldAt     string  `json:"sold_at"`
+}
+
+func openLot(tx *sql.Tx, userID int64, stockID string, shares Qty, costPrice float64, source string) error {
+	_, err := tx.Exec("INSERT INTO tax_lots (user_id, stock_id, shares, original_shares, cost_price, source) VALUES (?, ?, ?, ?, ?, ?)",
+		userID, stockID, shares, shares, costPrice, source)
+	return err
+}
+
+// closes shares out of the open lots and records the realized p&l of each piece, the fee is split
+// across lots by shares. returns the total realized
+func closeLots(tx *sql.Tx, userID int64, stockID string, shares Qty, salePrice, fee float64, method string, transactionID int64, reason string) (float64, error) {
+	order := "ASC"
+	if lotMethod(method) == "lifo" {
+		order = "DESC"
+	}
+	rows, err := tx.Query("SELECT id, shares, cost_price FROM tax_lots WHERE user_id = ? AND stock_id = ? AND shares > 0 ORDER BY opened_at "+order+", id "+order, userID, stockID)
+	if err != nil {
+		return 0, err
+	}
+	type lot struct {
+		id     int64
+		shares Qty
+		cost   float64
+	}
+	var open []lot
+	for rows.Next() {
+		var l lot
+		if err := rows.Scan(&l.id, &l.shares, &l.cost); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		open = append(open, l)
+	}
+	rows.Close()
+
+	total := 0.0
+	left := shares
+	for _, l := range open {
+		if left == 0 {
+			break
+		}
+		n := l.shares
+		if n > left {
+			n = left
+		}
+		lotFee := fee * n.Float() / shares.Float()
+		realized := n.Float()*(salePrice-l.cost) - lotFee
+		// remaining worked out here, float maths in sqlite would leave fractional lots a hair off zero
+		closed := interface{}(nil)
+		if l.shares-n == 0 {
+			closed = toDBTime(time.Now())
+		}
+		if _, err := tx.Exec("UPDATE tax_lots SET shares = ?, closed_at = COALESCE(?, closed_at) WHERE id = ?", l.shares-n, closed, l.id); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec("INSERT INTO lot_sales (lot_id, user_id, stock_id, transaction_id, shares, cost_price, sale_price, fee, realized_pl, reason) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			l.id, userID, stockID, transactionID, n, l.cost, salePrice, lotFee, realized, reason); err != nil {
+			return 0, err
+		}
+		total += realized
+		left -= n
+	}
+	if left > 0 {
+		return 0, errors.New("not enough shares in open lots")
+	}
+	if transactionID > 0 {
+		if _, err := tx.Exec("UPDATE transactions SET realized_pl = ? WHERE id = ?", moneyFromFloat(total), transactionID); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// rewrites the portfolio row from the open lots
+func syncHolding(tx *sql.Tx, userID int64, stockID string) error {
+	var shares Qty
+	var cost sql.NullFloat64
+	if err := tx.QueryRow("SELECT SUM(shares), SUM(shares * cost_price) FROM tax_lots WHERE user_id = ? AND stock_id = ? AND shares > 0", userID, stockID).Scan(&shares, &cost); err != nil {
+		return err
+	}
+	if shares <= 0 {
+		_, err := tx.Exec("DELETE FROM portfolio WHERE user_id = ? AND stock_id = ?", userID, stockID)
+		return err
+	}
+	avg := cost.Float64 / shares.Float()
+	basis := moneyFromFloat(cost.Float64)
+	res, err := tx.Exec("UPDATE portfolio SET shares = ?, avg_price = ?, cost = ? WHERE user_id = ? AND stock_id = ?", shares, avg, basis, userID, stockID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		_, err = tx.Exec("INSERT INTO portfolio(user_id, stock_id, shares, avg_price, cost) VALUES(?,?,?,?,?)", userID, stockID, shares, avg, basis)
+	}
+	return err
+}
+
+// a split scales every lot by the same factor, rounding down per lot to the share precision. whatever
+// that loses against the holder's new total goes back on the newest lot so the totals still match
+func splitLots(tx *sql.Tx, userID int64, stockID string, f float64, newShares Qty) error {
+	rows, err := tx.Query("SELECT id, shares, original_shares FROM tax_lots WHERE user_id = ? AND stock_id = ? AND shares > 0 ORDER BY opened_at ASC, id ASC", userID, stockID)
+	if err != nil {
+		return err
+	}
+	type lot struct {
+		id               int64
+		shares, original Qty
+	}
+	var open []lot
+	for rows.Next() {
+		var l lot
+		if err := rows.Scan(&l.id, &l.shares, &l.original); err == nil {
+			open = append(open, l)
+		}
+	}
+	rows.Close()
+
+	var sum Qty
+	for i := range open {
+		open[i].shares = qtyFromFloat(open[i].shares.Float()/f + 1e-9).floor()
+		open[i].original = qtyFromFloat(open[i].original.Float()/f + 1e-9).floor()
+		sum += open[i].shares
+	}
+	if len(open) > 0 {
+		open[len(open)-1].shares += newShares - sum
+	}
+	for _, l := range open {
+		closed := interface{}(nil)
+		if l.shares <= 0 {
+			l.shares = 0
+			closed = toDBTime(time.Now())
+		}
+		if _, err := tx.Exec("UPDATE tax_lots SET shares = ?, original_shares = ?, cost_price = cost_price * ?, closed_at = COALESCE(?, closed_at) WHERE id = ?", l.shares, l.original, f, closed, l.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// positions from before tax lots existed become one lot at their average price
+func migrateLots() {
+	res, err := db.Exec(`INSERT INTO tax_lots (user_id, stock_id, shares, original_shares, cost_price, source)
+		SELECT user_id, stock_id, shares, shares, avg_price, 'migrated' FROM portfolio p
+		WHERE shares > 0 AND NOT EXISTS (SELECT 1 FROM tax_lots l WHERE l.user_id = p.user_id AND l.stock_id = p.stock_id AND l.shares > 0)`)
+	if err != nil {
+		log.Printf("Failed to migrate tax lots: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Opened %d tax lots for existing positions", n)
+	}
+}
+
+func realizedPL(userID int64) float64 {
+	var total sql.NullFloat64
+	_ = db.QueryRow("SELECT SUM(realized_pl) FROM lot_sales WHERE user_id = ?", userID).Scan(&total)
+	return total.Float64
+}
+
+// GET /api/lots (&stock=APEX), open lots with unrealized p&l and closed pieces with realized p&l
+func lotsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	userID, err := parseUserIDFromRequest(r)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	stock := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("stock")))
+
+	q := "SELECT id, stock_id, shares, original_shares, cost_price, source, opened_at FROM tax_lots WHERE user_id = ? AND shares > 0"
+	args := []interface{}{userID}
+	if stock != "" {
+		q += " AND stock_id = ?"
+		args = append(args, stock)
+	}
+	rows, err := db.Query(q+" ORDER BY stock_id ASC, opened_at ASC, id ASC", args...)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	open := []TaxLot{}
+	unrealized := 0.0
+	for rows.Next() {
+		var l TaxLot
+		var opened string
+		if err := rows.Scan(&l.ID, &l.StockID, &l.Shares, &l.OriginalShares, &l.CostPrice, &l.Source, &opened); err != nil {
+			rows.Close()
+			http.Error(w, "db scan error", http.StatusInternalServerError)
+			return
+		}
+		l.OpenedAt = parseDBTimeToLocal(opened).Format(time.RFC3339)
+		open = append(open, l)
+	}
+	rows.Close()
+	for i := range open {
+		price, _ := getStockPrice(open[i].StockID)
+		open[i].CurrentPrice = roundToFour(price)
+		open[i].MarketValue = costOf(open[i].Shares, price).Float()
+		open[i].UnrealizedPL = roundToTwo(open[i].Shares.Float() * (price - open[i].CostPrice))
+		open[i].CostPrice = roundToFour(open[i].CostPrice)
+		unrealized += open[i].UnrealizedPL
+	}
+
+	q = "SELECT id, lot_id, stock_id, shares, cost_price, sale_price, fee, realized_pl, reason, sold_at FROM lot_sales WHERE user_id = ?"
+	args = []interface{}{userID}
+	if stock != "" {
+		q += " AND stock_id = ?"
+		args = append(args, stock)
+	}
+	rows, err = db.Query(q+" ORDER BY id DESC", args...)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	closed := []LotSale{}
+	realized := 0.0
+	for rows.Next() {
+		var s LotSale
+		var sold string
+		if err := rows.Scan(&s.ID, &s.LotID, &s.StockID, &s.Shares, &s.CostPrice, &s.SalePrice, &s.Fee, &s.RealizedPL, &s.Reason, &sold); err != nil {
+			http.Error(w, "db scan error", http.StatusInternalServerError)
+			return
+		}
+		s.SoldAt = parseDBTimeToLocal(sold).Format(time.RFC3339)
+		s.CostPrice = roundToFour(s.CostPrice)
+		s.SalePrice = roundToFour(s.SalePrice)
+		s.Fee = roundToTwo(s.Fee)
+		s.RealizedPL = roundToTwo(s.RealizedPL)
+		realized += s.RealizedPL
+		closed = append(closed, s)
+	}
+	writeJSON(w, map[string]interface{}{
+		"lot_method":    defaultLotMethod,
+		"open":          open,
+		"closed":        closed,
+		"unrealized_pl": roundToTwo(unrealized),
+		"realized_pl":   roundToTwo(realized),
+	})
+}
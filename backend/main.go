@@ -15,11 +15,24 @@ type Stock struct {
 	Price  float64 `json:"price"`
 	Change float64 `json:"change"`
 	Sector string  `json:"sector"`
+	Halted bool    `json:"halted,omitempty"` // circuit breaker or admin halt, see breakers.go
+	Kind   string  `json:"kind,omitempty"`   // "etf" for baskets, empty for plain stocks
+
+	SharesOutstanding int64 `json:"shares_outstanding,omitempty"` // for cap weighted indices
 }
 
 type Config struct {
-	Start string `json:"start"`
-	End   string `json:"end"`
+	Start           string           `json:"start"`
+	End             string           `json:"end"`
+	CircuitBreakers *BreakerSettings `json:"circuit_breakers,omitempty"`
+	Options         *OptionSettings  `json:"options,omitempty"`
+	Rates           *RateSettings    `json:"rates,omitempty"`
+	Fees            *FeeSettings     `json:"fees,omitempty"`
+	LotMethod       string           `json:"lot_method,omitempty"`     // fifo or lifo
+	ShareDecimals   int              `json:"share_decimals,omitempty"` // 0 is whole shares, up to 6
+	IdempotencyTTL  float64          `json:"idempotency_ttl_hours,omitempty"`
+	MaxSlippage     float64          `json:"max_slippage,omitempty"` // 0.01 = 1%, when a trade has expected_price but no max_slippage
+	Rules           *TradingRules    `json:"rules,omitempty"`
 }
 
 var (
@@ -33,16 +46,35 @@ func main() {
 	rand.Seed(time.Now().UnixNano())
 	loadConfig()
 	loadStocks()
-	initDB()    // db
-	initTicks() // get the inital stock history chart for frontend
+	initDB()              // db
+	seedCompetitions()    // the main competition from config.json
+	checkReconciliation() // cash and positions against the transactions and the ledger
+	applyStockListings()  // runtime listings/delistings on top of stocks.json
+	initTicks()           // get the inital stock history chart for frontend
+	initIndices()         // market/sector index history from the stock history
+	initBaskets()         // etfs, priced from their components
+	initBonds()           // bonds and bills from data/bonds.json on first start
+	loadRates()           // admin rate moves survive restarts
+	seedNewsSources()
+	seedScenarios()
+	loadNewsScript()
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/status", statusHandler)
 	mux.HandleFunc("/api/transactions", transactionsHandler)
 	mux.HandleFunc("/api/stocks", stocksHandler)
+	mux.HandleFunc("/api/halts", haltsHandler)
+	mux.HandleFunc("/api/indices", indicesHandler)
+	mux.HandleFunc("/api/baskets", basketsHandler)
 	mux.HandleFunc("/ws/prices", pricesWSHandler)
+	mux.HandleFunc("/api/stream", streamHandler)
 	mux.HandleFunc("/api/portfolio", portfolioHandler)
 	mux.HandleFunc("/api/trade", tradeHandler)
+	mux.HandleFunc("/api/trade/batch", batchTradeHandler)
+	mux.HandleFunc("/api/rules", rulesHandler)
+	mux.HandleFunc("/api/competitions", competitionsHandler)
+	mux.HandleFunc("/api/competitions/enrol", enrolCompetitionHandler)
+	mux.HandleFunc("/api/competitions/select", selectCompetitionHandler)
 	mux.HandleFunc("/api/auth/signup", signupHandler)
 	mux.HandleFunc("/api/auth/signout", signoutHandler)
 	mux.HandleFunc("/api/auth/me", meHandler)
@@ -50,8 +82,61 @@ func main() {
 	mux.HandleFunc("/api/history", historyHandler)
 	mux.HandleFunc("/api/news/sources", newsSourcesHandler)
 	mux.HandleFunc("/api/news", getNewsHandler)
+	mux.HandleFunc("/api/news/timeline", newsTimelineHandler)
+	mux.HandleFunc("/api/news.rss", newsRSSHandler)
+	mux.HandleFunc("/api/news.atom", newsAtomHandler)
+	mux.HandleFunc("/api/news.json", newsJSONFeedHandler)
 	mux.HandleFunc("/api/admin/publish-news", publishNewsHandler)
+	mux.HandleFunc("/api/admin/news/sources", adminNewsSourcesHandler)
+	mux.HandleFunc("/api/admin/news/sources/update", updateNewsSourceHandler)
+	mux.HandleFunc("/api/admin/news/sources/delete", deleteNewsSourceHandler)
+	mux.HandleFunc("/api/admin/news/retract", retractNewsHandler)
+	mux.HandleFunc("/api/admin/news/correct", correctNewsHandler)
+	mux.HandleFunc("/api/admin/news/rumor/resolve", resolveRumorHandler)
+	mux.HandleFunc("/api/admin/news/schedule", newsScheduleHandler)
+	mux.HandleFunc("/api/admin/news/schedule/update", updateScheduledNewsHandler)
+	mux.HandleFunc("/api/admin/news/schedule/cancel", cancelScheduledNewsHandler)
+	mux.HandleFunc("/api/admin/news/schedule/import", importNewsScriptHandler)
 	mux.HandleFunc("/api/admin/stock-action", adminStockActionHandler)
+	mux.HandleFunc("/api/admin/events", marketEventsHandler)
+	mux.HandleFunc("/api/admin/halt", adminHaltHandler)
+	mux.HandleFunc("/api/admin/resume", adminResumeHandler)
+	mux.HandleFunc("/api/admin/events/cancel", cancelMarketEventHandler)
+	mux.HandleFunc("/api/admin/scenarios", scenariosHandler)
+	mux.HandleFunc("/api/admin/scenarios/delete", deleteScenarioHandler)
+	mux.HandleFunc("/api/admin/scenarios/preview", previewScenarioHandler)
+	mux.HandleFunc("/api/admin/scenarios/run", runScenarioHandler)
+	mux.HandleFunc("/api/admin/scenarios/runs", scenarioRunsHandler)
+	mux.HandleFunc("/api/admin/scenarios/pause", scenarioRunControlHandler("pause"))
+	mux.HandleFunc("/api/admin/scenarios/resume", scenarioRunControlHandler("resume"))
+	mux.HandleFunc("/api/admin/scenarios/cancel", scenarioRunControlHandler("cancel"))
+	mux.HandleFunc("/api/admin/baskets", adminBasketsHandler)
+	mux.HandleFunc("/api/admin/listings", adminListingsHandler)
+	mux.HandleFunc("/api/admin/listings/update", updateListingHandler)
+	mux.HandleFunc("/api/admin/listings/delist", delistHandler)
+	mux.HandleFunc("/api/admin/listings/ipo", createIPOHandler)
+	mux.HandleFunc("/api/admin/listings/ipo/allocate", ipoControlHandler("allocate"))
+	mux.HandleFunc("/api/admin/listings/ipo/cancel", ipoControlHandler("cancel"))
+	mux.HandleFunc("/api/admin/corporate-actions", adminCorporateActionsHandler)
+	mux.HandleFunc("/api/admin/corporate-actions/cancel", cancelCorporateActionHandler)
+	mux.HandleFunc("/api/corporate-actions", corporateActionsHandler)
+	mux.HandleFunc("/api/options/chain", optionChainHandler)
+	mux.HandleFunc("/api/options/positions", optionPositionsHandler)
+	mux.HandleFunc("/api/options/trade", optionTradeHandler)
+	mux.HandleFunc("/api/admin/bonds", adminBondsHandler)
+	mux.HandleFunc("/api/admin/rates", adminRatesHandler)
+	mux.HandleFunc("/api/admin/ledger", adminLedgerHandler)
+	mux.HandleFunc("/api/admin/adjust", adminAdjustHandler)
+	mux.HandleFunc("/api/admin/reconcile", adminReconcileHandler)
+	mux.HandleFunc("/api/admin/competitions", adminCompetitionsHandler)
+	mux.HandleFunc("/api/bonds", bondsHandler)
+	mux.HandleFunc("/api/bonds/positions", bondPositionsHandler)
+	mux.HandleFunc("/api/bonds/trade", bondTradeHandler)
+	mux.HandleFunc("/api/rates", ratesHandler)
+	mux.HandleFunc("/api/lots", lotsHandler)
+	mux.HandleFunc("/api/statements", statementsHandler)
+	mux.HandleFunc("/api/ipos", iposHandler)
+	mux.HandleFunc("/api/ipos/subscribe", subscribeIPOHandler)
 	mux.HandleFunc("/api/leaderboard", leaderboardHandler)
 	mux.HandleFunc("/api/teams", teamsHandler)
 	mux.HandleFunc("/api/teams/leaderboard", teamLeaderboardHandler)
@@ -59,7 +144,16 @@ func main() {
 	mux.HandleFunc("/api/teams/create", createTeamHandler)
 	mux.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir("../frontend/")))) // serve frontend
 
-	go priceTicker() // start price ticking
+	go priceTicker()            // start price ticking
+	go marketEngine()           // runs every news/admin price move
+	go breakerWatcher()         // halts stocks that move too fast
+	go statusWatcher()          // tells streaming clients when the comp opens/closes
+	go newsScheduler()          // publishes queued news when it's due
+	go ipoWatcher()             // lists ipos once their subscription window closes
+	go corporateActionWatcher() // dividends, splits and buybacks
+	go optionsWatcher()         // lists option chains and settles them at expiry
+	go bondsWatcher()           // coupons, maturities and interest on cash
+	go reconcileWatcher()       // checks every account against the ledger at midnight
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -82,7 +176,8 @@ func enableCORS(next http.Handler) http.Handler {
 		}
 
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Last-Event-ID, Idempotency-Key, X-Competition")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Next-Cursor, Idempotent-Replayed")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// fixed point so cash and share maths add up exactly. Money is whole cents and Qty is millionths of a share.
+// money columns (users.cash, portfolio.cost, transactions.amount/fee/realized_pl) hold integer cents,
+// share columns keep holding plain share counts
+type Money int64
+type Qty int64
+
+const (
+	centsPerUnit = 100
+	qtyScale     = 1000000
+	maxQtyPlaces = 6
+)
+
+// the largest amount and quantity a request can send, well inside int64 once scaled and multiplied out
+const (
+	maxMoneyInput = 1e12
+	maxQtyInput   = 1e9
+)
+
+var errOutOfRange = errors.New("value out of range")
+
+// a whole unit count from a request (option contracts, bonds, ipo shares), held to the same bound as a
+// json quantity so wholeShares and the value maths after it cant wrap
+func checkCount(n int64) error {
+	if n < 0 || n > maxQtyInput {
+		return errOutOfRange
+	}
+	return nil
+}
+
+// what every account signs up with
+const startingCash Money = 10000 * centsPerUnit
+
+// how many decimals a share quantity can have, 0 is whole shares only. "share_decimals" in config.json
+var shareDecimals = 0
+
+// rounds half away from zero, which is what people expect from money
+func moneyFromFloat(f float64) Money {
+	return Money(math.Round(f * centsPerUnit))
+}
+
+func (m Money) Float() float64 { return float64(m) / centsPerUnit }
+
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/centsPerUnit, v%centsPerUnit)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) { return []byte(m.String()), nil }
+
+func (m *Money) UnmarshalJSON(b []byte) error {
+	f, err := strconv.ParseFloat(strings.Trim(string(b), `"`), 64)
+	if err != nil {
+		return errors.New("invalid amount")
+	}
+	// checked before scaling, past int64 the conversion wraps into nonsense
+	if math.IsNaN(f) || math.Abs(f) > maxMoneyInput {
+		return errOutOfRange
+	}
+	*m = moneyFromFloat(f)
+	return nil
+}
+
+// reads a cents column. old dbs declared them REAL, sqlite hands those back as whole floats
+func (m *Money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+	case int64:
+		*m = Money(v)
+	case float64:
+		*m = Money(math.Round(v))
+	case []byte:
+		return m.Scan(string(v))
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("cant scan %q into Money", v)
+		}
+		*m = Money(math.Round(f))
+	default:
+		return fmt.Errorf("cant scan %T into Money", src)
+	}
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) { return int64(m), nil }
+
+// what to tell a client whose body didnt decode, an out of range amount or quantity says so
+func badJSON(err error) string {
+	if errors.Is(err, errOutOfRange) {
+		return err.Error()
+	}
+	return "invalid json"
+}
+
+func qtyFromFloat(f float64) Qty {
+	return Qty(math.Round(f * qtyScale))
+}
+
+func wholeShares(n int64) Qty { return Qty(n * qtyScale) }
+
+func (q Qty) Float() float64 { return float64(q) / qtyScale }
+
+// whole shares when it is one, so old clients and integer columns see what they always did
+func (q Qty) String() string {
+	if q%qtyScale == 0 {
+		return strconv.FormatInt(int64(q/qtyScale), 10)
+	}
+	s := strconv.FormatFloat(q.Float(), 'f', maxQtyPlaces, 64)
+	return strings.TrimRight(strings.TrimRight(s, "0"), ".")
+}
+
+func (q Qty) MarshalJSON() ([]byte, error) { return []byte(q.String()), nil }
+
+func (q *Qty) UnmarshalJSON(b []byte) error {
+	f, err := strconv.ParseFloat(strings.Trim(string(b), `"`), 64)
+	if err != nil {
+		return errors.New("invalid quantity")
+	}
+	if math.IsNaN(f) || math.Abs(f) > maxQtyInput {
+		return errOutOfRange
+	}
+	*q = qtyFromFloat(f)
+	return nil
+}
+
+func (q *Qty) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*q = 0
+	case int64:
+		*q = wholeShares(v)
+	case float64:
+		*q = qtyFromFloat(v)
+	case []byte:
+		return q.UnmarshalJSON(v)
+	case string:
+		return q.UnmarshalJSON([]byte(v))
+	default:
+		return fmt.Errorf("cant scan %T into Qty", src)
+	}
+	return nil
+}
+
+func (q Qty) Value() (driver.Value, error) {
+	if q%qtyScale == 0 {
+		return int64(q / qtyScale), nil
+	}
+	return q.Float(), nil
+}
+
+func qtyStep(places int) Qty {
+	step := Qty(qtyScale)
+	for i := 0; i < places && i < maxQtyPlaces; i++ {
+		step /= 10
+	}
+	return step
+}
+
+// rounds down to the configured precision
+func (q Qty) floor() Qty {
+	step := qtyStep(shareDecimals)
+	return q / step * step
+}
+
+func (q Qty) valid() bool { return q > 0 && q == q.floor() }
+
+func (q Qty) mulFrac(num, den int64) Qty {
+	return Qty(mulDiv(int64(q), num, den, false))
+}
+
+// price is a float everywhere (ticks, news moves), it gets pinned to millionths before it touches money
+func priceMicros(price float64) int64 { return int64(math.Round(price * 1e6)) }
+
+// value of q shares at price, rounded to the cent
+func costOf(q Qty, price float64) Money {
+	// qty micro-shares * price micro-dollars = 1e-12 dollars, a cent is 1e10 of those
+	return Money(mulDiv(int64(q), priceMicros(price), 1e10, true))
+}
+
+// the most shares amount buys at price, floored to the configured precision
+func qtyForAmount(amount Money, price float64) Qty {
+	p := priceMicros(price)
+	if p <= 0 || amount <= 0 {
+		return 0
+	}
+	// cents * 1e10 / price micro-dollars = micro-shares
+	return Qty(mulDiv(int64(amount), 1e10, p, false)).floor()
+}
+
+// a*b/c without overflowing, rounded half away from zero or truncated. a result past int64 is pinned to
+// the nearest end instead of wrapping, so a huge order fails its cash check rather than paying out
+func mulDiv(a, b, c int64, round bool) int64 {
+	n := new(big.Int).Mul(big.NewInt(a), big.NewInt(b))
+	d := big.NewInt(c)
+	if round {
+		half := new(big.Int).Quo(d, big.NewInt(2))
+		if n.Sign() < 0 {
+			n.Sub(n, half)
+		} else {
+			n.Add(n, half)
+		}
+	}
+	n.Quo(n, d)
+	if !n.IsInt64() {
+		if n.Sign() < 0 {
+			return math.MinInt64
+		}
+		return math.MaxInt64
+	}
+	return n.Int64()
+}
+
+// dbs from before schema version 1 kept money as float dollars. converts them to cents once, fills in
+// the trade value on old buy/sell rows and the cost basis on old positions
+func migrateMoney() {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil || version >= 1 {
+		return
+	}
+	stmts := []string{
+		"UPDATE users SET cash = CAST(ROUND(cash * 100) AS INTEGER)",
+		"UPDATE transactions SET amount = shares * price WHERE amount IS NULL AND action IN ('buy', 'sell', 'ipo', 'delist')",
+		`UPDATE transactions SET amount = CAST(ROUND(amount * 100) AS INTEGER), fee = CAST(ROUND(fee * 100) AS INTEGER),
+			realized_pl = CAST(ROUND(realized_pl * 100) AS INTEGER)`,
+		`UPDATE portfolio SET cost = CAST(ROUND(COALESCE((SELECT SUM(l.shares * l.cost_price) FROM tax_lots l
+			WHERE l.user_id = portfolio.user_id AND l.stock_id = portfolio.stock_id AND l.shares > 0), shares * avg_price) * 100) AS INTEGER)`,
+		"PRAGMA user_version = 1",
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal("Failed to migrate money columns:", err)
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			log.Fatal("Failed to migrate money columns:", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal("Failed to migrate money columns:", err)
+	}
+	log.Println("Converted money columns to cents")
+}
+
+// schema version 1 still kept ipo escrow and refunds as float dollars, version 2 has them in cents too
+func migrateEscrow() {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil || version >= 2 {
+		return
+	}
+	stmts := []string{
+		"UPDATE ipo_subscriptions SET escrow = CAST(ROUND(escrow * 100) AS INTEGER), refunded = CAST(ROUND(refunded * 100) AS INTEGER)",
+		"PRAGMA user_version = 2",
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal("Failed to migrate ipo escrow:", err)
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			log.Fatal("Failed to migrate ipo escrow:", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal("Failed to migrate ipo escrow:", err)
+	}
+}
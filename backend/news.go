@@ -3,54 +3,32 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"math"
-	"math/rand"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
 	"time"
 )
 
 // /api/news output
 type NewsOut struct {
-	ID             int64   `json:"id"`
-	Title          string  `json:"title"`
-	Content        string  `json:"content"`
-	AffectedStock  string  `json:"affected_stock,omitempty"`
-	AffectedSector string  `json:"affected_sector,omitempty"`
-	Category       string  `json:"category,omitempty"`
-	Source         string  `json:"source,omitempty"`
-	Impact         float64 `json:"impact"`
-	PublishedAt    string  `json:"published_at"`
-}
-
-// loads from /data/newswebsites.json
-func loadNewsSources() map[string]bool {
-	allowed := map[string]bool{}
-	paths := []string{"data/newswebsites.json"}
-	var data []byte
-	var err error
-	for _, p := range paths {
-		data, err = os.ReadFile(p)
-		if err == nil {
-			break
-		}
-	}
-	if err == nil {
-		var arr []struct {
-			Name string `json:"name"`
-		}
-		if jerr := json.Unmarshal(data, &arr); jerr == nil {
-			for _, it := range arr {
-				n := strings.TrimSpace(it.Name)
-				if n != "" {
-					allowed[strings.ToLower(n)] = true
-				}
-			}
-		}
-	}
-	return allowed
+	ID             int64       `json:"id"`
+	Title          string      `json:"title"`
+	Content        string      `json:"content"`
+	AffectedStock  string      `json:"affected_stock,omitempty"`
+	AffectedSector string      `json:"affected_sector,omitempty"`
+	Category       string      `json:"category,omitempty"`
+	Source         string      `json:"source,omitempty"`
+	Impact         float64     `json:"impact"`
+	Urgency        string      `json:"urgency,omitempty"`
+	Kind           string      `json:"kind"`   // news or rumor
+	Status         string      `json:"status"` // published, corrected or retracted
+	Credibility    *float64    `json:"credibility,omitempty"`
+	RumorState     string      `json:"rumor_state,omitempty"` // pending, confirmed or denied
+	AppliedImpact  float64     `json:"applied_impact"`        // net move actually pushed onto the price so far
+	Note           string      `json:"note,omitempty"`
+	SourceInfo     *NewsSource `json:"source_info,omitempty"`
+	PublishedAt    string      `json:"published_at"`
 }
 
 func newsSourcesHandler(w http.ResponseWriter, r *http.Request) {
@@ -58,341 +36,324 @@ func newsSourcesHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
+	// ?view=full gives the credibility/bias/reach list, the plain map is what the admin page reads
+	if r.URL.Query().Get("view") == "full" {
+		list, err := listNewsSources()
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, list)
+		return
+	}
 	allowedSources := loadNewsSources()
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(allowedSources)
 }
 
-func getNewsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-		return
-	}
+const newsColumns = "id, title, content, affected_stock, affected_sector, category, source, impact, urgency, COALESCE(kind, 'news'), COALESCE(status, 'published'), credibility, rumor_state, COALESCE(applied_impact, impact), note, published_at"
 
-	limit := 50
-	if l := r.URL.Query().Get("limit"); l != "" {
-		if li, err := strconv.Atoi(l); err == nil && li > 0 {
-			limit = li
-		}
+func scanNews(row rowScanner) (NewsOut, error) {
+	var n NewsOut
+	var affectedStock sql.NullString
+	var affectedSector sql.NullString
+	var category sql.NullString
+	var source sql.NullString
+	var urgency sql.NullString
+	var credibility sql.NullFloat64
+	var rumorState sql.NullString
+	var note sql.NullString
+	var pub sql.NullString
+	if err := row.Scan(&n.ID, &n.Title, &n.Content, &affectedStock, &affectedSector, &category, &source, &n.Impact, &urgency,
+		&n.Kind, &n.Status, &credibility, &rumorState, &n.AppliedImpact, &note, &pub); err != nil {
+		return n, err
 	}
-	rows, err := db.Query("SELECT id, title, content, affected_stock, affected_sector, category, source, impact, published_at FROM news ORDER BY published_at DESC LIMIT ?", limit)
-	if err != nil {
-		http.Error(w, "db error", http.StatusInternalServerError)
-		return
+	if credibility.Valid {
+		c := credibility.Float64
+		n.Credibility = &c
+	}
+	n.RumorState = nullToString(rumorState)
+	n.Note = nullToString(note)
+	if affectedStock.Valid {
+		n.AffectedStock = affectedStock.String
+	}
+	if affectedSector.Valid {
+		n.AffectedSector = affectedSector.String
+	}
+	if category.Valid {
+		n.Category = category.String
 	}
-	defer rows.Close()
+	if source.Valid {
+		n.Source = source.String
+	}
+	if urgency.Valid {
+		n.Urgency = urgency.String
+	}
+	if pub.Valid {
+		n.PublishedAt = pub.String
+	}
+	return n, nil
+}
 
-	out := []NewsOut{}
-	for rows.Next() {
-		var n NewsOut
-		var affectedStock sql.NullString
-		var affectedSector sql.NullString
-		var category sql.NullString
-		var source sql.NullString
-		var pub sql.NullString
-		if err := rows.Scan(&n.ID, &n.Title, &n.Content, &affectedStock, &affectedSector, &category, &source, &n.Impact, &pub); err != nil {
-			http.Error(w, "db scan error", http.StatusInternalServerError)
-			return
-		}
-		if affectedStock.Valid {
-			n.AffectedStock = affectedStock.String
-		}
-		if affectedSector.Valid {
-			n.AffectedSector = affectedSector.String
-		}
-		if category.Valid {
-			n.Category = category.String
-		}
-		if source.Valid {
-			n.Source = source.String
-		}
-		if pub.Valid {
-			n.PublishedAt = pub.String
-		}
-		out = append(out, n)
+func getNewsByID(id int64) (NewsOut, error) {
+	n, err := scanNews(db.QueryRow("SELECT "+newsColumns+" FROM news WHERE id = ?", id))
+	if err != nil {
+		return n, err
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(out)
+	one := []NewsOut{n}
+	attachSourceInfo(one)
+	return one[0], nil
 }
 
-// admin action, publish new news.
-func publishNewsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
-		return
+// tells every streaming client about a publish, symbols are the stocks whose price is about to move
+func broadcastNews(n NewsOut, symbols []string) {
+	if symbols == nil {
+		symbols = []string{}
+	}
+	urgency := n.Urgency
+	if urgency == "" {
+		urgency = urgencyForImpact(n.Impact)
+	}
+	publishEvent("news", map[string]interface{}{
+		"type":    "news",
+		"news":    n,
+		"urgency": urgency,
+		"symbols": symbols,
+		"time":    time.Now().Local().Format(time.RFC3339),
+	})
+}
+
+// what an admin (or the scheduler) asks to publish
+type NewsRequest struct {
+	Title          string  `json:"title"`
+	Content        string  `json:"content"`
+	AffectedStock  string  `json:"affected_stock,omitempty"`
+	AffectedSector string  `json:"affected_sector,omitempty"`
+	Impact         float64 `json:"impact"`
+	Source         string  `json:"source"`
+	Urgency        string  `json:"urgency,omitempty"` // breaking, high, normal or low. worked out from impact if empty
+
+	// rumors only move the price by impact*credibility until an admin confirms or denies them
+	Kind          string   `json:"kind,omitempty"`
+	Credibility   *float64 `json:"credibility,omitempty"` // nil is 0.5, an explicit 0 is a rumor that moves nothing yet
+	ConfirmImpact *float64 `json:"confirm_impact,omitempty"`
+	DenyImpact    *float64 `json:"deny_impact,omitempty"`
+
+	category   string
+	scheduleID int64 // set when the scheduler publishes it
+}
+
+func clampImpact(impact float64) float64 {
+	if impact > 0.4 {
+		return 0.4
 	}
-	if secret := os.Getenv("ADMIN_SECRET"); secret != "" {
-		if r.Header.Get("X-Admin-Secret") != secret {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
+	if impact < -0.4 {
+		return -0.4
 	}
+	return impact
+}
 
-	var req struct {
-		Title          string  `json:"title"`
-		Content        string  `json:"content"`
-		AffectedStock  string  `json:"affected_stock,omitempty"`
-		AffectedSector string  `json:"affected_sector,omitempty"`
-		Impact         float64 `json:"impact"`
-		Source         string  `json:"source"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid json", http.StatusBadRequest)
-		return
+var newsUrgencies = map[string]bool{"breaking": true, "high": true, "normal": true, "low": true}
+
+func urgencyForImpact(impact float64) string {
+	a := math.Abs(impact)
+	switch {
+	case a >= 0.15:
+		return "breaking"
+	case a >= 0.05:
+		return "high"
+	case a > 0:
+		return "normal"
 	}
+	return "low"
+}
 
+// checks a news request and fills in the category. errors here are the admin's fault (400)
+func validateNews(req *NewsRequest) error {
 	req.Title = strings.TrimSpace(req.Title)
 	req.Content = strings.TrimSpace(req.Content)
 	req.AffectedStock = strings.TrimSpace(req.AffectedStock)
 	req.AffectedSector = strings.TrimSpace(req.AffectedSector)
 	req.Source = strings.TrimSpace(req.Source)
+	req.Urgency = strings.ToLower(strings.TrimSpace(req.Urgency))
+
+	req.Kind = strings.ToLower(strings.TrimSpace(req.Kind))
 
+	if req.Urgency != "" && !newsUrgencies[req.Urgency] {
+		return errors.New("urgency must be breaking, high, normal or low")
+	}
+	switch req.Kind {
+	case "", "news":
+		req.Kind = "news"
+	case "rumor":
+		if req.Credibility == nil {
+			half := 0.5
+			req.Credibility = &half
+		}
+		if *req.Credibility < 0 || *req.Credibility > 1 {
+			return errors.New("credibility must be between 0 and 1")
+		}
+	default:
+		return errors.New("kind must be news or rumor")
+	}
 	if req.Source == "" {
-		http.Error(w, "source required", http.StatusBadRequest)
-		return
+		return errors.New("source required")
 	}
 	allowedSources := loadNewsSources()
 	if !allowedSources[strings.ToLower(req.Source)] {
-		http.Error(w, "invalid source", http.StatusBadRequest)
-		return
+		return errors.New("invalid source")
 	}
 
 	if req.AffectedStock != "" && req.AffectedSector != "" {
-		http.Error(w, "provide either affected_stock OR affected_sector, not both", http.StatusBadRequest)
-		return
+		return errors.New("provide either affected_stock OR affected_sector, not both")
 	}
 	// to prevent lots of bugs in my code that arise if more than 0.4 on both ends
-	impact := req.Impact
-	if impact > 0.4 {
-		impact = 0.4
-	}
-	if impact < -0.4 {
-		impact = -0.4
-	}
+	req.Impact = clampImpact(req.Impact)
 
-	category := ""
+	req.category = ""
 	if req.AffectedStock != "" {
 		found := false
 		stocksLock.Lock()
+		basket := false
 		for i := range stocks {
 			if stocks[i].ID == req.AffectedStock {
-				category = strings.TrimSpace(stocks[i].Sector)
+				req.category = strings.TrimSpace(stocks[i].Sector)
 				found = true
+				basket = stocks[i].Kind == basketKind
 				break
 			}
 		}
 		stocksLock.Unlock()
 		if !found {
-			http.Error(w, "affected stock not found", http.StatusBadRequest)
-			return
+			return errors.New("affected stock not found")
+		}
+		if basket {
+			return errors.New("baskets move with their components, target the stocks instead")
 		}
 	} else if req.AffectedSector != "" {
-		category = req.AffectedSector
-	}
-
-	var dbAffectedStock interface{} = nil
-	var dbAffectedSector interface{} = nil
-	var dbCategory interface{} = nil
-	if req.AffectedStock != "" {
-		dbAffectedStock = req.AffectedStock
-	}
-	if req.AffectedSector != "" {
-		dbAffectedSector = req.AffectedSector
-	}
-	if category != "" {
-		dbCategory = category
-	}
-
-	_, err := db.Exec(
-		"INSERT INTO news (title, content, affected_stock, affected_sector, category, source, impact, published_at) VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)",
-		req.Title, req.Content, dbAffectedStock, dbAffectedSector, dbCategory, req.Source, impact,
-	)
-	if err != nil {
-		http.Error(w, "db insert error", http.StatusInternalServerError)
-		return
-	}
-	if (req.AffectedStock == "" && req.AffectedSector == "") || impact == 0 {
-		w.WriteHeader(http.StatusOK)
-		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
-		return
+		req.category = req.AffectedSector
+		if ids := newsTargets(*req); req.Impact != 0 && len(ids) == 0 {
+			return errors.New("no affected stocks found")
+		}
 	}
+	return nil
+}
 
+// ids and current prices of everything a news item moves
+func newsTargets(req NewsRequest) []string {
 	var ids []string
-	var bases []float64
 
 	stocksLock.Lock()
+	defer stocksLock.Unlock()
 	if req.AffectedStock != "" {
 		for i := range stocks {
 			if stocks[i].ID == req.AffectedStock {
 				ids = append(ids, stocks[i].ID)
-				bases = append(bases, stocks[i].Price)
 				break
 			}
 		}
 	} else if req.AffectedSector != "" {
 		target := strings.ToLower(req.AffectedSector)
 		for i := range stocks {
-			if strings.ToLower(strings.TrimSpace(stocks[i].Sector)) == target {
+			if strings.ToLower(strings.TrimSpace(stocks[i].Sector)) == target && stocks[i].Kind != basketKind {
 				ids = append(ids, stocks[i].ID)
-				bases = append(bases, stocks[i].Price)
 			}
 		}
 	}
-	stocksLock.Unlock()
-
-	if len(ids) == 0 {
-		http.Error(w, "no affected stocks found", http.StatusBadRequest)
-		return
-	}
-
-	// give up the glory of changing actual stock data to another function T-T
-	go changeStock(ids, bases, impact)
-
-	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	return ids
 }
 
-// very very gradual yet semi-realistic change to stock
-func changeStock(ids []string, bases []float64, impact float64) {
-	if len(ids) == 0 || len(ids) != len(bases) {
-		return
-	}
-
-	// as said in before comment T-T
-	if impact > 4.0 {
-		impact = 4.0
-	} else if impact < -4.0 {
-		impact = -4.0
-	}
-
-	targetMultiplier := 1.0 + impact
-	if targetMultiplier <= 0.0001 {
-		targetMultiplier = 0.0001
-	}
-	// keep it smooth
-	steps := int(40 + math.Round(math.Abs(impact)*160))
-	if steps < 30 {
-		steps = 30
+// stores a validated news item and starts the price move, used by the handler and the scheduler
+func publishNews(req NewsRequest) (int64, error) {
+	var dbAffectedStock interface{} = nil
+	var dbAffectedSector interface{} = nil
+	var dbCategory interface{} = nil
+	var dbScheduleID interface{} = nil
+	if req.AffectedStock != "" {
+		dbAffectedStock = req.AffectedStock
 	}
-	if steps > 900 {
-		steps = 900
+	if req.AffectedSector != "" {
+		dbAffectedSector = req.AffectedSector
 	}
-
-	randNorm := func() float64 {
-		u1 := rand.Float64()
-		u2 := rand.Float64()
-		if u1 < 1e-12 {
-			u1 = 1e-12
+	if req.category != "" {
+		dbCategory = req.category
+	}
+	if req.scheduleID != 0 {
+		dbScheduleID = req.scheduleID
+	}
+	// how hard the price actually moves depends on who reported it
+	effective := sourceWeightedImpact(req.Source, req.Impact)
+	urgency := req.Urgency
+	if urgency == "" {
+		urgency = urgencyForImpact(effective)
+	}
+	applied := effective
+	var dbCredibility, dbRumorState, dbConfirm, dbDeny interface{}
+	if req.Kind == "rumor" {
+		applied = effective * *req.Credibility
+		// by default confirming finishes the move and denying undoes it
+		confirm := clampImpact((1+effective)/(1+applied) - 1)
+		deny := clampImpact(1/(1+applied) - 1)
+		if req.ConfirmImpact != nil {
+			confirm = clampImpact(*req.ConfirmImpact)
 		}
-		return math.Sqrt(-2.0*math.Log(u1)) * math.Cos(2.0*math.Pi*u2)
-	}
-	easeInOut := func(x float64) float64 {
-		return 0.5 - 0.5*math.Cos(math.Pi*x)
-	}
-	n := len(ids)
-	current := make([]float64, n)
-	for i := 0; i < n; i++ {
-		current[i] = bases[i]
-		if current[i] <= 0 {
-			current[i] = 0.01
+		if req.DenyImpact != nil {
+			deny = clampImpact(*req.DenyImpact)
 		}
+		dbCredibility, dbRumorState, dbConfirm, dbDeny = *req.Credibility, "pending", confirm, deny
 	}
 
-	lastAppend := time.Now().Add(-2 * time.Minute)
-
-	for step := 1; step <= steps; step++ {
-		frac := float64(step) / float64(steps)
-		targetFrac := easeInOut(frac)
-
-		for ti := 0; ti < n; ti++ {
-			base := bases[ti]
-			ideal := base * math.Pow(targetMultiplier, targetFrac)
-
-			moveFactor := 0.08 + 0.55*math.Pow(frac, 1.1)
-			noiseScale := 0.0006 + 0.006*math.Abs(impact)
-			noise := randNorm() * noiseScale * base
-
-			nextPrice := current[ti] + (ideal-current[ti])*moveFactor + noise
-
-			microSteps := 1 + rand.Intn(3)
-			for m := 0; m < microSteps; m++ {
-				jitter := randNorm() * 0.00035 * base
-				stepPrice := nextPrice + jitter
-
-				maxAllowed := base * targetMultiplier * 1.06
-				minAllowed := base * targetMultiplier * 0.94
-				if impact >= 0 && stepPrice > maxAllowed {
-					stepPrice = maxAllowed
-				}
-				if impact < 0 && stepPrice < minAllowed {
-					stepPrice = minAllowed
-				}
-				if stepPrice < 0.01 {
-					stepPrice = 0.01
-				}
+	res, err := db.Exec(
+		"INSERT INTO news (title, content, affected_stock, affected_sector, category, source, impact, urgency, kind, status, credibility, rumor_state, confirm_impact, deny_impact, applied_impact, schedule_id, published_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 'published', ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)",
+		req.Title, req.Content, dbAffectedStock, dbAffectedSector, dbCategory, req.Source, req.Impact, urgency,
+		req.Kind, dbCredibility, dbRumorState, dbConfirm, dbDeny, applied, dbScheduleID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	newsID, _ := res.LastInsertId()
 
-				stocksLock.Lock()
-				for i := range stocks {
-					if stocks[i].ID != ids[ti] {
-						continue
-					}
-					prev := stocks[i].Price
-					stocks[i].Price = stepPrice
-					stocks[i].Change = stepPrice - prev
-				}
-				updated := make([]Stock, len(stocks))
-				copy(updated, stocks)
-				stocksLock.Unlock()
-				// broadcast it for the world to fear
-				broadcastPrices(updated)
+	var ids []string
+	if req.AffectedStock != "" || req.AffectedSector != "" {
+		ids = newsTargets(req)
+	}
 
-				shouldAppend := false
-				if time.Since(lastAppend) > 1200*time.Millisecond {
-					shouldAppend = true
-				} else if m == microSteps-1 && (math.Abs(stepPrice-current[ti]) > base*0.002) {
-					shouldAppend = true
-				}
+	// push it out right before the price move starts so clients see both at the same moment
+	if n, err := getNewsByID(newsID); err == nil {
+		broadcastNews(n, ids)
+	}
 
-				if shouldAppend {
-					vol := int64(400 + rand.Intn(3000) + int(math.Round(700.0*math.Abs(impact))))
-					appendTick(ids[ti], stepPrice, vol)
-					lastAppend = time.Now()
-				}
+	// give up the glory of changing actual stock data to the market engine T-T
+	submitMarketEvent("news", req.Title, ids, applied)
 
-				current[ti] = stepPrice
+	return newsID, nil
+}
 
-				sleepMs := 120 + rand.Intn(520)
-				time.Sleep(time.Duration(sleepMs) * time.Millisecond)
-			}
-		}
+// admin action, publish new news.
+func publishNewsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
 
-		// stack overflow or smth idk but this makes it feel a lot more realistic
-		plateauProb := 0.08 + 0.12*(1.0-frac)
-		if rand.Float64() < plateauProb {
-			time.Sleep(time.Duration(400+rand.Intn(1400)) * time.Millisecond)
-		}
+	var req NewsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
 	}
-	for ti := 0; ti < n; ti++ {
-		finalPrice := bases[ti] * targetMultiplier
-		if finalPrice < 0.01 {
-			finalPrice = 0.01
-		}
-		stocksLock.Lock()
-		for i := range stocks {
-			if stocks[i].ID != ids[ti] {
-				continue
-			}
-			prev := stocks[i].Price
-			stocks[i].Price = finalPrice
-			stocks[i].Change = finalPrice - prev
-			vol := int64(1200 + rand.Intn(5200))
-			appendTick(stocks[i].ID, finalPrice, vol)
-		}
-		updated := make([]Stock, len(stocks))
-		copy(updated, stocks)
-		stocksLock.Unlock()
-		broadcastPrices(updated)
-		// this makes it unusually fast and its fine for now
-		time.Sleep(90 * time.Millisecond)
+	if err := validateNews(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := publishNews(req); err != nil {
+		http.Error(w, "db insert error", http.StatusInternalServerError)
+		return
 	}
+
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
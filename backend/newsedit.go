@@ -0,0 +1,311 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// starts a price move on whatever an article points at, returns the symbols that move
+func moveNewsTargets(label, stock, sector string, impact float64) []string {
+	ids := newsTargets(NewsRequest{AffectedStock: stock, AffectedSector: sector})
+	submitMarketEvent("news", label, ids, impact)
+	return ids
+}
+
+// the move that takes a price back to where it was before an applied impact
+func undoImpact(applied float64) float64 {
+	if applied <= -1 {
+		return 0
+	}
+	return 1/(1+applied) - 1
+}
+
+// applied impacts stack multiplicatively, same as the price moves themselves
+func stackImpact(applied, move float64) float64 {
+	return (1+applied)*(1+move) - 1
+}
+
+func broadcastNewsUpdate(n NewsOut, action string, symbols []string, move float64) {
+	if symbols == nil {
+		symbols = []string{}
+	}
+	publishEvent("news_update", map[string]interface{}{
+		"type":    "news_update",
+		"action":  action,
+		"news":    n,
+		"symbols": symbols,
+		"impact":  move,
+		"time":    time.Now().Local().Format(time.RFC3339),
+	})
+}
+
+func loadNewsForEdit(w http.ResponseWriter, id int64) (NewsOut, bool) {
+	n, err := getNewsByID(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "news not found", http.StatusNotFound)
+		return n, false
+	} else if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return n, false
+	}
+	return n, true
+}
+
+// pulls an article, optionally moving the price back by whatever the article pushed on it
+func retractNewsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID                 int64    `json:"id"`
+		Note               string   `json:"note"`
+		Compensate         bool     `json:"compensate"`
+		CompensationImpact *float64 `json:"compensation_impact,omitempty"` // overrides the automatic undo
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	n, ok := loadNewsForEdit(w, req.ID)
+	if !ok {
+		return
+	}
+	if n.Status == "retracted" {
+		http.Error(w, "already retracted", http.StatusBadRequest)
+		return
+	}
+
+	move := 0.0
+	if req.CompensationImpact != nil {
+		move = clampImpact(*req.CompensationImpact)
+	} else if req.Compensate {
+		move = undoImpact(n.AppliedImpact)
+	}
+	applied := stackImpact(n.AppliedImpact, move)
+
+	// a retracted rumor counts as denied, the price side is whatever compensation was asked for
+	rumorState := n.RumorState
+	if n.Kind == "rumor" && rumorState == "pending" {
+		rumorState = "denied"
+	}
+
+	res, err := db.Exec("UPDATE news SET status = 'retracted', note = ?, applied_impact = ?, rumor_state = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND COALESCE(status, 'published') != 'retracted'",
+		strings.TrimSpace(req.Note), applied, nullIfEmpty(rumorState), req.ID)
+	if err != nil {
+		http.Error(w, "db update error", http.StatusInternalServerError)
+		return
+	}
+	if cnt, _ := res.RowsAffected(); cnt == 0 {
+		http.Error(w, "already retracted", http.StatusConflict)
+		return
+	}
+
+	symbols := moveNewsTargets("retracted: "+n.Title, n.AffectedStock, n.AffectedSector, move)
+
+	n, _ = getNewsByID(req.ID)
+	broadcastNewsUpdate(n, "retracted", symbols, move)
+	writeJSON(w, map[string]interface{}{"status": "ok", "news": n, "compensation_impact": move})
+}
+
+// fixes the text, target or impact of an article. with compensate the price is moved to match the corrected impact
+func correctNewsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID             int64    `json:"id"`
+		Title          *string  `json:"title"`
+		Content        *string  `json:"content"`
+		AffectedStock  *string  `json:"affected_stock"`
+		AffectedSector *string  `json:"affected_sector"`
+		Impact         *float64 `json:"impact"`
+		Note           string   `json:"note"`
+		Compensate     bool     `json:"compensate"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	n, ok := loadNewsForEdit(w, req.ID)
+	if !ok {
+		return
+	}
+	if n.Status == "retracted" {
+		http.Error(w, "cant correct a retracted article", http.StatusBadRequest)
+		return
+	}
+	if n.Kind == "rumor" && n.RumorState == "pending" {
+		http.Error(w, "confirm or deny the rumor before correcting it", http.StatusBadRequest)
+		return
+	}
+
+	fixed := NewsRequest{
+		Title:          n.Title,
+		Content:        n.Content,
+		AffectedStock:  n.AffectedStock,
+		AffectedSector: n.AffectedSector,
+		Impact:         n.Impact,
+		Source:         n.Source,
+		Urgency:        n.Urgency,
+	}
+	if req.Title != nil {
+		fixed.Title = *req.Title
+	}
+	if req.Content != nil {
+		fixed.Content = *req.Content
+	}
+	if req.AffectedStock != nil {
+		fixed.AffectedStock = *req.AffectedStock
+		if req.AffectedSector == nil {
+			fixed.AffectedSector = ""
+		}
+	}
+	if req.AffectedSector != nil {
+		fixed.AffectedSector = *req.AffectedSector
+		if req.AffectedStock == nil {
+			fixed.AffectedStock = ""
+		}
+	}
+	if req.Impact != nil {
+		fixed.Impact = *req.Impact
+	}
+	if err := validateNews(&fixed); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	retargeted := fixed.AffectedStock != n.AffectedStock || !strings.EqualFold(fixed.AffectedSector, n.AffectedSector)
+	applied := n.AppliedImpact
+	var symbols []string
+	move := 0.0
+	if req.Compensate {
+		weighted := sourceWeightedImpact(fixed.Source, fixed.Impact)
+		if retargeted {
+			// wrong stock: undo it where it landed and apply it where it should have gone
+			symbols = append(symbols, moveNewsTargets("corrected: "+n.Title, n.AffectedStock, n.AffectedSector, undoImpact(applied))...)
+			symbols = append(symbols, moveNewsTargets("corrected: "+fixed.Title, fixed.AffectedStock, fixed.AffectedSector, weighted)...)
+			move = weighted
+		} else {
+			move = stackImpact(weighted, undoImpact(applied))
+			symbols = moveNewsTargets("corrected: "+fixed.Title, fixed.AffectedStock, fixed.AffectedSector, move)
+		}
+		applied = weighted
+	}
+
+	_, err := db.Exec("UPDATE news SET title = ?, content = ?, affected_stock = ?, affected_sector = ?, category = ?, impact = ?, applied_impact = ?, status = 'corrected', note = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		fixed.Title, fixed.Content, nullIfEmpty(fixed.AffectedStock), nullIfEmpty(fixed.AffectedSector), nullIfEmpty(fixed.category),
+		fixed.Impact, applied, strings.TrimSpace(req.Note), req.ID)
+	if err != nil {
+		http.Error(w, "db update error", http.StatusInternalServerError)
+		return
+	}
+
+	n, _ = getNewsByID(req.ID)
+	broadcastNewsUpdate(n, "corrected", symbols, move)
+	writeJSON(w, map[string]interface{}{"status": "ok", "news": n, "compensation_impact": move})
+}
+
+// confirms or denies a pending rumor, each outcome carries its own price move
+func resolveRumorHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID      int64    `json:"id"`
+		Outcome string   `json:"outcome"` // confirm or deny
+		Impact  *float64 `json:"impact,omitempty"`
+		Note    string   `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	req.Outcome = strings.ToLower(strings.TrimSpace(req.Outcome))
+	if req.Outcome != "confirm" && req.Outcome != "deny" {
+		http.Error(w, "outcome must be confirm or deny", http.StatusBadRequest)
+		return
+	}
+
+	n, ok := loadNewsForEdit(w, req.ID)
+	if !ok {
+		return
+	}
+	if n.Kind != "rumor" {
+		http.Error(w, "not a rumor", http.StatusBadRequest)
+		return
+	}
+	if n.RumorState != "pending" || n.Status == "retracted" {
+		http.Error(w, "rumor already resolved", http.StatusBadRequest)
+		return
+	}
+
+	var confirmImpact, denyImpact sql.NullFloat64
+	if err := db.QueryRow("SELECT confirm_impact, deny_impact FROM news WHERE id = ?", req.ID).Scan(&confirmImpact, &denyImpact); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	move, state := denyImpact.Float64, "denied"
+	if req.Outcome == "confirm" {
+		move, state = confirmImpact.Float64, "confirmed"
+	}
+	if req.Impact != nil {
+		move = clampImpact(*req.Impact)
+	}
+	applied := stackImpact(n.AppliedImpact, move)
+
+	res, err := db.Exec("UPDATE news SET rumor_state = ?, applied_impact = ?, note = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND rumor_state = 'pending'",
+		state, applied, strings.TrimSpace(req.Note), req.ID)
+	if err != nil {
+		http.Error(w, "db update error", http.StatusInternalServerError)
+		return
+	}
+	if cnt, _ := res.RowsAffected(); cnt == 0 {
+		http.Error(w, "rumor already resolved", http.StatusConflict)
+		return
+	}
+
+	symbols := moveNewsTargets("rumor "+state+": "+n.Title, n.AffectedStock, n.AffectedSector, move)
+
+	n, _ = getNewsByID(req.ID)
+	broadcastNewsUpdate(n, state, symbols, move)
+	writeJSON(w, map[string]interface{}{"status": "ok", "news": n, "impact": move})
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultNewsLimit = 50
+	maxNewsLimit     = 200
+)
+
+// where clause + args built from the /api/news query params
+type newsFilter struct {
+	where []string
+	args  []interface{}
+}
+
+func (f *newsFilter) add(clause string, args ...interface{}) {
+	f.where = append(f.where, clause)
+	f.args = append(f.args, args...)
+}
+
+func (f *newsFilter) sql() string {
+	if len(f.where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(f.where, " AND ")
+}
+
+// cursor is just the last row's published_at|id, opaque to the client.
+// the driver hands published_at back as rfc3339 so it goes back to the stored layout first
+func encodeNewsCursor(n NewsOut) string {
+	pub := toDBTime(parseDBTimeToLocal(n.PublishedAt))
+	return base64.RawURLEncoding.EncodeToString([]byte(pub + "|" + strconv.FormatInt(n.ID, 10)))
+}
+
+func decodeNewsCursor(c string) (string, int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(c)
+	if err != nil {
+		return "", 0, errors.New("invalid cursor")
+	}
+	parts := strings.SplitN(string(raw), "|", 2)
+	if len(parts) != 2 {
+		return "", 0, errors.New("invalid cursor")
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", 0, errors.New("invalid cursor")
+	}
+	return parts[0], id, nil
+}
+
+// turns whatever the user typed into a safe fts5 query, every word has to match (prefix match so "apex" finds "apexes")
+func ftsQuery(q string) string {
+	var terms []string
+	for _, word := range strings.Fields(q) {
+		word = strings.ReplaceAll(word, `"`, "")
+		if word == "" {
+			continue
+		}
+		terms = append(terms, `"`+word+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+func parseNewsTime(v string) (string, error) {
+	if unix, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return toDBTime(time.Unix(unix, 0)), nil
+	}
+	t, err := parseCompTime(v)
+	if err != nil {
+		return "", err
+	}
+	return toDBTime(t), nil
+}
+
+func parseNewsLimit(v string) int {
+	limit := defaultNewsLimit
+	if li, err := strconv.Atoi(v); err == nil && li > 0 {
+		limit = li
+	}
+	if limit > maxNewsLimit {
+		limit = maxNewsLimit
+	}
+	return limit
+}
+
+func newsFilterFromQuery(r *http.Request) (*newsFilter, error) {
+	q := r.URL.Query()
+	f := &newsFilter{}
+
+	if v := strings.ToUpper(strings.TrimSpace(q.Get("stock"))); v != "" {
+		f.add("affected_stock = ?", v)
+	}
+	// sector matches sector-wide news and news about any stock in that sector
+	if v := strings.TrimSpace(q.Get("sector")); v != "" {
+		f.add("(affected_sector = ? COLLATE NOCASE OR category = ? COLLATE NOCASE)", v, v)
+	}
+	if v := strings.TrimSpace(q.Get("category")); v != "" {
+		f.add("category = ? COLLATE NOCASE", v)
+	}
+	if v := strings.TrimSpace(q.Get("source")); v != "" {
+		f.add("source = ? COLLATE NOCASE", v)
+	}
+	if v := strings.ToLower(strings.TrimSpace(q.Get("kind"))); v != "" {
+		f.add("COALESCE(kind, 'news') = ?", v)
+	}
+	if v := strings.ToLower(strings.TrimSpace(q.Get("status"))); v != "" {
+		f.add("COALESCE(status, 'published') = ?", v)
+	}
+	if v := strings.TrimSpace(q.Get("since")); v != "" {
+		t, err := parseNewsTime(v)
+		if err != nil {
+			return nil, errors.New("invalid since")
+		}
+		f.add("published_at >= ?", t)
+	}
+	if v := strings.TrimSpace(q.Get("until")); v != "" {
+		t, err := parseNewsTime(v)
+		if err != nil {
+			return nil, errors.New("invalid until")
+		}
+		f.add("published_at <= ?", t)
+	}
+	if v := ftsQuery(q.Get("q")); v != "" {
+		f.add("id IN (SELECT rowid FROM news_fts WHERE news_fts MATCH ?)", v)
+	}
+	if v := strings.TrimSpace(q.Get("cursor")); v != "" {
+		pub, id, err := decodeNewsCursor(v)
+		if err != nil {
+			return nil, err
+		}
+		f.add("(published_at < ? OR (published_at = ? AND id < ?))", pub, pub, id)
+	}
+	return f, nil
+}
+
+func queryNews(f *newsFilter, limit int) ([]NewsOut, error) {
+	args := append(append([]interface{}{}, f.args...), limit)
+	rows, err := db.Query("SELECT "+newsColumns+" FROM news"+f.sql()+" ORDER BY published_at DESC, id DESC LIMIT ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []NewsOut{}
+	for rows.Next() {
+		n, err := scanNews(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
+
+// /api/news?stock=&sector=&category=&source=&kind=&status=&since=&until=&q=&limit=&cursor=
+// body stays a plain array so old clients keep working, the next page cursor comes back in X-Next-Cursor
+func getNewsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	f, err := newsFilterFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := parseNewsLimit(r.URL.Query().Get("limit"))
+
+	// one extra row tells us if there is another page
+	out, err := queryNews(f, limit+1)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if len(out) > limit {
+		out = out[:limit]
+		w.Header().Set("X-Next-Cursor", encodeNewsCursor(out[len(out)-1]))
+	}
+	attachSourceInfo(out)
+	writeJSON(w, out)
+}
+
+// last recorded price at or before t, false if the tick history doesnt go back that far
+func priceAt(stockID string, t time.Time) (float64, bool) {
+	tickLock.Lock()
+	defer tickLock.Unlock()
+
+	rh := rawTickHistory[stockID]
+	if len(rh) == 0 || rh[0].Time.After(t) {
+		return 0, false
+	}
+	i := sort.Search(len(rh), func(i int) bool { return rh[i].Time.After(t) })
+	return rh[i-1].Price, true
+}
+
+// /api/news/timeline?stock=APEX, the news that moved one stock (its own plus sector-wide) oldest first,
+// with the price when it came out so the market page can pin it on the chart
+func newsTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	stockID := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("stock")))
+	if stockID == "" {
+		http.Error(w, "stock required", http.StatusBadRequest)
+		return
+	}
+	var stock Stock
+	found := false
+	stocksLock.Lock()
+	for _, s := range stocks {
+		if s.ID == stockID {
+			stock = s
+			found = true
+			break
+		}
+	}
+	stocksLock.Unlock()
+	if !found {
+		http.Error(w, "stock not found", http.StatusNotFound)
+		return
+	}
+
+	f := &newsFilter{}
+	f.add("(affected_stock = ? OR (affected_stock IS NULL AND affected_sector = ? COLLATE NOCASE))", stock.ID, stock.Sector)
+	if v := strings.TrimSpace(r.URL.Query().Get("since")); v != "" {
+		t, err := parseNewsTime(v)
+		if err != nil {
+			http.Error(w, "invalid since", http.StatusBadRequest)
+			return
+		}
+		f.add("published_at >= ?", t)
+	}
+	// retracted articles still show up, the chart moved on them
+	items, err := queryNews(f, parseNewsLimit(r.URL.Query().Get("limit")))
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	attachSourceInfo(items)
+
+	out := make([]map[string]interface{}, 0, len(items))
+	for i := len(items) - 1; i >= 0; i-- {
+		n := items[i]
+		at := parseDBTimeToLocal(n.PublishedAt)
+		entry := map[string]interface{}{
+			"news": n,
+			"time": at.Format(time.RFC3339),
+			"unix": at.Unix(),
+		}
+		if p, ok := priceAt(stock.ID, at); ok {
+			entry["price_at"] = roundToFour(p)
+			if p > 0 {
+				entry["change_since"] = roundToFour((stock.Price - p) / p * 100)
+			}
+		}
+		out = append(out, entry)
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"stock":  stock.ID,
+		"name":   stock.Name,
+		"sector": stock.Sector,
+		"price":  stock.Price,
+		"items":  out,
+		"count":  len(out),
+	})
+}
@@ -0,0 +1,664 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// "options" in config.json, anything left out keeps the defaults below
+type OptionSettings struct {
+	Enabled    bool      `json:"enabled"`
+	Expiries   int       `json:"expiries"`   // spread evenly over the competition, the last one at the end
+	Strikes    []float64 `json:"strikes"`    // offsets from the current price, 0.1 = 10% above
+	Multiplier float64   `json:"multiplier"` // shares per contract
+	MinVol     float64   `json:"min_hourly_vol"`
+}
+
+func defaultOptionSettings() *OptionSettings {
+	return &OptionSettings{
+		Enabled:    true,
+		Expiries:   4,
+		Strikes:    []float64{-0.2, -0.1, -0.05, 0, 0.05, 0.1, 0.2},
+		Multiplier: 100,
+		MinVol:     0.01,
+	}
+}
+
+var optionSettings = defaultOptionSettings()
+
+// european call or put on one stock, settled in cash at expiry
+type OptionContract struct {
+	ID          int64   `json:"id"`
+	Symbol      string  `json:"symbol"`
+	StockID     string  `json:"stock_id"`
+	Type        string  `json:"type"` // call or put
+	Strike      float64 `json:"strike"`
+	Multiplier  float64 `json:"multiplier"`
+	ExpiresAt   string  `json:"expires_at"`
+	Status      string  `json:"status"` // open or settled
+	SettlePrice float64 `json:"settle_price,omitempty"`
+
+	// filled in for open contracts
+	Mark      float64 `json:"mark"` // per share, one contract costs mark*multiplier
+	Delta     float64 `json:"delta"`
+	HourlyVol float64 `json:"hourly_vol,omitempty"`
+	Underlier float64 `json:"underlying_price,omitempty"`
+
+	expires time.Time
+}
+
+const optionColumns = "id, symbol, stock_id, type, strike, multiplier, expires_at, status, settle_price"
+
+func scanOption(row rowScanner) (OptionContract, error) {
+	var c OptionContract
+	var expires string
+	var settle sql.NullFloat64
+	if err := row.Scan(&c.ID, &c.Symbol, &c.StockID, &c.Type, &c.Strike, &c.Multiplier, &expires, &c.Status, &settle); err != nil {
+		return c, err
+	}
+	c.expires = parseDBTimeToLocal(expires)
+	c.ExpiresAt = c.expires.Format(time.RFC3339)
+	c.SettlePrice = settle.Float64
+	return c, nil
+}
+
+func getOptionBySymbol(symbol string) (OptionContract, error) {
+	return scanOption(db.QueryRow("SELECT "+optionColumns+" FROM option_contracts WHERE symbol = ?", strings.ToUpper(strings.TrimSpace(symbol))))
+}
+
+func normCDF(x float64) float64 {
+	return 0.5 * math.Erfc(-x/math.Sqrt2)
+}
+
+// black-scholes with no interest, sigma is per sqrt(second) and t in seconds so sim time and real time are the same thing.
+// returns price per share and delta
+func blackScholes(kind string, s, k, sigma, t float64) (float64, float64) {
+	if t <= 0 || sigma <= 0 || s <= 0 || k <= 0 {
+		if kind == "call" {
+			if s > k {
+				return s - k, 1
+			}
+			return 0, 0
+		}
+		if k > s {
+			return k - s, -1
+		}
+		return 0, 0
+	}
+	sd := sigma * math.Sqrt(t)
+	d1 := (math.Log(s/k) + 0.5*sd*sd) / sd
+	d2 := d1 - sd
+	if kind == "call" {
+		return s*normCDF(d1) - k*normCDF(d2), normCDF(d1)
+	}
+	return k*normCDF(-d2) - s*normCDF(-d1), normCDF(d1) - 1
+}
+
+// realised volatility per sqrt(second) from the raw tick history, never below min_hourly_vol
+func stockVolatility(stockID string) float64 {
+	tickLock.Lock()
+	rh := rawTickHistory[stockID]
+	start := 0
+	if len(rh) > 600 {
+		start = len(rh) - 600
+	}
+	sumSq, secs := 0.0, 0.0
+	for i := start + 1; i < len(rh); i++ {
+		dt := rh[i].Time.Sub(rh[i-1].Time).Seconds()
+		if dt <= 0 || rh[i-1].Price <= 0 || rh[i].Price <= 0 {
+			continue
+		}
+		r := math.Log(rh[i].Price / rh[i-1].Price)
+		sumSq += r * r
+		secs += dt
+	}
+	tickLock.Unlock()
+
+	floor := optionSettings.MinVol / 60 // per hour -> per sqrt(second)
+	if secs <= 0 {
+		return floor
+	}
+	sigma := math.Sqrt(sumSq / secs)
+	if sigma < floor {
+		return floor
+	}
+	return sigma
+}
+
+// fills in mark/delta for an open contract. vols caches per stock sigma for a whole chain
+func markOption(c *OptionContract, vols map[string]float64, now time.Time) {
+	if c.Status != "open" {
+		c.Mark = c.SettlePrice
+		return
+	}
+	price, err := getStockPrice(c.StockID)
+	if err != nil {
+		return
+	}
+	sigma, ok := vols[c.StockID]
+	if !ok {
+		sigma = stockVolatility(c.StockID)
+		vols[c.StockID] = sigma
+	}
+	mark, delta := blackScholes(c.Type, price, c.Strike, sigma, c.expires.Sub(now).Seconds())
+	c.Mark = roundToFour(mark)
+	c.Delta = roundToFour(delta)
+	c.HourlyVol = roundToFour(sigma * 60)
+	c.Underlier = roundToFour(price)
+}
+
+// expiry times still ahead, spread evenly over each competition's window. contracts are shared but an
+// account only sees and trades the ones that expire inside its own competition
+func optionExpiries(now time.Time) []time.Time {
+	n := optionSettings.Expiries
+	if n <= 0 {
+		return nil
+	}
+	comps, err := listCompetitions()
+	if err != nil {
+		log.Printf("Failed to load competitions for option expiries: %v", err)
+		return nil
+	}
+	seen := map[time.Time]bool{}
+	var out []time.Time
+	for _, c := range comps {
+		if !c.End.After(c.Start) {
+			continue
+		}
+		step := c.End.Sub(c.Start) / time.Duration(n)
+		for i := 1; i <= n; i++ {
+			t := c.Start.Add(step * time.Duration(i)).Truncate(time.Minute)
+			// nobody can trade something that expires in a minute
+			if t.After(now.Add(2*time.Minute)) && !seen[t] {
+				seen[t] = true
+				out = append(out, t)
+			}
+		}
+	}
+	return out
+}
+
+// whether a contract expiring at t belongs to c's chain
+func (c Competition) expiresInside(t time.Time) bool {
+	return t.After(c.Start) && !t.After(c.End)
+}
+
+// 1, 2.5 or 5 times a power of ten, roughly 2.5% of the price
+func strikeStep(price float64) float64 {
+	raw := price * 0.025
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2.5, 5, 10} {
+		if raw <= m*mag {
+			return m * mag
+		}
+	}
+	return 10 * mag
+}
+
+func optionSymbol(stockID, kind string, strike float64, expires time.Time) string {
+	return fmt.Sprintf("%s-%s-%s-%s", stockID, expires.UTC().Format("0102T1504"), strings.ToUpper(kind[:1]), strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.2f", strike), "0"), "."))
+}
+
+// lists calls and puts around the current price for every expiry. runs again later so new
+// listings get a chain and strikes follow the price, existing contracts are left alone
+func refreshOptionChains(now time.Time) {
+	if !optionSettings.Enabled {
+		return
+	}
+	expiries := optionExpiries(now)
+	if len(expiries) == 0 {
+		return
+	}
+	stocksLock.Lock()
+	snapshot := make([]Stock, len(stocks))
+	copy(snapshot, stocks)
+	stocksLock.Unlock()
+
+	for _, s := range snapshot {
+		if s.Kind == basketKind || s.Price <= 0 {
+			continue
+		}
+		step := strikeStep(s.Price)
+		for _, exp := range expiries {
+			for _, off := range optionSettings.Strikes {
+				strike := math.Round(s.Price*(1+off)/step) * step
+				if strike <= 0 {
+					continue
+				}
+				for _, kind := range []string{"call", "put"} {
+					_, err := db.Exec("INSERT OR IGNORE INTO option_contracts (symbol, stock_id, type, strike, multiplier, expires_at, status) VALUES (?, ?, ?, ?, ?, ?, 'open')",
+						optionSymbol(s.ID, kind, strike, exp), s.ID, kind, strike, optionSettings.Multiplier, toDBTime(exp))
+					if err != nil {
+						log.Printf("Failed to list option on %s: %v", s.ID, err)
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// pays every holder the intrinsic value and closes the contract
+func settleOption(c OptionContract, underlying float64) error {
+	payoff := 0.0
+	if c.Type == "call" {
+		payoff = math.Max(underlying-c.Strike, 0)
+	} else {
+		payoff = math.Max(c.Strike-underlying, 0)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec("UPDATE option_contracts SET status = 'settled', settle_price = ?, settled_at = CURRENT_TIMESTAMP WHERE id = ? AND status = 'open'", payoff, c.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		tx.Rollback()
+		return nil
+	}
+	rows, err := tx.Query("SELECT user_id, contracts FROM option_positions WHERE contract_id = ? AND contracts > 0", c.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	type pos struct{ userID, contracts int64 }
+	var positions []pos
+	for rows.Next() {
+		var p pos
+		if err := rows.Scan(&p.userID, &p.contracts); err == nil {
+			positions = append(positions, p)
+		}
+	}
+	rows.Close()
+
+	for _, p := range positions {
+		amount := moneyFromFloat(float64(p.contracts) * payoff * c.Multiplier)
+		if amount > 0 {
+			if _, err := tx.Exec("UPDATE users SET cash = cash + ? WHERE id = ?", amount, p.userID); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if _, err := recordTransaction(tx, txnRow{UserID: p.userID, StockID: c.Symbol, Action: "option_settle", Shares: wholeShares(p.contracts), Price: payoff, Amount: amount}); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM option_positions WHERE contract_id = ?", c.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// settles every open contract on a stock right away, used when it gets delisted
+func settleOptionsOn(stockID string, price float64) {
+	rows, err := db.Query("SELECT "+optionColumns+" FROM option_contracts WHERE stock_id = ? AND status = 'open'", stockID)
+	if err != nil {
+		return
+	}
+	var list []OptionContract
+	for rows.Next() {
+		if c, err := scanOption(rows); err == nil {
+			list = append(list, c)
+		}
+	}
+	rows.Close()
+	for _, c := range list {
+		if err := settleOption(c, price); err != nil {
+			log.Printf("option %s settlement failed: %v", c.Symbol, err)
+		}
+	}
+}
+
+// after a split a contract covers more (or fewer) shares at a lower (or higher) strike, worth the same
+func adjustOptionsForSplit(tx *sql.Tx, stockID string, f float64) error {
+	_, err := tx.Exec("UPDATE option_contracts SET strike = strike * ?, multiplier = multiplier / ? WHERE stock_id = ? AND status = 'open'", f, f, stockID)
+	return err
+}
+
+// settles expired contracts and keeps the chains listed, started from main
+func optionsWatcher() {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	refreshOptionChains(time.Now())
+	lastRefresh := time.Now()
+	for now := range ticker.C {
+		rows, err := db.Query("SELECT "+optionColumns+" FROM option_contracts WHERE status = 'open' AND expires_at <= ?", toDBTime(now))
+		if err == nil {
+			var due []OptionContract
+			for rows.Next() {
+				if c, err := scanOption(rows); err == nil {
+					due = append(due, c)
+				}
+			}
+			rows.Close()
+			for _, c := range due {
+				price, err := getStockPrice(c.StockID)
+				if err != nil {
+					continue
+				}
+				if err := settleOption(c, price); err != nil {
+					log.Printf("option %s settlement failed: %v", c.Symbol, err)
+				}
+			}
+		}
+		if now.Sub(lastRefresh) > 30*time.Second {
+			refreshOptionChains(now)
+			lastRefresh = now
+		}
+	}
+}
+
+// mark value of everything a user holds in options
+func optionPositionsValue(q querier, userID int64) float64 {
+	rows, err := q.Query("SELECT "+prefixColumns("c.", optionColumns)+", p.contracts FROM option_positions p JOIN option_contracts c ON c.id = p.contract_id WHERE p.user_id = ? AND c.status = 'open'", userID)
+	if err != nil {
+		return 0
+	}
+	var list []OptionContract
+	var qty []int64
+	for rows.Next() {
+		var c OptionContract
+		var expires string
+		var settle sql.NullFloat64
+		var n int64
+		if err := rows.Scan(&c.ID, &c.Symbol, &c.StockID, &c.Type, &c.Strike, &c.Multiplier, &expires, &c.Status, &settle, &n); err != nil {
+			continue
+		}
+		c.expires = parseDBTimeToLocal(expires)
+		list = append(list, c)
+		qty = append(qty, n)
+	}
+	rows.Close()
+
+	total := 0.0
+	vols := map[string]float64{}
+	now := time.Now()
+	for i := range list {
+		markOption(&list[i], vols, now)
+		total += float64(qty[i]) * list[i].Mark * list[i].Multiplier
+	}
+	return total
+}
+
+func prefixColumns(prefix, cols string) string {
+	parts := strings.Split(cols, ",")
+	for i, p := range parts {
+		parts[i] = prefix + strings.TrimSpace(p)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// GET /api/options/chain?stock=APEX (&expiry=... to pick one), open contracts with their marks
+func optionChainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	stock := strings.ToUpper(strings.TrimSpace(r.URL.Query().Get("stock")))
+	if stock == "" {
+		http.Error(w, "missing stock", http.StatusBadRequest)
+		return
+	}
+	comp, err := competitionFromRequest(r)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	rows, err := db.Query("SELECT "+optionColumns+" FROM option_contracts WHERE stock_id = ? AND status = 'open' ORDER BY expires_at ASC, strike ASC, type ASC", stock)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := []OptionContract{}
+	for rows.Next() {
+		c, err := scanOption(rows)
+		if err != nil {
+			rows.Close()
+			http.Error(w, "db scan error", http.StatusInternalServerError)
+			return
+		}
+		if e := r.URL.Query().Get("expiry"); e != "" && e != c.ExpiresAt {
+			continue
+		}
+		if !comp.allows(c.StockID) || !comp.expiresInside(c.expires) {
+			continue
+		}
+		out = append(out, c)
+	}
+	rows.Close()
+
+	vols := map[string]float64{}
+	now := time.Now()
+	for i := range out {
+		markOption(&out[i], vols, now)
+	}
+	writeJSON(w, map[string]interface{}{"stock": stock, "contracts": out, "count": len(out)})
+}
+
+type OptionPosition struct {
+	OptionContract
+	Contracts    int64   `json:"contracts"`
+	AvgPrice     float64 `json:"avg_price"` // premium per share paid
+	MarketValue  float64 `json:"market_value"`
+	UnrealizedPL float64 `json:"unrealized_pl"`
+}
+
+// GET /api/options/positions, the signed in user's open option positions at mark
+func optionPositionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	userID, err := parseUserIDFromRequest(r)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	rows, err := db.Query("SELECT "+prefixColumns("c.", optionColumns)+", p.contracts, p.avg_price FROM option_positions p JOIN option_contracts c ON c.id = p.contract_id WHERE p.user_id = ? ORDER BY c.expires_at ASC, c.symbol ASC", userID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := []OptionPosition{}
+	for rows.Next() {
+		var p OptionPosition
+		var expires string
+		var settle sql.NullFloat64
+		if err := rows.Scan(&p.ID, &p.Symbol, &p.StockID, &p.Type, &p.Strike, &p.Multiplier, &expires, &p.Status, &settle, &p.Contracts, &p.AvgPrice); err != nil {
+			rows.Close()
+			http.Error(w, "db scan error", http.StatusInternalServerError)
+			return
+		}
+		p.expires = parseDBTimeToLocal(expires)
+		p.ExpiresAt = p.expires.Format(time.RFC3339)
+		out = append(out, p)
+	}
+	rows.Close()
+
+	vols := map[string]float64{}
+	now := time.Now()
+	for i := range out {
+		markOption(&out[i].OptionContract, vols, now)
+		out[i].MarketValue = roundToTwo(float64(out[i].Contracts) * out[i].Mark * out[i].Multiplier)
+		out[i].UnrealizedPL = roundToTwo(out[i].MarketValue - float64(out[i].Contracts)*out[i].AvgPrice*out[i].Multiplier)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].expires.Before(out[j].expires) })
+	writeJSON(w, out)
+}
+
+// buys or sells contracts at the current mark. long only, selling closes what you hold
+// what contracts of c cost at its mark, in cents the same way costOf values shares
+func optionPremium(c OptionContract, contracts int64) Money {
+	return costOf(qtyFromFloat(float64(contracts)*c.Multiplier), c.Mark)
+}
+
+func tradeOption(userID int64, symbol, action string, contracts int64) (OptionContract, error) {
+	if contracts <= 0 || checkCount(contracts) != nil {
+		return OptionContract{}, errOutOfRange
+	}
+	defer lockAccount(userID)()
+	c, err := getOptionBySymbol(symbol)
+	if err == sql.ErrNoRows {
+		return c, errors.New("unknown option contract")
+	} else if err != nil {
+		return c, err
+	}
+	now := time.Now()
+	if c.Status != "open" || !c.expires.After(now) {
+		return c, errors.New("contract has expired")
+	}
+	if h := haltFor(c.StockID); h != nil {
+		return c, errors.New("trading in " + c.StockID + " is halted: " + h.Reason)
+	}
+	comp, err := accountCompetition(db, userID)
+	if err != nil {
+		return c, err
+	}
+	if err := comp.checkOpen(now); err != nil {
+		return c, err
+	}
+	if !comp.allows(c.StockID) {
+		return c, errors.New(c.StockID + " isnt part of " + comp.Name)
+	}
+	if !comp.expiresInside(c.expires) {
+		return c, errors.New("contract expires outside " + comp.Name)
+	}
+	markOption(&c, map[string]float64{}, now)
+	if c.Mark < 0.01 {
+		// nobody sells lottery tickets for free
+		c.Mark = 0.01
+	}
+	premium := optionPremium(c, contracts)
+	if premium <= 0 || premium == math.MaxInt64 {
+		return c, errOutOfRange
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return c, err
+	}
+	// only the trade rate rule applies to options, the position rules are about stocks
+	if err := checkTradeRate(tx, comp.rules(), userID); err != nil {
+		tx.Rollback()
+		return c, err
+	}
+	var held int64
+	var avg float64
+	err = tx.QueryRow("SELECT contracts, avg_price FROM option_positions WHERE user_id = ? AND contract_id = ?", userID, c.ID).Scan(&held, &avg)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return c, err
+	}
+
+	switch action {
+	case "buy":
+		var cash Money
+		if err := tx.QueryRow("SELECT cash FROM users WHERE id = ?", userID).Scan(&cash); err != nil {
+			tx.Rollback()
+			return c, errors.New("user not found")
+		}
+		if cash < premium {
+			tx.Rollback()
+			return c, errors.New("insufficient funds")
+		}
+		newAvg := (float64(held)*avg + float64(contracts)*c.Mark) / float64(held+contracts)
+		_, err = tx.Exec(`INSERT INTO option_positions (user_id, contract_id, contracts, avg_price) VALUES (?, ?, ?, ?)
+			ON CONFLICT(user_id, contract_id) DO UPDATE SET contracts = excluded.contracts, avg_price = excluded.avg_price`, userID, c.ID, held+contracts, newAvg)
+		if err == nil {
+			_, err = tx.Exec("UPDATE users SET cash = cash - ? WHERE id = ?", premium, userID)
+		}
+	case "sell":
+		if held < contracts {
+			tx.Rollback()
+			return c, errors.New("not enough contracts (writing options isnt supported)")
+		}
+		if held == contracts {
+			_, err = tx.Exec("DELETE FROM option_positions WHERE user_id = ? AND contract_id = ?", userID, c.ID)
+		} else {
+			_, err = tx.Exec("UPDATE option_positions SET contracts = ? WHERE user_id = ? AND contract_id = ?", held-contracts, userID, c.ID)
+		}
+		if err == nil {
+			_, err = tx.Exec("UPDATE users SET cash = cash + ? WHERE id = ?", premium, userID)
+		}
+	default:
+		tx.Rollback()
+		return c, errors.New("action must be buy or sell")
+	}
+	if err != nil {
+		tx.Rollback()
+		return c, err
+	}
+	if _, err := recordTransaction(tx, txnRow{UserID: userID, StockID: c.Symbol, Action: "option_" + action, Shares: wholeShares(contracts), Price: c.Mark, Amount: premium}); err != nil {
+		tx.Rollback()
+		return c, err
+	}
+	return c, tx.Commit()
+}
+
+// POST /api/options/trade {"symbol": "APEX-0831T2359-C-250", "action": "buy", "contracts": 2}
+func optionTradeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, err := parseUserIDFromRequest(r)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	var req struct {
+		Symbol    string `json:"symbol"`
+		Action    string `json:"action"`
+		Contracts int64  `json:"contracts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	if req.Contracts <= 0 {
+		http.Error(w, "contracts must be > 0", http.StatusBadRequest)
+		return
+	}
+	if err := checkCount(req.Contracts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// same lock as stock trades, a split rewrites strikes
+	corpActionLock.RLock()
+	c, err := tradeOption(userID, req.Symbol, strings.ToLower(strings.TrimSpace(req.Action)), req.Contracts)
+	corpActionLock.RUnlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var cash Money
+	_ = db.QueryRow("SELECT cash FROM users WHERE id = ?", userID).Scan(&cash)
+	writeJSON(w, map[string]interface{}{
+		"status":    "ok",
+		"contract":  c,
+		"action":    req.Action,
+		"contracts": req.Contracts,
+		"price":     c.Mark,
+		"total":     optionPremium(c, req.Contracts),
+		"cash":      cash,
+	})
+}
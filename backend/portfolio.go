@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
 	"sort"
 	"strconv"
@@ -14,11 +15,11 @@ import (
 type Holding struct {
 	StockID        string  `json:"stock_id"`
 	Name           string  `json:"name,omitempty"`
-	Shares         int64   `json:"shares"`
+	Shares         Qty     `json:"shares"`
 	AvgPrice       float64 `json:"avg_price"`
 	CurrentPrice   float64 `json:"current_price"`
-	MarketValue    float64 `json:"market_value"`
-	UnrealizedPL   float64 `json:"unrealized_pl"`
+	MarketValue    Money   `json:"market_value"`
+	UnrealizedPL   Money   `json:"unrealized_pl"`
 	UnrealizedPLPc float64 `json:"unrealized_pl_pct"`
 	DailyChange    float64 `json:"daily_change"` // was gonna implement something like this, but it never worked out. im too scared to take change it now lol, frontend doesnt ask daily change
 	DailyChangePL  float64 `json:"daily_change_pl"`
@@ -37,10 +38,14 @@ type TeamInfo struct {
 type PortfolioSummary struct {
 	UserID             int64    `json:"user_id"`
 	Username           string   `json:"username"`
-	Cash               float64  `json:"cash"`
-	Networth           float64  `json:"networth"`
-	TotalUnrealizedPL  float64  `json:"total_unrealized_pl"`
-	TotalGainSincePrev float64  `json:"total_gain_since_prev"`
+	Cash               Money    `json:"cash"`
+	IPOEscrow          Money    `json:"ipo_escrow,omitempty"` // cash held for open ipo subscriptions
+	OptionsValue       Money    `json:"options_value,omitempty"`
+	BondsValue         Money    `json:"bonds_value,omitempty"`
+	Networth           Money    `json:"networth"`
+	TotalUnrealizedPL  Money    `json:"total_unrealized_pl"`
+	TotalRealizedPL    Money    `json:"total_realized_pl"` // closed tax lots, after fees
+	TotalGainSincePrev Money    `json:"total_gain_since_prev"`
 	TotalGainPct       float64  `json:"total_gain_pct"`
 	Diversification    int      `json:"diversification"`
 	LeaderPosition     int      `json:"leaderboard_position,omitempty"`
@@ -54,12 +59,23 @@ type TransactionOut struct {
 	Timestamp string  `json:"timestamp"`
 	StockID   string  `json:"stock_id"`
 	Action    string  `json:"action"`
-	Shares    int64   `json:"shares"`
+	Shares    Qty     `json:"shares"`
 	Price     float64 `json:"price"`
-	Total     float64 `json:"total"`
+	Total     Money   `json:"total"`
+	Fee       Money   `json:"fee,omitempty"`
+	Realized  Money   `json:"realized_pl,omitempty"` // sells, buybacks and delistings
 }
 
+// the account for the request's competition (see competitions.go), from the login in the cookie or user_id
 func parseUserIDFromRequest(r *http.Request) (int64, error) {
+	login, err := loginFromRequest(r)
+	if err != nil {
+		return 0, err
+	}
+	return accountInContext(r, login)
+}
+
+func loginFromRequest(r *http.Request) (int64, error) {
 	// accepts cookie or user_id
 	if c, err := r.Cookie("stocksim_user"); err == nil && c.Value != "" {
 		if id, err := strconv.ParseInt(c.Value, 10, 64); err == nil && id > 0 {
@@ -86,15 +102,16 @@ func portfolioHandler(w http.ResponseWriter, r *http.Request) {
 
 	userID, err := parseUserIDFromRequest(r)
 	if err != nil {
-		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		writeAuthError(w, err)
 		return
 	}
 
 	// fetch user's data including team info
-	var cash float64
+	var cash Money
 	var username string
 	var teamID sql.NullInt64
-	err = db.QueryRow("SELECT school_code, cash, team_id FROM users WHERE id = ?", userID).Scan(&username, &cash, &teamID)
+	var compID int64
+	err = db.QueryRow("SELECT "+accountNameSQL+", u.cash, u.team_id, u.competition_id FROM users u WHERE u.id = ?", userID).Scan(&username, &cash, &teamID, &compID)
 	if err == sql.ErrNoRows {
 		http.Error(w, "user not found", http.StatusNotFound)
 		return
@@ -104,7 +121,7 @@ func portfolioHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// load holdings
-	rows, err := db.Query("SELECT stock_id, shares, avg_price FROM portfolio WHERE user_id = ?", userID)
+	rows, err := db.Query("SELECT stock_id, shares, avg_price, cost FROM portfolio WHERE user_id = ?", userID)
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
@@ -112,14 +129,15 @@ func portfolioHandler(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	holdings := []Holding{}
-	var totalMarketValue float64
-	var totalUnrealizedPL float64
+	var totalMarketValue Money
+	var totalUnrealizedPL Money
 
 	for rows.Next() {
 		var stockID string
-		var shares int64
+		var shares Qty
 		var avgPrice float64
-		if err := rows.Scan(&stockID, &shares, &avgPrice); err != nil {
+		var cost Money
+		if err := rows.Scan(&stockID, &shares, &avgPrice, &cost); err != nil {
 			http.Error(w, "db scan error", http.StatusInternalServerError)
 			return
 		}
@@ -128,8 +146,8 @@ func portfolioHandler(w http.ResponseWriter, r *http.Request) {
 		if perr != nil {
 			price = 0
 		}
-		marketVal := float64(shares) * price
-		unrealized := marketVal - float64(shares)*avgPrice
+		marketVal := costOf(shares, price)
+		unrealized := marketVal - cost
 
 		// previous close for daily calculation (if not available, prevClose == price)
 		prevClose, _ := getPreviousClose(stockID)
@@ -138,7 +156,7 @@ func portfolioHandler(w http.ResponseWriter, r *http.Request) {
 			prevClose = price
 		}
 		dailyChange := price - prevClose
-		dailyPL := float64(shares) * dailyChange
+		dailyPL := shares.Float() * dailyChange
 
 		h := Holding{
 			StockID:       stockID,
@@ -161,30 +179,33 @@ func portfolioHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	for i := range holdings {
 		if totalMarketValue > 0 {
-			holdings[i].AllocationPct = (holdings[i].MarketValue / totalMarketValue) * 100.0
+			holdings[i].AllocationPct = (holdings[i].MarketValue.Float() / totalMarketValue.Float()) * 100.0
 		} else {
 			holdings[i].AllocationPct = 0
 		}
 	}
 
 	// compute networth and previous networth (using prevClose)
-	networth := cash + totalMarketValue
+	escrow := ipoEscrow(db, userID)
+	optionsValue := moneyFromFloat(optionPositionsValue(db, userID))
+	bondsValue := moneyFromFloat(bondPositionsValue(db, userID))
+	networth := cash + escrow + optionsValue + bondsValue + totalMarketValue
 
-	previousMarketValue := 0.0
+	var previousMarketValue Money
 	for _, h := range holdings {
-		previousMarketValue += float64(h.Shares) * h.PrevClose
+		previousMarketValue += costOf(h.Shares, h.PrevClose)
 	}
-	previousNetworth := cash + previousMarketValue
+	previousNetworth := cash + escrow + optionsValue + bondsValue + previousMarketValue
 	totalGain := networth - previousNetworth
 	totalGainPct := 0.0
 	if previousNetworth > 0 {
-		totalGainPct = (totalGain / previousNetworth) * 100.0
+		totalGainPct = (totalGain.Float() / previousNetworth.Float()) * 100.0
 	}
 
 	diversification := len(holdings)
 
 	leaderPos, leaderTotal := computeLeaderboardPosition(userID)
-	teamInfo := getTeamInfo(teamID)
+	teamInfo := getTeamInfo(teamID, compID)
 
 	lastUpdated := time.Now().Local().Format(time.RFC3339)
 
@@ -192,9 +213,13 @@ func portfolioHandler(w http.ResponseWriter, r *http.Request) {
 		UserID:             userID,
 		Username:           username,
 		Cash:               cash,
-		Networth:           roundToTwo(networth),
-		TotalUnrealizedPL:  roundToTwo(totalUnrealizedPL),
-		TotalGainSincePrev: roundToTwo(totalGain),
+		IPOEscrow:          escrow,
+		OptionsValue:       optionsValue,
+		BondsValue:         bondsValue,
+		Networth:           networth,
+		TotalUnrealizedPL:  totalUnrealizedPL,
+		TotalRealizedPL:    moneyFromFloat(realizedPL(userID)),
+		TotalGainSincePrev: totalGain,
 		TotalGainPct:       roundToTwo(totalGainPct),
 		Diversification:    diversification,
 		LeaderPosition:     leaderPos,
@@ -225,7 +250,7 @@ func portfolioHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // pretty sure this is duplicate - maybe something to fix later?
-func getTeamInfo(teamID sql.NullInt64) TeamInfo {
+func getTeamInfo(teamID sql.NullInt64, compID int64) TeamInfo {
 	teamInfo := TeamInfo{}
 
 	if !teamID.Valid {
@@ -237,32 +262,32 @@ func getTeamInfo(teamID sql.NullInt64) TeamInfo {
 		teamInfo.TeamName = &teamName
 
 		var memberCount int
-		if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE team_id = ?", teamID.Int64).Scan(&memberCount); err == nil {
+		if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE team_id = ? AND competition_id = ?", teamID.Int64, compID).Scan(&memberCount); err == nil {
 			teamInfo.MemberCount = &memberCount
 		}
-		teamValue := calculateTeamValue(teamID.Int64)
+		teamValue := calculateTeamValue(teamID.Int64, compID)
 		teamInfo.TeamValue = &teamValue
-		teamRank := calculateTeamRank(teamID.Int64, teamValue)
+		teamRank := calculateTeamRank(teamID.Int64, compID, teamValue)
 		teamInfo.TeamRank = &teamRank
 	}
 
 	return teamInfo
 }
 
-func calculateTeamValue(teamID int64) float64 {
-	rows, err := db.Query("SELECT id FROM users WHERE team_id = ?", teamID)
+func calculateTeamValue(teamID, compID int64) float64 {
+	rows, err := db.Query("SELECT id FROM users WHERE team_id = ? AND competition_id = ?", teamID, compID)
 	if err != nil {
 		return 0
 	}
 	defer rows.Close()
 
-	var totalValue float64
+	var totalValue Money
 	for rows.Next() {
 		var userID int64
 		if err := rows.Scan(&userID); err != nil {
 			continue
 		}
-		var cash float64
+		var cash Money
 		if err := db.QueryRow("SELECT cash FROM users WHERE id = ?", userID).Scan(&cash); err != nil {
 			continue
 		}
@@ -270,11 +295,11 @@ func calculateTeamValue(teamID int64) float64 {
 		totalValue += cash + portfolioValue
 	}
 
-	return roundToTwo(totalValue)
+	return totalValue.Float()
 }
 
-func calculateTeamRank(teamID int64, teamValue float64) int {
-	rows, err := db.Query("SELECT DISTINCT team_id FROM users WHERE team_id IS NOT NULL")
+func calculateTeamRank(teamID, compID int64, teamValue float64) int {
+	rows, err := db.Query("SELECT DISTINCT team_id FROM users WHERE team_id IS NOT NULL AND competition_id = ?", compID)
 	if err != nil {
 		return 0
 	}
@@ -291,7 +316,7 @@ func calculateTeamRank(teamID int64, teamValue float64) int {
 			continue
 		}
 
-		otherTeamValue := calculateTeamValue(otherTeamID)
+		otherTeamValue := calculateTeamValue(otherTeamID, compID)
 		if otherTeamValue > teamValue {
 			rank++
 		}
@@ -312,7 +337,7 @@ func transactionsHandler(w http.ResponseWriter, r *http.Request) {
 
 	userID, err := parseUserIDFromRequest(r)
 	if err != nil {
-		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		writeAuthError(w, err)
 		return
 	}
 
@@ -323,7 +348,7 @@ func transactionsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	rows, err := db.Query("SELECT id, timestamp, stock_id, action, shares, price FROM transactions WHERE user_id = ? ORDER BY timestamp DESC LIMIT ?", userID, limit)
+	rows, err := db.Query("SELECT id, timestamp, stock_id, action, shares, price, amount, fee, realized_pl FROM transactions WHERE user_id = ? ORDER BY timestamp DESC LIMIT ?", userID, limit)
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
@@ -335,9 +360,10 @@ func transactionsHandler(w http.ResponseWriter, r *http.Request) {
 		var id int64
 		var ts sql.NullString
 		var stockID, action string
-		var shares int64
+		var shares Qty
 		var price float64
-		if err := rows.Scan(&id, &ts, &stockID, &action, &shares, &price); err != nil {
+		var amount, fee, realized Money
+		if err := rows.Scan(&id, &ts, &stockID, &action, &shares, &price, &amount, &fee, &realized); err != nil {
 			http.Error(w, "db scan error", http.StatusInternalServerError)
 			return
 		}
@@ -346,14 +372,20 @@ func transactionsHandler(w http.ResponseWriter, r *http.Request) {
 			parsed := parseDBTimeToLocal(ts.String)
 			tstr = parsed.Format("2006-01-02 15:04:05 MST")
 		}
+		total := amount
+		if total == 0 {
+			total = costOf(shares, price)
+		}
 		out = append(out, TransactionOut{
 			ID:        id,
 			Timestamp: tstr,
 			StockID:   stockID,
-			Action:    strings.Title(action),
+			Action:    strings.Title(strings.ReplaceAll(action, "_", " ")),
 			Shares:    shares,
 			Price:     roundToTwo(price),
-			Total:     roundToTwo(float64(shares) * price),
+			Total:     total,
+			Fee:       fee,
+			Realized:  realized,
 		})
 	}
 	if err := rows.Err(); err != nil {
@@ -402,7 +434,8 @@ func getPreviousClose(stockID string) (float64, error) {
 }
 
 func computeLeaderboardPosition(userID int64) (int, int) {
-	rows, err := db.Query("SELECT id, cash FROM users")
+	// ranked against the accounts in the same competition
+	rows, err := db.Query("SELECT id, cash FROM users WHERE competition_id = (SELECT competition_id FROM users WHERE id = ?)", userID)
 	if err != nil {
 		return 0, 0
 	}
@@ -410,12 +443,12 @@ func computeLeaderboardPosition(userID int64) (int, int) {
 
 	type userNet struct {
 		id  int64
-		net float64
+		net Money
 	}
 	users := []userNet{}
 	for rows.Next() {
 		var id int64
-		var cash float64
+		var cash Money
 		if err := rows.Scan(&id, &cash); err != nil {
 			continue
 		}
@@ -423,10 +456,10 @@ func computeLeaderboardPosition(userID int64) (int, int) {
 		if err != nil {
 			continue
 		}
-		total := cash
+		total := cash + ipoEscrow(db, id) + moneyFromFloat(optionPositionsValue(db, id)+bondPositionsValue(db, id))
 		for hrows.Next() {
 			var sid string
-			var shares int64
+			var shares Qty
 			if err := hrows.Scan(&sid, &shares); err != nil {
 				continue
 			}
@@ -434,7 +467,7 @@ func computeLeaderboardPosition(userID int64) (int, int) {
 			if perr != nil {
 				price = 0
 			}
-			total += float64(shares) * price
+			total += costOf(shares, price)
 		}
 		hrows.Close()
 		users = append(users, userNet{id: id, net: total})
@@ -481,12 +514,5 @@ func parseDBTimeToLocal(src string) time.Time {
 
 // some basic math helpers
 func roundToTwo(f float64) float64 {
-	return mathRound(f*100.0) / 100.0
-}
-
-func mathRound(f float64) float64 {
-	if f < 0 {
-		return float64(int64(f - 0.5))
-	}
-	return float64(int64(f + 0.5))
+	return math.Round(f*100.0) / 100.0
 }
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// a user whose cash, escrow or shares dont match what their transactions or the ledger add up to.
+// symbol is only set for shares, user 0 is a journal that doesnt balance
+type ReconcileIssue struct {
+	Check    string `json:"check"`   // transactions or ledger
+	Account  string `json:"account"` // cash, escrow, shares or journal
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+	Symbol   string `json:"symbol,omitempty"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// replays every transactions row: starting cash plus each row's cash effect should be the user's cash
+// (plus whatever sits in ipo escrow, that moves without a row), and buys minus sells should be the position
+func reconcileAccounts() ([]ReconcileIssue, error) {
+	type account struct {
+		name   string
+		cash   Money
+		ledger Money
+		held   map[string]Qty
+		shares map[string]Qty
+	}
+	accounts := map[int64]*account{}
+	var order []int64
+
+	// every read below comes from one snapshot so a trade landing halfway through cant show up as a mismatch.
+	// read only starts it deferred, trades keep going while it runs
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// every account starts from its competition's cash, accounts from before competitions have no starting_cash
+	rows, err := tx.Query("SELECT id, school_code, cash, COALESCE(starting_cash, ?) FROM users ORDER BY id ASC", startingCash)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		a := &account{held: map[string]Qty{}, shares: map[string]Qty{}}
+		if err := rows.Scan(&id, &a.name, &a.cash, &a.ledger); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		accounts[id] = a
+		order = append(order, id)
+	}
+	rows.Close()
+
+	// what sits in open ipo subscriptions, the same sum ipoEscrow takes for one user
+	escrow := map[int64]Money{}
+	rows, err = tx.Query("SELECT s.user_id, SUM(s.escrow) FROM ipo_subscriptions s JOIN ipos i ON i.id = s.ipo_id WHERE i.status = 'open' GROUP BY s.user_id")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		var sum Money
+		if err := rows.Scan(&id, &sum); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		escrow[id] = sum
+	}
+	rows.Close()
+
+	rows, err = tx.Query("SELECT user_id, stock_id, shares FROM portfolio WHERE shares > 0")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		var stockID string
+		var shares Qty
+		if err := rows.Scan(&id, &stockID, &shares); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if a := accounts[id]; a != nil {
+			a.held[stockID] = shares
+		}
+	}
+	rows.Close()
+
+	rows, err = tx.Query("SELECT user_id, stock_id, action, shares, amount, fee FROM transactions ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		var stockID, action string
+		var shares Qty
+		var amount, fee Money
+		if err := rows.Scan(&id, &stockID, &action, &shares, &amount, &fee); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		a := accounts[id]
+		if a == nil {
+			continue
+		}
+		a.ledger += cashEffect(action, amount, fee)
+		switch action {
+		case "buy", "ipo":
+			a.shares[stockID] += shares
+		case "sell", "delist", "buyback":
+			a.shares[stockID] -= shares
+		case "split":
+			// split rows carry the position after the split
+			a.shares[stockID] = shares
+		}
+	}
+	rows.Close()
+
+	var issues []ReconcileIssue
+	for _, id := range order {
+		a := accounts[id]
+		if got := a.cash + escrow[id]; got != a.ledger {
+			issues = append(issues, ReconcileIssue{Check: "transactions", Account: "cash", UserID: id, Username: a.name, Expected: a.ledger.String(), Actual: got.String()})
+		}
+		for stockID, want := range a.shares {
+			if got := a.held[stockID]; got != want {
+				issues = append(issues, ReconcileIssue{Check: "transactions", Account: "shares", UserID: id, Username: a.name, Symbol: stockID, Expected: want.String(), Actual: got.String()})
+			}
+		}
+		for stockID, got := range a.held {
+			if _, ok := a.shares[stockID]; !ok {
+				issues = append(issues, ReconcileIssue{Check: "transactions", Account: "shares", UserID: id, Username: a.name, Symbol: stockID, Expected: "0", Actual: got.String()})
+			}
+		}
+	}
+
+	ledgerIssues, err := reconcileLedger(tx, order, escrow, func(id int64) (string, Money, map[string]Qty) {
+		a := accounts[id]
+		return a.name, a.cash, a.held
+	})
+	if err != nil {
+		return nil, err
+	}
+	issues = append(issues, ledgerIssues...)
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].UserID != issues[j].UserID {
+			return issues[i].UserID < issues[j].UserID
+		}
+		if issues[i].Check != issues[j].Check {
+			return issues[i].Check > issues[j].Check
+		}
+		return issues[i].Symbol < issues[j].Symbol
+	})
+	return issues, nil
+}
+
+// the balances on the tables against the ledger: users.cash with user:<id>, open ipo escrow with
+// escrow:<id> and every position with the user's share balance. also any journal that doesnt net to zero.
+// the tables side comes from what reconcileAccounts already read in the same tx
+func reconcileLedger(tx *sql.Tx, users []int64, escrow map[int64]Money, account func(int64) (string, Money, map[string]Qty)) ([]ReconcileIssue, error) {
+	var issues []ReconcileIssue
+
+	rows, err := tx.Query("SELECT journal_id, asset, SUM(amount) FROM ledger_entries GROUP BY journal_id, asset HAVING SUM(amount) != 0")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var journalID, sum int64
+		var asset string
+		if err := rows.Scan(&journalID, &asset, &sum); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		issues = append(issues, ReconcileIssue{Check: "ledger", Account: "journal", Symbol: asset, Expected: "0", Actual: fmt.Sprintf("%s in journal %d", formatLedger(asset, sum), journalID)})
+	}
+	rows.Close()
+
+	ledger := map[string]map[string]int64{}
+	rows, err = tx.Query("SELECT account, asset, SUM(amount) FROM ledger_entries WHERE account LIKE 'user:%' OR account LIKE 'escrow:%' GROUP BY account, asset")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var account, asset string
+		var sum int64
+		if err := rows.Scan(&account, &asset, &sum); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if ledger[account] == nil {
+			ledger[account] = map[string]int64{}
+		}
+		ledger[account][asset] = sum
+	}
+	rows.Close()
+
+	for _, id := range users {
+		name, cash, held := account(id)
+		user := ledger[userAccount(id)]
+		if want := Money(user[ledgerCash]); want != cash {
+			issues = append(issues, ReconcileIssue{Check: "ledger", Account: "cash", UserID: id, Username: name, Expected: want.String(), Actual: cash.String()})
+		}
+		if want := Money(ledger[escrowAccount(id)][ledgerCash]); want != escrow[id] {
+			issues = append(issues, ReconcileIssue{Check: "ledger", Account: "escrow", UserID: id, Username: name, Expected: want.String(), Actual: escrow[id].String()})
+		}
+		for asset, sum := range user {
+			if asset == ledgerCash {
+				continue
+			}
+			if got := held[asset]; Qty(sum) != got {
+				issues = append(issues, ReconcileIssue{Check: "ledger", Account: "shares", UserID: id, Username: name, Symbol: asset, Expected: Qty(sum).String(), Actual: got.String()})
+			}
+		}
+		for stockID, got := range held {
+			if _, ok := user[stockID]; !ok {
+				issues = append(issues, ReconcileIssue{Check: "ledger", Account: "shares", UserID: id, Username: name, Symbol: stockID, Expected: "0", Actual: got.String()})
+			}
+		}
+	}
+	return issues, nil
+}
+
+func formatLedger(asset string, amount int64) string {
+	if asset == ledgerCash {
+		return Money(amount).String()
+	}
+	return Qty(amount).String()
+}
+
+// run on startup, only reports. fixing someones cash is an admin decision
+func checkReconciliation() {
+	issues, err := reconcileAccounts()
+	if err != nil {
+		log.Printf("Reconciliation failed: %v", err)
+		return
+	}
+	for _, i := range issues {
+		if i.Account == "journal" {
+			log.Printf("Reconciliation: %s %s doesnt balance", i.Actual, i.Symbol)
+			continue
+		}
+		what := i.Account
+		if i.Symbol != "" {
+			what = i.Symbol + " shares"
+		}
+		log.Printf("Reconciliation: user %d (%s) %s is %s, %s add up to %s", i.UserID, i.Username, what, i.Actual, i.Check, i.Expected)
+	}
+	if len(issues) == 0 {
+		log.Println("Reconciliation: every account matches its transactions and the ledger")
+	}
+}
+
+// reruns the check every night at midnight, started from main
+func reconcileWatcher() {
+	for {
+		now := time.Now()
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		time.Sleep(midnight.Sub(now))
+		checkReconciliation()
+	}
+}
+
+// GET /api/admin/reconcile, runs the check now and lists whatever doesnt add up
+func adminReconcileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	issues, err := reconcileAccounts()
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if issues == nil {
+		issues = []ReconcileIssue{}
+	}
+	writeJSON(w, map[string]interface{}{"ok": len(issues) == 0, "issues": issues, "checked_at": time.Now().UTC().Format(time.RFC3339)})
+}
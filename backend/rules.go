@@ -0,0 +1,191 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// "rules" in config.json, limits every stock/etf trade has to pass. 0 turns a rule off
+type TradingRules struct {
+	MaxStockPercent    float64 `json:"max_stock_percent,omitempty"`  // 0.25 = a buy cant take one stock past 25% of equity
+	MaxSectorPercent   float64 `json:"max_sector_percent,omitempty"` // same for every stock in a sector together, etfs dont count
+	MaxPositionValue   float64 `json:"max_position_value,omitempty"` // dollars in one stock
+	MaxPositionShares  float64 `json:"max_position_shares,omitempty"`
+	MaxTradesPerMinute int     `json:"max_trades_per_minute,omitempty"` // buys and sells, every batch leg and option/bond order counts
+	MinHoldSeconds     int     `json:"min_hold_seconds,omitempty"`      // shares bought more recently than this cant be sold
+}
+
+var tradingRules = &TradingRules{}
+
+// a line per rule that is on, for the rules endpoint
+func (r *TradingRules) describe() []string {
+	out := []string{}
+	if r.MaxStockPercent > 0 {
+		out = append(out, fmt.Sprintf("No more than %.4g%% of your equity in one stock", r.MaxStockPercent*100))
+	}
+	if r.MaxSectorPercent > 0 {
+		out = append(out, fmt.Sprintf("No more than %.4g%% of your equity in one sector", r.MaxSectorPercent*100))
+	}
+	if r.MaxPositionValue > 0 {
+		out = append(out, fmt.Sprintf("No position worth more than %s", moneyFromFloat(r.MaxPositionValue)))
+	}
+	if r.MaxPositionShares > 0 {
+		out = append(out, fmt.Sprintf("No position bigger than %s shares", qtyFromFloat(r.MaxPositionShares)))
+	}
+	if r.MaxTradesPerMinute > 0 {
+		out = append(out, fmt.Sprintf("At most %d trades a minute", r.MaxTradesPerMinute))
+	}
+	if r.MinHoldSeconds > 0 {
+		out = append(out, fmt.Sprintf("Shares must be held for %s before they can be sold", time.Duration(r.MinHoldSeconds)*time.Second))
+	}
+	return out
+}
+
+// runs inside the trade's transaction before anything is written, r is the account's competition's rules.
+// cash is before the trade, cost and fee are the trade's, method is the sell's lot method.
+// a broken rule comes back as a tradeRejected saying which one
+func checkTradeRules(tx *sql.Tx, r *TradingRules, userID int64, stockID, action, method string, shares Qty, price float64, cost, fee, cash Money) error {
+	if err := checkTradeRate(tx, r, userID); err != nil {
+		return err
+	}
+
+	if action == "sell" {
+		if r.MinHoldSeconds <= 0 {
+			return nil
+		}
+		hold := time.Duration(r.MinHoldSeconds) * time.Second
+		// walk the lots in the order closeLots will close them, lifo gets to the newest shares first
+		order := "ASC"
+		if lotMethod(method) == "lifo" {
+			order = "DESC"
+		}
+		rows, err := tx.Query("SELECT shares, opened_at > ? FROM tax_lots WHERE user_id = ? AND stock_id = ? AND shares > 0 ORDER BY opened_at "+order+", id "+order,
+			toDBTime(time.Now().Add(-hold)), userID, stockID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		var held, free Qty
+		young := false
+		for rows.Next() {
+			var n Qty
+			var tooNew bool
+			if err := rows.Scan(&n, &tooNew); err != nil {
+				return err
+			}
+			held += n
+			if tooNew {
+				young = true
+			}
+			if !young {
+				free += n
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if shares > free {
+			msg := fmt.Sprintf("rule: shares must be held for %s before selling, %s of your %s %s can be sold now", hold, free, held, stockID)
+			if order == "DESC" {
+				msg += " with " + lotMethod(method) + ", fifo sells the oldest shares first"
+			}
+			return tradeRejected(msg)
+		}
+		return nil
+	}
+
+	if r.MaxStockPercent <= 0 && r.MaxSectorPercent <= 0 && r.MaxPositionValue <= 0 && r.MaxPositionShares <= 0 {
+		return nil
+	}
+	held, err := batchHoldings(tx, userID)
+	if err != nil {
+		return err
+	}
+	type info struct {
+		price  float64
+		sector string
+		etf    bool
+	}
+	stocksLock.Lock()
+	infos := make(map[string]info, len(stocks))
+	for _, s := range stocks {
+		infos[s.ID] = info{s.Price, s.Sector, s.Kind == basketKind}
+	}
+	stocksLock.Unlock()
+	// the stock being bought is valued at the trade's price, not whatever ticked since
+	if in, ok := infos[stockID]; ok {
+		in.price = price
+		infos[stockID] = in
+	}
+
+	// the rest of the account counts the same way the portfolio and leaderboard value it
+	equity := cash - fee + ipoEscrow(tx, userID) + moneyFromFloat(optionPositionsValue(tx, userID)+bondPositionsValue(tx, userID))
+	values := map[string]Money{}
+	for id, q := range held {
+		values[id] = costOf(q, infos[id].price)
+		equity += values[id]
+	}
+	position := values[stockID] + cost
+	positionShares := held[stockID] + shares
+
+	if r.MaxPositionShares > 0 && positionShares > qtyFromFloat(r.MaxPositionShares) {
+		return tradeRejected(fmt.Sprintf("rule: no position bigger than %s shares, this would make %s %s", qtyFromFloat(r.MaxPositionShares), positionShares, stockID))
+	}
+	if r.MaxPositionValue > 0 && position > moneyFromFloat(r.MaxPositionValue) {
+		return tradeRejected(fmt.Sprintf("rule: no position worth more than %s, this would make %s worth %s", moneyFromFloat(r.MaxPositionValue), stockID, position))
+	}
+	if equity <= 0 {
+		return nil
+	}
+	if r.MaxStockPercent > 0 {
+		if pct := position.Float() / equity.Float(); pct > r.MaxStockPercent {
+			return tradeRejected(fmt.Sprintf("rule: no more than %.4g%% of equity in one stock, this would put %.1f%% in %s", r.MaxStockPercent*100, pct*100, stockID))
+		}
+	}
+	if in := infos[stockID]; r.MaxSectorPercent > 0 && !in.etf && in.sector != "" {
+		sector := position
+		for id, v := range values {
+			if id != stockID && !infos[id].etf && infos[id].sector == in.sector {
+				sector += v
+			}
+		}
+		if pct := sector.Float() / equity.Float(); pct > r.MaxSectorPercent {
+			return tradeRejected(fmt.Sprintf("rule: no more than %.4g%% of equity in one sector, this would put %.1f%% in %s", r.MaxSectorPercent*100, pct*100, in.sector))
+		}
+	}
+	return nil
+}
+
+// max_trades_per_minute, the one rule option and bond orders go through as well. stock, option and
+// bond buys and sells all count towards it
+func checkTradeRate(tx *sql.Tx, r *TradingRules, userID int64) error {
+	if r.MaxTradesPerMinute <= 0 {
+		return nil
+	}
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM transactions WHERE user_id = ? AND action IN ('buy', 'sell', 'option_buy', 'option_sell', 'bond_buy', 'bond_sell') AND timestamp >= ?",
+		userID, toDBTime(time.Now().Add(-time.Minute))).Scan(&n); err != nil {
+		return err
+	}
+	if n >= r.MaxTradesPerMinute {
+		return tradeRejected(fmt.Sprintf("rule: at most %d trades a minute, try again shortly", r.MaxTradesPerMinute))
+	}
+	return nil
+}
+
+// GET /api/rules, the trading limits in force for the request's competition
+func rulesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	comp, err := competitionFromRequest(r)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	rules := comp.rules()
+	writeJSON(w, map[string]interface{}{"competition": comp.Slug, "rules": rules, "descriptions": rules.describe()})
+}
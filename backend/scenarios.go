@@ -0,0 +1,621 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// one step of a scenario, every targeted stock gets its own price path on the market engine
+type ScenarioStage struct {
+	Label    string   `json:"label,omitempty"`
+	Stocks   []string `json:"stocks,omitempty"`
+	Sector   string   `json:"sector,omitempty"`
+	All      bool     `json:"all,omitempty"`
+	Move     float64  `json:"move"`              // 0.2 = +20%, -0.3 = -30%
+	At       *float64 `json:"at,omitempty"`      // seconds from the start, default is right after the previous stage ends
+	Duration float64  `json:"duration"`          // seconds each stock takes to make the move
+	Stagger  float64  `json:"stagger,omitempty"` // seconds between one stock starting and the next
+}
+
+type Scenario struct {
+	ID          int64           `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Stages      []ScenarioStage `json:"stages"`
+	CreatedAt   string          `json:"created_at,omitempty"`
+	UpdatedAt   string          `json:"updated_at,omitempty"`
+}
+
+// one stock's part of one stage, start is seconds from the scenario start
+type scenarioLeg struct {
+	Stage    int     `json:"stage"`
+	Label    string  `json:"label"`
+	Stock    string  `json:"stock"`
+	Move     float64 `json:"move"`
+	Start    float64 `json:"start"`
+	Duration float64 `json:"duration"`
+}
+
+type ScenarioRun struct {
+	ID         int64     `json:"id"`
+	ScenarioID int64     `json:"scenario_id"`
+	Name       string    `json:"name"`
+	Legs       int       `json:"legs"`
+	Remaining  int       `json:"remaining"`
+	Status     string    `json:"status"` // running, paused, done or cancelled
+	StartedAt  time.Time `json:"started_at"`
+
+	cancelled bool
+	paused    bool
+}
+
+var (
+	scenarioLock    sync.Mutex
+	scenarioRuns    []*ScenarioRun
+	scenarioNextRun int64
+	maxScenarioRuns = 50
+)
+
+func validateScenario(sc *Scenario) error {
+	sc.Name = strings.TrimSpace(sc.Name)
+	if sc.Name == "" || len(sc.Name) > 128 {
+		return errors.New("name required (1-128 chars)")
+	}
+	if len(sc.Stages) == 0 || len(sc.Stages) > 50 {
+		return errors.New("scenario needs 1-50 stages")
+	}
+	for i := range sc.Stages {
+		st := &sc.Stages[i]
+		targets := 0
+		if len(st.Stocks) > 0 {
+			targets++
+		}
+		if strings.TrimSpace(st.Sector) != "" {
+			targets++
+		}
+		if st.All {
+			targets++
+		}
+		if targets != 1 {
+			return fmt.Errorf("stage %d: set exactly one of stocks, sector or all", i+1)
+		}
+		for j := range st.Stocks {
+			st.Stocks[j] = strings.ToUpper(strings.TrimSpace(st.Stocks[j]))
+		}
+		st.Sector = strings.TrimSpace(st.Sector)
+		if st.Move <= -0.95 || st.Move > 4 || st.Move == 0 {
+			return fmt.Errorf("stage %d: move must be between -0.95 and 4 and not 0", i+1)
+		}
+		if st.Duration < 1 || st.Duration > 3600 {
+			return fmt.Errorf("stage %d: duration must be 1-3600 seconds", i+1)
+		}
+		if st.Stagger < 0 || st.Stagger > 3600 {
+			return fmt.Errorf("stage %d: stagger must be 0-3600 seconds", i+1)
+		}
+		if st.At != nil && (*st.At < 0 || *st.At > 86400) {
+			return fmt.Errorf("stage %d: at must be 0-86400 seconds", i+1)
+		}
+	}
+	return nil
+}
+
+// resolves targets against the current stock list and lays every stage out on one timeline
+func planScenario(sc Scenario) ([]scenarioLeg, float64, error) {
+	stocksLock.Lock()
+	current := make([]Stock, len(stocks))
+	copy(current, stocks)
+	stocksLock.Unlock()
+
+	var legs []scenarioLeg
+	end := 0.0
+	for i, st := range sc.Stages {
+		var ids []string
+		switch {
+		case st.All:
+			for _, s := range current {
+				ids = append(ids, s.ID)
+			}
+		case st.Sector != "":
+			for _, s := range current {
+				if strings.EqualFold(strings.TrimSpace(s.Sector), st.Sector) {
+					ids = append(ids, s.ID)
+				}
+			}
+		default:
+			for _, id := range st.Stocks {
+				found := false
+				for _, s := range current {
+					if s.ID == id {
+						found = true
+						break
+					}
+				}
+				if !found {
+					return nil, 0, fmt.Errorf("stage %d: stock %s not found", i+1, id)
+				}
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			return nil, 0, fmt.Errorf("stage %d: no stocks matched", i+1)
+		}
+
+		start := end
+		if st.At != nil {
+			start = *st.At
+		}
+		label := st.Label
+		if label == "" {
+			label = fmt.Sprintf("stage %d", i+1)
+		}
+		for k, id := range ids {
+			leg := scenarioLeg{
+				Stage:    i + 1,
+				Label:    label,
+				Stock:    id,
+				Move:     st.Move,
+				Start:    start + float64(k)*st.Stagger,
+				Duration: st.Duration,
+			}
+			legs = append(legs, leg)
+			if e := leg.Start + leg.Duration; e > end {
+				end = e
+			}
+		}
+	}
+	return legs, end, nil
+}
+
+// the path the engine would draw, minus the noise and the random walk underneath
+func previewScenario(sc Scenario, step float64) (map[string]interface{}, error) {
+	legs, total, err := planScenario(sc)
+	if err != nil {
+		return nil, err
+	}
+	if step <= 0 {
+		step = math.Max(1, math.Ceil(total/200))
+	}
+
+	prices := map[string]float64{}
+	stocksLock.Lock()
+	for _, s := range stocks {
+		prices[s.ID] = s.Price
+	}
+	stocksLock.Unlock()
+
+	byStock := map[string][]scenarioLeg{}
+	for _, l := range legs {
+		byStock[l.Stock] = append(byStock[l.Stock], l)
+	}
+	logAt := func(ls []scenarioLeg, t float64) float64 {
+		sum := 0.0
+		for _, l := range ls {
+			frac := (t - l.Start) / l.Duration
+			if frac <= 0 {
+				continue
+			}
+			if frac > 1 {
+				frac = 1
+			}
+			sum += math.Log(1+l.Move) * easeInOut(frac)
+		}
+		return sum
+	}
+
+	paths := map[string][]map[string]float64{}
+	final := map[string]map[string]float64{}
+	for id, ls := range byStock {
+		base := prices[id]
+		var pts []map[string]float64
+		for t := 0.0; ; t += step {
+			if t > total {
+				t = total
+			}
+			pts = append(pts, map[string]float64{"t": t, "price": roundToFour(base * math.Exp(logAt(ls, t)))})
+			if t >= total {
+				break
+			}
+		}
+		paths[id] = pts
+		to := base * math.Exp(logAt(ls, total))
+		low, high := base, base
+		for _, p := range pts {
+			low = math.Min(low, p["price"])
+			high = math.Max(high, p["price"])
+		}
+		final[id] = map[string]float64{
+			"from":       roundToFour(base),
+			"to":         roundToFour(to),
+			"low":        roundToFour(low),
+			"high":       roundToFour(high),
+			"change_pct": roundToFour((to - base) / base * 100),
+		}
+	}
+
+	return map[string]interface{}{
+		"name":             sc.Name,
+		"duration_seconds": total,
+		"step_seconds":     step,
+		"legs":             legs,
+		"paths":            paths,
+		"summary":          final,
+	}, nil
+}
+
+func startScenario(sc Scenario) (*ScenarioRun, error) {
+	legs, _, err := planScenario(sc)
+	if err != nil {
+		return nil, err
+	}
+
+	scenarioLock.Lock()
+	scenarioNextRun++
+	run := &ScenarioRun{ID: scenarioNextRun, ScenarioID: sc.ID, Name: sc.Name, Legs: len(legs), Status: "running", StartedAt: time.Now()}
+	scenarioRuns = append(scenarioRuns, run)
+	if len(scenarioRuns) > maxScenarioRuns {
+		scenarioRuns = scenarioRuns[len(scenarioRuns)-maxScenarioRuns:]
+	}
+	scenarioLock.Unlock()
+
+	for _, l := range legs {
+		submitEvent(eventSpec{
+			Kind:     "scenario",
+			Label:    sc.Name + ": " + l.Label,
+			Stocks:   []string{l.Stock},
+			Impact:   l.Move,
+			Duration: time.Duration(l.Duration * float64(time.Second)),
+			Delay:    time.Duration(l.Start * float64(time.Second)),
+			Run:      run.ID,
+		})
+	}
+	_, _ = db.Exec("INSERT INTO admin_actions (stock_id, action, magnitude, created_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)", "*", "scenario:"+sc.Name, 0)
+	return run, nil
+}
+
+// status comes from whatever the engine still has queued for the run
+func scenarioRunView(run *ScenarioRun) ScenarioRun {
+	out := *run
+	out.Remaining = len(runEventIDs(run.ID))
+	switch {
+	case run.cancelled:
+		out.Status = "cancelled"
+	case out.Remaining == 0:
+		out.Status = "done"
+	case run.paused:
+		out.Status = "paused"
+	default:
+		out.Status = "running"
+	}
+	return out
+}
+
+func findScenarioRun(id int64) *ScenarioRun {
+	for _, r := range scenarioRuns {
+		if r.ID == id {
+			return r
+		}
+	}
+	return nil
+}
+
+const scenarioColumns = "id, name, description, definition, created_at, updated_at"
+
+func scanScenario(row rowScanner) (Scenario, error) {
+	var sc Scenario
+	var desc, created, updated sql.NullString
+	var def string
+	if err := row.Scan(&sc.ID, &sc.Name, &desc, &def, &created, &updated); err != nil {
+		return sc, err
+	}
+	if err := json.Unmarshal([]byte(def), &sc.Stages); err != nil {
+		return sc, err
+	}
+	sc.Description = nullToString(desc)
+	sc.CreatedAt = nullToString(created)
+	sc.UpdatedAt = nullToString(updated)
+	return sc, nil
+}
+
+func getScenario(id int64) (Scenario, error) {
+	return scanScenario(db.QueryRow("SELECT "+scenarioColumns+" FROM scenarios WHERE id = ?", id))
+}
+
+// same name replaces the stored definition
+func saveScenario(sc Scenario) (Scenario, error) {
+	def, err := json.Marshal(sc.Stages)
+	if err != nil {
+		return sc, err
+	}
+	_, err = db.Exec(`INSERT INTO scenarios (name, description, definition) VALUES (?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET description = excluded.description, definition = excluded.definition, updated_at = CURRENT_TIMESTAMP`,
+		sc.Name, sc.Description, string(def))
+	if err != nil {
+		return sc, err
+	}
+	return scanScenario(db.QueryRow("SELECT "+scenarioColumns+" FROM scenarios WHERE name = ? COLLATE NOCASE", sc.Name))
+}
+
+// first run loads the example scenarios from data/scenarios.json
+func seedScenarios() {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM scenarios").Scan(&count); err != nil || count > 0 {
+		return
+	}
+	data, err := os.ReadFile("data/scenarios.json")
+	if err != nil {
+		return
+	}
+	var arr []Scenario
+	if err := json.Unmarshal(data, &arr); err != nil {
+		log.Printf("Failed to parse scenarios.json: %v", err)
+		return
+	}
+	for _, sc := range arr {
+		if err := validateScenario(&sc); err != nil {
+			log.Printf("Skipping scenario %q: %v", sc.Name, err)
+			continue
+		}
+		_, _ = saveScenario(sc)
+	}
+	log.Printf("Seeded %d scenarios", len(arr))
+}
+
+// GET lists scenarios, POST uploads one (replacing any with the same name)
+func scenariosHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := db.Query("SELECT " + scenarioColumns + " FROM scenarios ORDER BY name ASC")
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		out := []Scenario{}
+		for rows.Next() {
+			sc, err := scanScenario(rows)
+			if err != nil {
+				http.Error(w, "db scan error", http.StatusInternalServerError)
+				return
+			}
+			out = append(out, sc)
+		}
+		writeJSON(w, out)
+
+	case http.MethodPost:
+		var sc Scenario
+		if err := json.NewDecoder(r.Body).Decode(&sc); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := validateScenario(&sc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, _, err := planScenario(sc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		saved, err := saveScenario(sc)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, saved)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func deleteScenarioHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	res, err := db.Exec("DELETE FROM scenarios WHERE id = ?", req.ID)
+	if err != nil {
+		http.Error(w, "db delete error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "scenario not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"status": "deleted", "id": req.ID})
+}
+
+// either a stored scenario by id or an inline one, so admins can try things before saving them
+func scenarioFromRequest(w http.ResponseWriter, id int64, inline *Scenario) (Scenario, bool) {
+	if inline != nil {
+		sc := *inline
+		if sc.Name == "" {
+			sc.Name = "preview"
+		}
+		if err := validateScenario(&sc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return sc, false
+		}
+		return sc, true
+	}
+	sc, err := getScenario(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "scenario not found", http.StatusNotFound)
+		return sc, false
+	} else if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return sc, false
+	}
+	return sc, true
+}
+
+// POST {"id": 1} or {"scenario": {...}}, optional step_seconds
+func previewScenarioHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID       int64     `json:"id"`
+		Scenario *Scenario `json:"scenario,omitempty"`
+		Step     float64   `json:"step_seconds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	sc, ok := scenarioFromRequest(w, req.ID, req.Scenario)
+	if !ok {
+		return
+	}
+	out, err := previewScenario(sc, req.Step)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, out)
+}
+
+// POST {"id": 1} or {"scenario": {...}}
+func runScenarioHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID       int64     `json:"id"`
+		Scenario *Scenario `json:"scenario,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	sc, ok := scenarioFromRequest(w, req.ID, req.Scenario)
+	if !ok {
+		return
+	}
+	run, err := startScenario(sc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scenarioLock.Lock()
+	out := scenarioRunView(run)
+	scenarioLock.Unlock()
+	writeJSON(w, out)
+}
+
+func scenarioRunsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	scenarioLock.Lock()
+	out := make([]ScenarioRun, 0, len(scenarioRuns))
+	for _, run := range scenarioRuns {
+		out = append(out, scenarioRunView(run))
+	}
+	scenarioLock.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ID > out[j].ID })
+	writeJSON(w, out)
+}
+
+// POST /api/admin/scenarios/pause|resume|cancel {"run_id": 1, "revert": false}
+func scenarioRunControlHandler(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if !requireAdmin(w, r) {
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			RunID  int64 `json:"run_id"`
+			Revert bool  `json:"revert"` // cancel only, walks back whatever already moved
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+
+		scenarioLock.Lock()
+		defer scenarioLock.Unlock()
+		run := findScenarioRun(req.RunID)
+		if run == nil {
+			http.Error(w, "run not found", http.StatusNotFound)
+			return
+		}
+		if run.cancelled || len(runEventIDs(run.ID)) == 0 {
+			http.Error(w, "run already finished", http.StatusBadRequest)
+			return
+		}
+
+		switch action {
+		case "pause":
+			setRunPaused(run.ID, true)
+			run.paused = true
+		case "resume":
+			setRunPaused(run.ID, false)
+			run.paused = false
+		case "cancel":
+			for _, id := range runEventIDs(run.ID) {
+				cancelMarketEvent(id, req.Revert)
+			}
+			run.cancelled = true
+		}
+		writeJSON(w, scenarioRunView(run))
+	}
+}
@@ -0,0 +1,677 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// a queued news item as the admin page sees it
+type ScheduledNews struct {
+	ID                 int64   `json:"id"`
+	Title              string  `json:"title"`
+	Content            string  `json:"content"`
+	AffectedStock      string  `json:"affected_stock,omitempty"`
+	AffectedSector     string  `json:"affected_sector,omitempty"`
+	Source             string  `json:"source"`
+	Impact             float64 `json:"impact"`
+	ImpactJitter       float64 `json:"impact_jitter"`
+	Urgency            string  `json:"urgency,omitempty"`
+	PublishAt          string  `json:"publish_at"`
+	FireAt             string  `json:"fire_at"`
+	JitterSeconds      int     `json:"jitter_seconds"`
+	RepeatEverySeconds int     `json:"repeat_every_seconds"`
+	RepeatRemaining    int     `json:"repeat_remaining"`
+	Occurrence         int     `json:"occurrence"`
+	Origin             string  `json:"origin"`
+	Competition        string  `json:"competition"`
+	Status             string  `json:"status"`
+	NewsID             *int64  `json:"news_id,omitempty"`
+	Error              string  `json:"error,omitempty"`
+	CreatedAt          string  `json:"created_at"`
+
+	publishAt time.Time
+}
+
+// body for queueing one item, also the shape of each entry in data/newsscript.json
+type ScheduleRequest struct {
+	NewsRequest
+	ImpactJitter       float64  `json:"impact_jitter"`
+	PublishAt          string   `json:"publish_at,omitempty"`
+	DelaySeconds       int      `json:"delay_seconds,omitempty"`
+	OffsetMinutes      *float64 `json:"offset_minutes,omitempty"` // minutes after comp start, handy for scripts
+	JitterSeconds      int      `json:"jitter_seconds"`
+	RepeatEverySeconds int      `json:"repeat_every_seconds"`
+	RepeatCount        int      `json:"repeat_count"`          // extra occurrences, -1 keeps going until the comp ends
+	Competition        string   `json:"competition,omitempty"` // whose start and end offset_minutes and -1 go by, main by default
+}
+
+func (s ScheduleRequest) competition() (Competition, error) {
+	slug := strings.ToLower(strings.TrimSpace(s.Competition))
+	if slug == "" {
+		slug = mainCompetition
+	}
+	return getCompetition(slug)
+}
+
+// affected_stock "*" means pick any stock when the item fires, mostly for templates like "earnings for {stock}"
+const anyStock = "*"
+
+func (s ScheduleRequest) publishTime(now time.Time) (time.Time, error) {
+	if strings.TrimSpace(s.PublishAt) != "" {
+		return parseCompTime(s.PublishAt)
+	}
+	if s.OffsetMinutes != nil {
+		comp, err := s.competition()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return comp.Start.Add(time.Duration(*s.OffsetMinutes * float64(time.Minute))), nil
+	}
+	if s.DelaySeconds > 0 {
+		return now.Add(time.Duration(s.DelaySeconds) * time.Second), nil
+	}
+	return time.Time{}, errors.New("publish_at, delay_seconds or offset_minutes required")
+}
+
+func applyJitter(t time.Time, jitterSeconds int, now time.Time) time.Time {
+	if jitterSeconds <= 0 {
+		return t
+	}
+	offset := rand.Intn(2*jitterSeconds+1) - jitterSeconds
+	fire := t.Add(time.Duration(offset) * time.Second)
+	// jitter shouldnt drag a future item into the past
+	if fire.Before(now) && !t.Before(now) {
+		fire = now
+	}
+	return fire
+}
+
+// fills in {stock}, {name}, {sector} and {n}, and resolves "*" to an actual stock
+func expandNewsTemplate(req NewsRequest, occurrence int) NewsRequest {
+	out := req
+	var name, sector string
+
+	stocksLock.Lock()
+	if out.AffectedStock == anyStock && len(stocks) > 0 {
+		out.AffectedStock = stocks[rand.Intn(len(stocks))].ID
+	}
+	for i := range stocks {
+		if stocks[i].ID == out.AffectedStock {
+			name = stocks[i].Name
+			sector = stocks[i].Sector
+			break
+		}
+	}
+	stocksLock.Unlock()
+	if sector == "" {
+		sector = out.AffectedSector
+	}
+
+	rep := strings.NewReplacer(
+		"{stock}", out.AffectedStock,
+		"{name}", name,
+		"{sector}", sector,
+		"{n}", strconv.Itoa(occurrence),
+	)
+	out.Title = rep.Replace(out.Title)
+	out.Content = rep.Replace(out.Content)
+	return out
+}
+
+// validates a schedule entry the same way a live publish would be validated
+func validateSchedule(req *ScheduleRequest) error {
+	check := expandNewsTemplate(req.NewsRequest, 1)
+	if err := validateNews(&check); err != nil {
+		return err
+	}
+	if check.Kind == "rumor" {
+		return errors.New("rumors cant be scheduled, publish them directly")
+	}
+	if req.ImpactJitter < 0 || req.JitterSeconds < 0 || req.RepeatEverySeconds < 0 {
+		return errors.New("jitter and repeat values must be >= 0")
+	}
+	if req.RepeatCount < -1 {
+		return errors.New("repeat_count must be -1 or more")
+	}
+	if req.RepeatCount != 0 && req.RepeatEverySeconds == 0 {
+		return errors.New("repeat_every_seconds required when repeating")
+	}
+	if req.RepeatEverySeconds > 0 && req.RepeatEverySeconds < 10 {
+		return errors.New("repeat_every_seconds must be >= 10")
+	}
+	comp, err := req.competition()
+	if err != nil {
+		return err
+	}
+	req.Competition = comp.Slug
+	return nil
+}
+
+// db or tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func insertScheduledNews(ex execer, req ScheduleRequest, publishAt time.Time, occurrence int, origin string, status string) (int64, error) {
+	now := time.Now().UTC()
+	fireAt := applyJitter(publishAt, req.JitterSeconds, now)
+	res, err := ex.Exec(`INSERT INTO news_schedule
+		(title, content, affected_stock, affected_sector, source, impact, impact_jitter, urgency, publish_at, fire_at, jitter_seconds, repeat_every_seconds, repeat_remaining, occurrence, origin, competition, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		strings.TrimSpace(req.Title), strings.TrimSpace(req.Content), strings.TrimSpace(req.AffectedStock), strings.TrimSpace(req.AffectedSector),
+		strings.TrimSpace(req.Source), req.Impact, req.ImpactJitter, strings.ToLower(strings.TrimSpace(req.Urgency)), toDBTime(publishAt), toDBTime(fireAt),
+		req.JitterSeconds, req.RepeatEverySeconds, req.RepeatCount, occurrence, origin, req.Competition, status,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+const scheduledNewsColumns = "id, title, content, affected_stock, affected_sector, source, impact, impact_jitter, urgency, publish_at, fire_at, jitter_seconds, repeat_every_seconds, repeat_remaining, occurrence, origin, COALESCE(competition, 'main'), status, news_id, error, created_at"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanScheduledNews(row rowScanner) (ScheduledNews, error) {
+	var n ScheduledNews
+	var affectedStock, affectedSector, urgency, publishAt, fireAt, errText, created sql.NullString
+	var newsID sql.NullInt64
+	if err := row.Scan(&n.ID, &n.Title, &n.Content, &affectedStock, &affectedSector, &n.Source, &n.Impact, &n.ImpactJitter, &urgency,
+		&publishAt, &fireAt, &n.JitterSeconds, &n.RepeatEverySeconds, &n.RepeatRemaining, &n.Occurrence,
+		&n.Origin, &n.Competition, &n.Status, &newsID, &errText, &created); err != nil {
+		return n, err
+	}
+	n.AffectedStock = nullToString(affectedStock)
+	n.AffectedSector = nullToString(affectedSector)
+	n.Urgency = nullToString(urgency)
+	n.Error = nullToString(errText)
+	n.CreatedAt = nullToString(created)
+	if publishAt.Valid {
+		n.publishAt = parseDBTimeToLocal(publishAt.String)
+		n.PublishAt = n.publishAt.Format(time.RFC3339)
+	}
+	if fireAt.Valid {
+		n.FireAt = parseDBTimeToLocal(fireAt.String).Format(time.RFC3339)
+	}
+	if newsID.Valid {
+		id := newsID.Int64
+		n.NewsID = &id
+	}
+	return n, nil
+}
+
+func getScheduledNews(id int64) (ScheduledNews, error) {
+	return scanScheduledNews(db.QueryRow("SELECT "+scheduledNewsColumns+" FROM news_schedule WHERE id = ?", id))
+}
+
+// runs for the whole server lifetime and publishes whatever is due
+func newsScheduler() {
+	// anything still claimed is from a run that died mid publish, put it back in the queue
+	if res, err := db.Exec("UPDATE news_schedule SET status = 'pending' WHERE status = 'publishing'"); err != nil {
+		log.Println("news scheduler reset error:", err)
+	} else if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("news scheduler: %d interrupted items back in the queue", n)
+	}
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		runDueNews(time.Now())
+	}
+}
+
+func runDueNews(now time.Time) {
+	rows, err := db.Query("SELECT id FROM news_schedule WHERE status = 'pending' AND fire_at <= ? ORDER BY fire_at ASC, id ASC", toDBTime(now))
+	if err != nil {
+		log.Println("news scheduler query error:", err)
+		return
+	}
+	var due []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err == nil {
+			due = append(due, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range due {
+		fireScheduledNews(id, now)
+	}
+}
+
+func fireScheduledNews(id int64, now time.Time) {
+	// claim it first so an edit/cancel racing with us cant double fire it
+	res, err := db.Exec("UPDATE news_schedule SET status = 'publishing' WHERE id = ? AND status = 'pending'", id)
+	if err != nil {
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return
+	}
+
+	item, err := getScheduledNews(id)
+	if err != nil {
+		// release the claim so the next tick tries again
+		log.Println("news scheduler load error:", err)
+		_, _ = db.Exec("UPDATE news_schedule SET status = 'pending' WHERE id = ? AND status = 'publishing'", id)
+		return
+	}
+
+	req := expandNewsTemplate(NewsRequest{
+		Title:          item.Title,
+		Content:        item.Content,
+		AffectedStock:  item.AffectedStock,
+		AffectedSector: item.AffectedSector,
+		Impact:         item.Impact,
+		Source:         item.Source,
+		Urgency:        item.Urgency,
+	}, item.Occurrence)
+	if item.ImpactJitter > 0 {
+		req.Impact += (rand.Float64()*2 - 1) * item.ImpactJitter
+	}
+	req.scheduleID = item.ID
+
+	// a run that died between publishing and marking it published already put it out, just finish the bookkeeping
+	var published int64
+	if err := db.QueryRow("SELECT id FROM news WHERE schedule_id = ?", id).Scan(&published); err == nil {
+		_, _ = db.Exec("UPDATE news_schedule SET status = 'published', news_id = ? WHERE id = ?", published, id)
+	} else if err := validateNews(&req); err != nil {
+		_, _ = db.Exec("UPDATE news_schedule SET status = 'failed', error = ? WHERE id = ?", err.Error(), id)
+	} else if newsID, err := publishNews(req); err != nil {
+		_, _ = db.Exec("UPDATE news_schedule SET status = 'failed', error = ? WHERE id = ?", err.Error(), id)
+	} else {
+		_, _ = db.Exec("UPDATE news_schedule SET status = 'published', news_id = ? WHERE id = ?", newsID, id)
+	}
+
+	if item.RepeatEverySeconds <= 0 || item.RepeatRemaining == 0 {
+		return
+	}
+	every := time.Duration(item.RepeatEverySeconds) * time.Second
+	next := item.publishAt.Add(every)
+	remaining := item.RepeatRemaining
+	// after downtime the slots that went by are dropped rather than fired back to back, it picks up at the next one ahead
+	if !next.After(now) {
+		missed := int(now.Sub(next)/every) + 1
+		next = next.Add(time.Duration(missed) * every)
+		if remaining > 0 {
+			if remaining <= missed {
+				return
+			}
+			remaining -= missed
+		}
+	}
+	if remaining < 0 {
+		comp, err := getCompetition(item.Competition)
+		if err != nil || next.After(comp.End) {
+			return
+		}
+	}
+	if remaining > 0 {
+		remaining--
+	}
+	nextReq := ScheduleRequest{
+		NewsRequest: NewsRequest{
+			Title:          item.Title,
+			Content:        item.Content,
+			AffectedStock:  item.AffectedStock,
+			AffectedSector: item.AffectedSector,
+			Impact:         item.Impact,
+			Source:         item.Source,
+			Urgency:        item.Urgency,
+		},
+		ImpactJitter:       item.ImpactJitter,
+		JitterSeconds:      item.JitterSeconds,
+		RepeatEverySeconds: item.RepeatEverySeconds,
+		RepeatCount:        remaining,
+		Competition:        item.Competition,
+	}
+	if _, err := insertScheduledNews(db, nextReq, next, item.Occurrence+1, item.Origin, "pending"); err != nil {
+		log.Println("news scheduler repeat insert error:", err)
+	}
+}
+
+// queues every entry of a news script, entries whose time already passed are kept as "missed" instead of firing all at once
+func importNewsScript(entries []ScheduleRequest) (int, int, error) {
+	now := time.Now().UTC()
+	times := make([]time.Time, len(entries))
+	for i := range entries {
+		if err := validateSchedule(&entries[i]); err != nil {
+			return 0, 0, errors.New("entry " + strconv.Itoa(i) + ": " + err.Error())
+		}
+		at, err := entries[i].publishTime(now)
+		if err != nil {
+			return 0, 0, errors.New("entry " + strconv.Itoa(i) + ": " + err.Error())
+		}
+		times[i] = at
+	}
+
+	// all or nothing, a failure halfway leaves no partial script behind
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	queued, missed := 0, 0
+	for i, e := range entries {
+		at := times[i]
+		status := "pending"
+		if at.Before(now) {
+			status = "missed"
+			missed++
+		} else {
+			queued++
+		}
+		if _, err := insertScheduledNews(tx, e, at, 1, "script", status); err != nil {
+			tx.Rollback()
+			return 0, 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	return queued, missed, nil
+}
+
+// pre-loads data/newsscript.json once, later restarts keep whatever is already in the db
+func loadNewsScript() {
+	data, err := os.ReadFile("data/newsscript.json")
+	if err != nil {
+		return
+	}
+	var existing int
+	if err := db.QueryRow("SELECT COUNT(*) FROM news_schedule WHERE origin = 'script'").Scan(&existing); err != nil || existing > 0 {
+		return
+	}
+	var entries []ScheduleRequest
+	if err := json.Unmarshal(data, &entries); err != nil {
+		log.Printf("Failed to parse newsscript.json: %v", err)
+		return
+	}
+	queued, missed, err := importNewsScript(entries)
+	if err != nil {
+		log.Printf("Failed to load newsscript.json: %v", err)
+		return
+	}
+	log.Printf("Loaded news script: %d queued, %d missed", queued, missed)
+}
+
+// GET lists the queue, POST queues one item
+func newsScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		query := "SELECT " + scheduledNewsColumns + " FROM news_schedule"
+		args := []interface{}{}
+		if status := strings.TrimSpace(r.URL.Query().Get("status")); status != "" {
+			query += " WHERE status = ?"
+			args = append(args, status)
+		}
+		query += " ORDER BY fire_at ASC, id ASC"
+
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		out := []ScheduledNews{}
+		for rows.Next() {
+			n, err := scanScheduledNews(rows)
+			if err != nil {
+				http.Error(w, "db scan error", http.StatusInternalServerError)
+				return
+			}
+			out = append(out, n)
+		}
+		writeJSON(w, out)
+
+	case http.MethodPost:
+		var req ScheduleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := validateSchedule(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		at, err := req.publishTime(time.Now().UTC())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id, err := insertScheduledNews(db, req, at, 1, "admin", "pending")
+		if err != nil {
+			http.Error(w, "db insert error", http.StatusInternalServerError)
+			return
+		}
+		item, err := getScheduledNews(id)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, item)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// edits a pending item, only the fields sent are changed
+func updateScheduledNewsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID                 int64    `json:"id"`
+		Title              *string  `json:"title"`
+		Content            *string  `json:"content"`
+		AffectedStock      *string  `json:"affected_stock"`
+		AffectedSector     *string  `json:"affected_sector"`
+		Source             *string  `json:"source"`
+		Urgency            *string  `json:"urgency"`
+		Impact             *float64 `json:"impact"`
+		ImpactJitter       *float64 `json:"impact_jitter"`
+		PublishAt          *string  `json:"publish_at"`
+		JitterSeconds      *int     `json:"jitter_seconds"`
+		RepeatEverySeconds *int     `json:"repeat_every_seconds"`
+		RepeatCount        *int     `json:"repeat_count"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	item, err := getScheduledNews(req.ID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "scheduled news not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if item.Status != "pending" {
+		http.Error(w, "only pending items can be edited", http.StatusBadRequest)
+		return
+	}
+
+	upd := ScheduleRequest{
+		NewsRequest: NewsRequest{
+			Title:          item.Title,
+			Content:        item.Content,
+			AffectedStock:  item.AffectedStock,
+			AffectedSector: item.AffectedSector,
+			Impact:         item.Impact,
+			Source:         item.Source,
+			Urgency:        item.Urgency,
+		},
+		ImpactJitter:       item.ImpactJitter,
+		JitterSeconds:      item.JitterSeconds,
+		RepeatEverySeconds: item.RepeatEverySeconds,
+		RepeatCount:        item.RepeatRemaining,
+		Competition:        item.Competition,
+	}
+	if req.Title != nil {
+		upd.Title = *req.Title
+	}
+	if req.Content != nil {
+		upd.Content = *req.Content
+	}
+	if req.AffectedStock != nil {
+		upd.AffectedStock = *req.AffectedStock
+	}
+	if req.AffectedSector != nil {
+		upd.AffectedSector = *req.AffectedSector
+	}
+	if req.Source != nil {
+		upd.Source = *req.Source
+	}
+	if req.Urgency != nil {
+		upd.Urgency = *req.Urgency
+	}
+	if req.Impact != nil {
+		upd.Impact = *req.Impact
+	}
+	if req.ImpactJitter != nil {
+		upd.ImpactJitter = *req.ImpactJitter
+	}
+	if req.JitterSeconds != nil {
+		upd.JitterSeconds = *req.JitterSeconds
+	}
+	if req.RepeatEverySeconds != nil {
+		upd.RepeatEverySeconds = *req.RepeatEverySeconds
+	}
+	if req.RepeatCount != nil {
+		upd.RepeatCount = *req.RepeatCount
+	}
+	if err := validateSchedule(&upd); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	publishAt := item.publishAt
+	if req.PublishAt != nil {
+		t, err := parseCompTime(*req.PublishAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		publishAt = t
+	}
+	fireAt := applyJitter(publishAt, upd.JitterSeconds, now)
+
+	res, err := db.Exec(`UPDATE news_schedule SET title = ?, content = ?, affected_stock = ?, affected_sector = ?, source = ?, urgency = ?,
+		impact = ?, impact_jitter = ?, publish_at = ?, fire_at = ?, jitter_seconds = ?, repeat_every_seconds = ?, repeat_remaining = ?
+		WHERE id = ? AND status = 'pending'`,
+		strings.TrimSpace(upd.Title), strings.TrimSpace(upd.Content), strings.TrimSpace(upd.AffectedStock), strings.TrimSpace(upd.AffectedSector),
+		strings.TrimSpace(upd.Source), strings.ToLower(strings.TrimSpace(upd.Urgency)), upd.Impact, upd.ImpactJitter, toDBTime(publishAt), toDBTime(fireAt),
+		upd.JitterSeconds, upd.RepeatEverySeconds, upd.RepeatCount, req.ID,
+	)
+	if err != nil {
+		http.Error(w, "db update error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "item already went out", http.StatusConflict)
+		return
+	}
+
+	item, err = getScheduledNews(req.ID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, item)
+}
+
+func cancelScheduledNewsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.Exec("UPDATE news_schedule SET status = 'cancelled' WHERE id = ? AND status = 'pending'", req.ID)
+	if err != nil {
+		http.Error(w, "db update error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "no pending item with that id", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{"status": "cancelled", "id": req.ID})
+}
+
+// takes the same json array as data/newsscript.json
+func importNewsScriptHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var entries []ScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&entries); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	queued, missed, err := importNewsScript(entries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{"status": "ok", "queued": queued, "missed": missed})
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// a news outlet and how much the market trusts it
+type NewsSource struct {
+	ID          int64   `json:"id"`
+	Name        string  `json:"name"`
+	Credibility float64 `json:"credibility"` // 0..1, scales the whole move
+	Bias        float64 `json:"bias"`        // -1..1, bearish to bullish, exaggerates moves in the direction the outlet leans
+	Reach       float64 `json:"reach"`       // 0..1, how much of the market actually reads it
+	CreatedAt   string  `json:"created_at,omitempty"`
+}
+
+// 1.0 for a fully credible, unbiased, widely read source
+func (s NewsSource) impactMultiplier(impact float64) float64 {
+	m := s.Credibility * (0.5 + 0.5*s.Reach)
+	if impact > 0 {
+		m *= 1 + 0.5*s.Bias
+	} else if impact < 0 {
+		m *= 1 - 0.5*s.Bias
+	}
+	return m
+}
+
+func sourceWeightedImpact(source string, impact float64) float64 {
+	src, err := getNewsSource(source)
+	if err != nil {
+		return impact
+	}
+	return impact * src.impactMultiplier(impact)
+}
+
+func validateNewsSource(s *NewsSource) error {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" || len(s.Name) > 128 {
+		return errors.New("name required (1-128 chars)")
+	}
+	if s.Credibility < 0 || s.Credibility > 1 {
+		return errors.New("credibility must be between 0 and 1")
+	}
+	if s.Bias < -1 || s.Bias > 1 {
+		return errors.New("bias must be between -1 and 1")
+	}
+	if s.Reach < 0 || s.Reach > 1 {
+		return errors.New("reach must be between 0 and 1")
+	}
+	return nil
+}
+
+// first run copies data/newswebsites.json into the db, after that the db is the source of truth
+func seedNewsSources() {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM news_sources").Scan(&count); err != nil || count > 0 {
+		return
+	}
+	data, err := os.ReadFile("data/newswebsites.json")
+	if err != nil {
+		return
+	}
+	var arr []struct {
+		Name        string   `json:"name"`
+		Credibility *float64 `json:"credibility"`
+		Bias        float64  `json:"bias"`
+		Reach       *float64 `json:"reach"`
+	}
+	if err := json.Unmarshal(data, &arr); err != nil {
+		log.Printf("Failed to parse newswebsites.json: %v", err)
+		return
+	}
+	for _, it := range arr {
+		src := NewsSource{Name: it.Name, Credibility: 1, Bias: it.Bias, Reach: 1}
+		if it.Credibility != nil {
+			src.Credibility = *it.Credibility
+		}
+		if it.Reach != nil {
+			src.Reach = *it.Reach
+		}
+		if err := validateNewsSource(&src); err != nil {
+			continue
+		}
+		_, _ = db.Exec("INSERT OR IGNORE INTO news_sources (name, credibility, bias, reach) VALUES (?, ?, ?, ?)", src.Name, src.Credibility, src.Bias, src.Reach)
+	}
+	log.Printf("Seeded %d news sources", len(arr))
+}
+
+func listNewsSources() ([]NewsSource, error) {
+	rows, err := db.Query("SELECT id, name, credibility, bias, reach, created_at FROM news_sources ORDER BY name ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []NewsSource{}
+	for rows.Next() {
+		var s NewsSource
+		var created sql.NullString
+		if err := rows.Scan(&s.ID, &s.Name, &s.Credibility, &s.Bias, &s.Reach, &created); err != nil {
+			return nil, err
+		}
+		s.CreatedAt = nullToString(created)
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+func getNewsSource(name string) (NewsSource, error) {
+	var s NewsSource
+	var created sql.NullString
+	err := db.QueryRow("SELECT id, name, credibility, bias, reach, created_at FROM news_sources WHERE name = ? COLLATE NOCASE", strings.TrimSpace(name)).
+		Scan(&s.ID, &s.Name, &s.Credibility, &s.Bias, &s.Reach, &created)
+	s.CreatedAt = nullToString(created)
+	return s, err
+}
+
+// lowercase name -> allowed, kept in this shape because the admin page reads it
+func loadNewsSources() map[string]bool {
+	allowed := map[string]bool{}
+	list, err := listNewsSources()
+	if err != nil {
+		return allowed
+	}
+	for _, s := range list {
+		allowed[strings.ToLower(s.Name)] = true
+	}
+	return allowed
+}
+
+func attachSourceInfo(items []NewsOut) {
+	if len(items) == 0 {
+		return
+	}
+	list, err := listNewsSources()
+	if err != nil {
+		return
+	}
+	byName := make(map[string]NewsSource, len(list))
+	for _, s := range list {
+		byName[strings.ToLower(s.Name)] = s
+	}
+	for i := range items {
+		if s, ok := byName[strings.ToLower(items[i].Source)]; ok {
+			src := s
+			src.CreatedAt = ""
+			items[i].SourceInfo = &src
+		}
+	}
+}
+
+// GET lists sources, POST adds one
+func adminNewsSourcesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		list, err := listNewsSources()
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, list)
+
+	case http.MethodPost:
+		var req struct {
+			Name        string   `json:"name"`
+			Credibility *float64 `json:"credibility"`
+			Bias        float64  `json:"bias"`
+			Reach       *float64 `json:"reach"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		src := NewsSource{Name: req.Name, Credibility: 1, Bias: req.Bias, Reach: 1}
+		if req.Credibility != nil {
+			src.Credibility = *req.Credibility
+		}
+		if req.Reach != nil {
+			src.Reach = *req.Reach
+		}
+		if err := validateNewsSource(&src); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := getNewsSource(src.Name); err == nil {
+			http.Error(w, "source already exists", http.StatusBadRequest)
+			return
+		}
+		if _, err := db.Exec("INSERT INTO news_sources (name, credibility, bias, reach) VALUES (?, ?, ?, ?)", src.Name, src.Credibility, src.Bias, src.Reach); err != nil {
+			http.Error(w, "db insert error", http.StatusInternalServerError)
+			return
+		}
+		created, err := getNewsSource(src.Name)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, created)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func updateNewsSourceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID          int64    `json:"id"`
+		Name        *string  `json:"name"`
+		Credibility *float64 `json:"credibility"`
+		Bias        *float64 `json:"bias"`
+		Reach       *float64 `json:"reach"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	var src NewsSource
+	err := db.QueryRow("SELECT id, name, credibility, bias, reach FROM news_sources WHERE id = ?", req.ID).Scan(&src.ID, &src.Name, &src.Credibility, &src.Bias, &src.Reach)
+	if err == sql.ErrNoRows {
+		http.Error(w, "source not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if req.Name != nil {
+		src.Name = *req.Name
+	}
+	if req.Credibility != nil {
+		src.Credibility = *req.Credibility
+	}
+	if req.Bias != nil {
+		src.Bias = *req.Bias
+	}
+	if req.Reach != nil {
+		src.Reach = *req.Reach
+	}
+	if err := validateNewsSource(&src); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.Exec("UPDATE news_sources SET name = ?, credibility = ?, bias = ?, reach = ? WHERE id = ?", src.Name, src.Credibility, src.Bias, src.Reach, src.ID); err != nil {
+		http.Error(w, "db update error (name taken?)", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, src)
+}
+
+// past articles keep the name, they just stop carrying source_info
+func deleteNewsSourceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+	res, err := db.Exec("DELETE FROM news_sources WHERE id = ?", req.ID)
+	if err != nil {
+		http.Error(w, "db delete error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		http.Error(w, "source not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"status": "deleted", "id": req.ID})
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type StatementPosition struct {
+	StockID      string  `json:"stock_id"`
+	Shares       Qty     `json:"shares"`
+	Price        float64 `json:"price"`
+	Value        Money   `json:"value"`
+	Cost         Money   `json:"cost"`
+	UnrealizedPL Money   `json:"unrealized_pl"`
+}
+
+// cash includes money held for ipo subscriptions, holdings are stocks and etfs only
+type StatementBalance struct {
+	Time          string              `json:"time"`
+	Cash          Money               `json:"cash"`
+	HoldingsValue Money               `json:"holdings_value"`
+	Total         Money               `json:"total"`
+	UnrealizedPL  Money               `json:"unrealized_pl"`
+	Positions     []StatementPosition `json:"positions"`
+}
+
+type StatementLine struct {
+	ID         int64   `json:"id"`
+	Time       string  `json:"time"`
+	StockID    string  `json:"stock_id"`
+	Action     string  `json:"action"`
+	Shares     Qty     `json:"shares"`
+	Price      float64 `json:"price"`
+	Fee        Money   `json:"fee"`
+	CashEffect Money   `json:"cash_effect"`
+	RealizedPL Money   `json:"realized_pl"`
+}
+
+type StatementSummary struct {
+	Bought     Money `json:"bought"` // stocks and etfs, before fees
+	Sold       Money `json:"sold"`
+	Fees       Money `json:"fees"`
+	Dividends  Money `json:"dividends"`
+	Interest   Money `json:"interest"` // cash interest and bond coupons
+	RealizedPL Money `json:"realized_pl"`
+	NetCash    Money `json:"net_cash"`
+}
+
+type Statement struct {
+	UserID       int64            `json:"user_id"`
+	Username     string           `json:"username"`
+	From         string           `json:"from"`
+	To           string           `json:"to"`
+	GeneratedAt  string           `json:"generated_at"`
+	Opening      StatementBalance `json:"opening"`
+	Closing      StatementBalance `json:"closing"`
+	Summary      StatementSummary `json:"summary"`
+	Transactions []StatementLine  `json:"transactions"`
+}
+
+// what a transactions row did to cash. amount is the trade value for buys/sells with the fee on top,
+// the rest store their cash effect in amount already (positive in, except purchases)
+func cashEffect(action string, amount, fee Money) Money {
+	switch action {
+	case "buy", "ipo", "option_buy", "bond_buy":
+		return -amount - fee
+	case "sell", "delist":
+		return amount - fee
+	}
+	return amount
+}
+
+// price of a stock at t: live if t is now, then the raw ticks, then the minute bars (which go back further)
+func statementPrice(stockID string, t time.Time) (float64, bool) {
+	if time.Since(t) < time.Minute {
+		if p, err := getStockPrice(stockID); err == nil {
+			return p, true
+		}
+	}
+	if p, ok := priceAt(stockID, t); ok {
+		return p, true
+	}
+	tickLock.Lock()
+	defer tickLock.Unlock()
+	buf := tickBuffer[stockID]
+	if len(buf) == 0 {
+		return 0, false
+	}
+	i := sort.Search(len(buf), func(i int) bool { return buf[i].Time.After(t) })
+	if i == 0 {
+		return buf[0].Open, true
+	}
+	return buf[i-1].Close, true
+}
+
+// holdings at t rebuilt from tax lots: what was open then, minus what had been sold by then
+func positionsAt(userID int64, t time.Time) ([]StatementPosition, error) {
+	rows, err := db.Query(`SELECT l.stock_id, l.original_shares - COALESCE((SELECT SUM(s.shares) FROM lot_sales s WHERE s.lot_id = l.id AND s.sold_at <= ?), 0), l.cost_price
+		FROM tax_lots l WHERE l.user_id = ? AND l.opened_at <= ?`, toDBTime(t), userID, toDBTime(t))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byStock := map[string]*StatementPosition{}
+	for rows.Next() {
+		var stockID string
+		var shares Qty
+		var cost float64
+		if err := rows.Scan(&stockID, &shares, &cost); err != nil {
+			return nil, err
+		}
+		if shares <= 0 {
+			continue
+		}
+		p, ok := byStock[stockID]
+		if !ok {
+			p = &StatementPosition{StockID: stockID}
+			byStock[stockID] = p
+		}
+		p.Shares += shares
+		p.Cost += costOf(shares, cost)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	out := make([]StatementPosition, 0, len(byStock))
+	for _, p := range byStock {
+		price, ok := statementPrice(p.StockID, t)
+		if !ok {
+			// delisted and gone from memory, cost is the best we have
+			price = p.Cost.Float() / p.Shares.Float()
+		}
+		p.Price = roundToFour(price)
+		p.Value = costOf(p.Shares, price)
+		p.UnrealizedPL = p.Value - p.Cost
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].StockID < out[j].StockID })
+	return out, nil
+}
+
+func balanceAt(userID int64, t time.Time, cash Money) (StatementBalance, error) {
+	positions, err := positionsAt(userID, t)
+	if err != nil {
+		return StatementBalance{}, err
+	}
+	b := StatementBalance{Time: t.Local().Format(time.RFC3339), Cash: cash, Positions: positions}
+	for _, p := range positions {
+		b.HoldingsValue += p.Value
+		b.UnrealizedPL += p.UnrealizedPL
+	}
+	b.Total = b.Cash + b.HoldingsValue
+	return b, nil
+}
+
+func buildStatement(userID int64, from, to time.Time) (Statement, error) {
+	st := Statement{
+		UserID:       userID,
+		From:         from.Local().Format(time.RFC3339),
+		To:           to.Local().Format(time.RFC3339),
+		GeneratedAt:  time.Now().Local().Format(time.RFC3339),
+		Transactions: []StatementLine{},
+	}
+	var cash Money
+	if err := db.QueryRow("SELECT "+accountNameSQL+", u.cash FROM users u WHERE u.id = ?", userID).Scan(&st.Username, &cash); err != nil {
+		return st, err
+	}
+	cash += ipoEscrow(db, userID)
+
+	// walk everything from the start of the range to now, cash at the edges is worked back from today's cash
+	rows, err := db.Query("SELECT id, timestamp, stock_id, action, shares, price, amount, fee, realized_pl FROM transactions WHERE user_id = ? AND timestamp > ? ORDER BY timestamp ASC, id ASC", userID, toDBTime(from))
+	if err != nil {
+		return st, err
+	}
+	defer rows.Close()
+	var afterFrom, afterTo Money
+	for rows.Next() {
+		var l StatementLine
+		var ts string
+		var amount Money
+		if err := rows.Scan(&l.ID, &ts, &l.StockID, &l.Action, &l.Shares, &l.Price, &amount, &l.Fee, &l.RealizedPL); err != nil {
+			return st, err
+		}
+		at := parseDBTimeToLocal(ts)
+		effect := cashEffect(l.Action, amount, l.Fee)
+		afterFrom += effect
+		if at.After(to) {
+			afterTo += effect
+			continue
+		}
+		l.Time = at.Format(time.RFC3339)
+		l.Price = roundToFour(l.Price)
+		l.CashEffect = effect
+		st.Transactions = append(st.Transactions, l)
+
+		s := &st.Summary
+		switch l.Action {
+		case "buy", "ipo":
+			s.Bought += amount
+		case "sell", "delist", "buyback":
+			s.Sold += amount
+		case "dividend":
+			s.Dividends += effect
+		case "interest", "coupon":
+			s.Interest += effect
+		}
+		s.Fees += l.Fee
+		s.RealizedPL += l.RealizedPL
+		s.NetCash += effect
+	}
+	if err := rows.Err(); err != nil {
+		return st, err
+	}
+
+	if st.Opening, err = balanceAt(userID, from, cash-afterFrom); err != nil {
+		return st, err
+	}
+	if st.Closing, err = balanceAt(userID, to, cash-afterTo); err != nil {
+		return st, err
+	}
+	return st, nil
+}
+
+func (st Statement) csvBytes() []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	f := Money.String
+	w.Write([]string{"statement", st.Username, st.From, st.To})
+	w.Write([]string{})
+	w.Write([]string{"balance", "time", "cash", "holdings_value", "total", "unrealized_pl"})
+	w.Write([]string{"opening", st.Opening.Time, f(st.Opening.Cash), f(st.Opening.HoldingsValue), f(st.Opening.Total), f(st.Opening.UnrealizedPL)})
+	w.Write([]string{"closing", st.Closing.Time, f(st.Closing.Cash), f(st.Closing.HoldingsValue), f(st.Closing.Total), f(st.Closing.UnrealizedPL)})
+	w.Write([]string{})
+	w.Write([]string{"bought", "sold", "fees", "dividends", "interest", "realized_pl", "net_cash"})
+	s := st.Summary
+	w.Write([]string{f(s.Bought), f(s.Sold), f(s.Fees), f(s.Dividends), f(s.Interest), f(s.RealizedPL), f(s.NetCash)})
+	w.Write([]string{})
+	w.Write([]string{"closing_position", "shares", "price", "value", "cost", "unrealized_pl"})
+	for _, p := range st.Closing.Positions {
+		w.Write([]string{p.StockID, fmt.Sprint(p.Shares), fmt.Sprintf("%.4f", p.Price), f(p.Value), f(p.Cost), f(p.UnrealizedPL)})
+	}
+	w.Write([]string{})
+	w.Write([]string{"id", "time", "symbol", "action", "shares", "price", "fee", "cash_effect", "realized_pl"})
+	for _, l := range st.Transactions {
+		w.Write([]string{fmt.Sprint(l.ID), l.Time, l.StockID, l.Action, fmt.Sprint(l.Shares), fmt.Sprintf("%.4f", l.Price), f(l.Fee), f(l.CashEffect), f(l.RealizedPL)})
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+func (st Statement) pdfBytes() []byte {
+	lines := []string{
+		"StockSim account statement",
+		"",
+		fmt.Sprintf("Account: %s (#%d)", st.Username, st.UserID),
+		fmt.Sprintf("Period:  %s to %s", st.From, st.To),
+		fmt.Sprintf("Created: %s", st.GeneratedAt),
+		"",
+		fmt.Sprintf("%-10s %14s %16s %14s %14s", "", "Cash", "Holdings", "Total", "Unrealized"),
+		fmt.Sprintf("%-10s %14s %16s %14s %14s", "Opening", st.Opening.Cash, st.Opening.HoldingsValue, st.Opening.Total, st.Opening.UnrealizedPL),
+		fmt.Sprintf("%-10s %14s %16s %14s %14s", "Closing", st.Closing.Cash, st.Closing.HoldingsValue, st.Closing.Total, st.Closing.UnrealizedPL),
+		"",
+		fmt.Sprintf("Bought %s   Sold %s   Fees %s", st.Summary.Bought, st.Summary.Sold, st.Summary.Fees),
+		fmt.Sprintf("Dividends %s   Interest %s   Realized P&L %s   Net cash %s", st.Summary.Dividends, st.Summary.Interest, st.Summary.RealizedPL, st.Summary.NetCash),
+		"",
+		"Closing positions",
+		fmt.Sprintf("%-22s %8s %12s %12s %12s %12s", "Symbol", "Shares", "Price", "Value", "Cost", "Unrealized"),
+	}
+	for _, p := range st.Closing.Positions {
+		lines = append(lines, fmt.Sprintf("%-22s %8s %12.4f %12s %12s %12s", p.StockID, p.Shares, p.Price, p.Value, p.Cost, p.UnrealizedPL))
+	}
+	lines = append(lines, "", "Transactions",
+		fmt.Sprintf("%-19s %-20s %-13s %7s %10s %7s %11s %9s", "Time", "Symbol", "Action", "Qty", "Price", "Fee", "Cash", "Realized"))
+	for _, l := range st.Transactions {
+		t := l.Time
+		if pt, err := time.Parse(time.RFC3339, l.Time); err == nil {
+			t = pt.Format("2006-01-02 15:04:05")
+		}
+		lines = append(lines, fmt.Sprintf("%-19s %-20s %-13s %7s %10.2f %7s %11s %9s", t, l.StockID, l.Action, l.Shares, l.Price, l.Fee, l.CashEffect, l.RealizedPL))
+	}
+	if len(st.Transactions) == 0 {
+		lines = append(lines, "(none)")
+	}
+	return renderTextPDF(lines)
+}
+
+// dates on their own cover the whole day, everything else goes through the same parser as config times
+func parseStatementTime(v string, endOfDay bool) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if len(v) == len("2006-01-02") {
+		t, err := parseCompTime(v + " 00:00")
+		if err == nil && endOfDay {
+			t = t.Add(24*time.Hour - time.Second)
+		}
+		return t, err
+	}
+	return parseCompTime(v)
+}
+
+// GET /api/statements?from=2025-08-17&to=2025-08-31&format=csv|pdf|json, defaults to the whole competition so far
+func statementsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	userID, err := parseUserIDFromRequest(r)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	q := r.URL.Query()
+	now := time.Now()
+	from, to := compStart, now
+	if comp, err := accountCompetition(db, userID); err == nil {
+		from = comp.Start
+	}
+	if v := q.Get("from"); v != "" {
+		if from, err = parseStatementTime(v, false); err != nil {
+			http.Error(w, "invalid from", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if to, err = parseStatementTime(v, true); err != nil {
+			http.Error(w, "invalid to", http.StatusBadRequest)
+			return
+		}
+	}
+	if to.After(now) {
+		to = now
+	}
+	if !to.After(from) {
+		http.Error(w, "to must be after from", http.StatusBadRequest)
+		return
+	}
+
+	st, err := buildStatement(userID, from, to)
+	if err == sql.ErrNoRows {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
+	user := strings.Map(func(c rune) rune {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_' {
+			return c
+		}
+		return -1
+	}, st.Username)
+	name := fmt.Sprintf("statement-%s-%s-%s", user, from.Local().Format("20060102"), to.Local().Format("20060102"))
+	switch strings.ToLower(q.Get("format")) {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+		w.Write(st.csvBytes())
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.pdf"`)
+		w.Write(st.pdfBytes())
+	case "", "json":
+		writeJSON(w, st)
+	default:
+		http.Error(w, "format must be json, csv or pdf", http.StatusBadRequest)
+	}
+}
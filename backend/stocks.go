@@ -49,7 +49,7 @@ var (
 		},
 	}
 
-	clients     = make(map[*websocket.Conn]bool)
+	clients     = make(map[*websocket.Conn]chan []byte) // each conn's send queue, drained by its wsWriter
 	clientsLock sync.Mutex
 	writeWait   = 5 * time.Second
 	pingPeriod  = 25 * time.Second
@@ -63,10 +63,26 @@ func stocksHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// a competition with its own universe only lists its stocks
+	comp, err := competitionFromRequest(r)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+
 	stocksLock.Lock()
 	defer stocksLock.Unlock()
+	out := stocks
+	if len(comp.Stocks) > 0 {
+		out = make([]Stock, 0, len(comp.Stocks))
+		for _, s := range stocks {
+			if comp.allows(s.ID) {
+				out = append(out, s)
+			}
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(stocks)
+	json.NewEncoder(w).Encode(out)
 }
 
 func init() {
@@ -204,11 +220,6 @@ func finalizeCompMinutes(currentMinKey int64) {
 	}
 }
 
-func writeJSONToConn(conn *websocket.Conn, payload interface{}) error {
-	conn.SetWriteDeadline(time.Now().Add(writeWait))
-	return conn.WriteJSON(payload)
-}
-
 func pricesWSHandler(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -216,61 +227,30 @@ func pricesWSHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// snapshot goes in the queue before the conn is registered so it is always the first message
+	send := make(chan []byte, wsBufferSize)
+	if snap, err := json.Marshal(pricesSnapshot()); err == nil {
+		send <- snap
+	}
 	clientsLock.Lock()
-	clients[conn] = true
+	clients[conn] = send
 	clientsLock.Unlock()
+	go wsWriter(conn, send)
 
-	stocksLock.Lock()
-	initial := make([]Stock, len(stocks))
-	copy(initial, stocks)
-	stocksLock.Unlock()
-
-	_ = writeJSONToConn(conn, map[string]interface{}{
-		"stocks": initial,
-		"time":   time.Now().Local().Format(time.RFC3339),
-	})
 	conn.SetReadLimit(1024)
 	conn.SetReadDeadline(time.Now().Add(pongWait))
 	conn.SetPongHandler(func(string) error {
 		conn.SetReadDeadline(time.Now().Add(pongWait))
 		return nil
 	})
-	stopPing := make(chan struct{})
-	go func() {
-		t := time.NewTicker(pingPeriod)
-		defer t.Stop()
-		for {
-			select {
-			case <-t.C:
-				conn.SetWriteDeadline(time.Now().Add(writeWait))
-				if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-					// remove on error
-					clientsLock.Lock()
-					if _, ok := clients[conn]; ok {
-						delete(clients, conn)
-					}
-					clientsLock.Unlock()
-					conn.Close()
-					return
-				}
-			case <-stopPing:
-				return
-			}
-		}
-	}()
 	for {
 		if _, _, err := conn.ReadMessage(); err != nil {
-			clientsLock.Lock()
-			if _, ok := clients[conn]; ok {
-				delete(clients, conn)
-			}
-			clientsLock.Unlock()
-			close(stopPing)
-			conn.Close()
+			removeWSClient(conn)
 			return
 		}
 	}
 }
+
 func priceTicker() {
 	log.Println("priceTicker.")
 	tickInterval := 3 * time.Second
@@ -286,6 +266,14 @@ func priceTicker() {
 
 		stocksLock.Lock()
 		for i := range stocks {
+			if stocks[i].Halted {
+				// no trading, no price
+				stocks[i].Change = 0
+				continue
+			}
+			if stocks[i].Kind == basketKind {
+				continue
+			}
 			id := stocks[i].ID
 			oldPrice := stocks[i].Price
 
@@ -304,6 +292,7 @@ func priceTicker() {
 			stocks[i].Price = newPrice
 			appendTick(id, newPrice, vol)
 		}
+		repriceBaskets()
 
 		updated := make([]Stock, len(stocks))
 		copy(updated, stocks)
@@ -316,35 +305,13 @@ func priceTicker() {
 }
 
 func broadcastPrices(data []Stock) {
-	// broadcasts to websocket clients
-	msg, err := json.Marshal(map[string]interface{}{
-		"stocks": data,
-		"time":   time.Now().Local().Format(time.RFC3339),
+	// broadcasts to websocket and sse clients
+	publishEvent("prices", map[string]interface{}{
+		"type":    "prices",
+		"stocks":  data,
+		"indices": updateIndices(data),
+		"time":    time.Now().Local().Format(time.RFC3339),
 	})
-	if err != nil {
-		log.Println("broadcast marshal error:", err)
-		return
-	}
-	clientsLock.Lock()
-	conns := make([]*websocket.Conn, 0, len(clients))
-	for c := range clients {
-		conns = append(conns, c)
-	}
-	clientsLock.Unlock()
-
-	for _, c := range conns {
-		c.SetWriteDeadline(time.Now().Add(writeWait))
-		if err := c.WriteMessage(websocket.TextMessage, msg); err != nil {
-			log.Println("WebSocket write error, removing client:", err)
-			// cleanup
-			clientsLock.Lock()
-			if _, ok := clients[c]; ok {
-				delete(clients, c)
-			}
-			clientsLock.Unlock()
-			_ = c.Close()
-		}
-	}
 }
 
 func historyHandler(w http.ResponseWriter, r *http.Request) {
@@ -369,6 +336,15 @@ func historyHandler(w http.ResponseWriter, r *http.Request) {
 	if points > maxTicksPerStock {
 		points = maxTicksPerStock
 	}
+	if strings.HasPrefix(stock, "^") {
+		out, ok := indexHistoryOut(stock, points)
+		if !ok {
+			http.Error(w, "unknown index", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, out)
+		return
+	}
 
 	tickLock.Lock()
 	buf, ok := tickBuffer[stock]
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// one message that went out to every streaming client, ws and sse get the exact same bytes
+type StreamEvent struct {
+	ID    int64
+	Event string
+	Data  []byte
+}
+
+var (
+	streamLock       sync.Mutex
+	streamNextID     int64
+	streamBacklog    []StreamEvent
+	maxStreamBacklog = 1000
+
+	sseClients    = make(map[chan StreamEvent]bool)
+	sseBufferSize = 256
+	wsBufferSize  = 256
+)
+
+// publishEvent is the single fan-out point, prices, news and status all go through here
+func publishEvent(event string, payload interface{}) {
+	msg, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("stream marshal error:", err)
+		return
+	}
+
+	streamLock.Lock()
+	streamNextID++
+	ev := StreamEvent{ID: streamNextID, Event: event, Data: msg}
+	streamBacklog = append(streamBacklog, ev)
+	if len(streamBacklog) > maxStreamBacklog {
+		start := len(streamBacklog) - maxStreamBacklog
+		streamBacklog = streamBacklog[start:]
+	}
+	for ch := range sseClients {
+		select {
+		case ch <- ev:
+		default:
+			// client cant keep up, drop it. it will reconnect with Last-Event-ID and catch up from the backlog
+			delete(sseClients, ch)
+			close(ch)
+		}
+	}
+	streamLock.Unlock()
+
+	writeToWebsockets(msg)
+}
+
+// only queues the message, each conn's wsWriter does the writing since gorilla allows one writer per conn
+func writeToWebsockets(msg []byte) {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+	for c, send := range clients {
+		select {
+		case send <- msg:
+		default:
+			// same as sse, a client that cant keep up is dropped and reconnects
+			log.Println("WebSocket client too slow, removing")
+			delete(clients, c)
+			close(send)
+		}
+	}
+}
+
+// safe to call more than once, closing send stops the conn's writer which closes the conn
+func removeWSClient(conn *websocket.Conn) {
+	clientsLock.Lock()
+	if send, ok := clients[conn]; ok {
+		delete(clients, conn)
+		close(send)
+	}
+	clientsLock.Unlock()
+}
+
+// the only goroutine that writes to conn, messages and pings both
+func wsWriter(conn *websocket.Conn, send chan []byte) {
+	ping := time.NewTicker(pingPeriod)
+	defer func() {
+		ping.Stop()
+		conn.Close()
+	}()
+	for {
+		select {
+		case msg, open := <-send:
+			if !open {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				log.Println("WebSocket write error, removing client:", err)
+				removeWSClient(conn)
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				removeWSClient(conn)
+				return
+			}
+		}
+	}
+}
+
+func pricesSnapshot() map[string]interface{} {
+	stocksLock.Lock()
+	initial := make([]Stock, len(stocks))
+	copy(initial, stocks)
+	stocksLock.Unlock()
+
+	return map[string]interface{}{
+		"type":    "prices",
+		"stocks":  initial,
+		"indices": indexSnapshot(),
+		"time":    time.Now().Local().Format(time.RFC3339),
+	}
+}
+
+func writeSSE(w http.ResponseWriter, ev StreamEvent) error {
+	var b strings.Builder
+	if ev.ID > 0 {
+		fmt.Fprintf(&b, "id: %d\n", ev.ID)
+	}
+	fmt.Fprintf(&b, "event: %s\n", ev.Event)
+	fmt.Fprintf(&b, "data: %s\n\n", ev.Data)
+	_, err := w.Write([]byte(b.String()))
+	return err
+}
+
+// /api/stream, same feed as /ws/prices but over text/event-stream for networks that block websockets
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// EventSource sends the header on reconnect, query param is for clients that resume by hand
+	var lastID int64
+	lastStr := r.Header.Get("Last-Event-ID")
+	if lastStr == "" {
+		lastStr = r.URL.Query().Get("last_event_id")
+	}
+	if lastStr != "" {
+		if id, err := strconv.ParseInt(strings.TrimSpace(lastStr), 10, 64); err == nil && id > 0 {
+			lastID = id
+		}
+	}
+
+	// ?events=news,status limits what this client gets, default is everything
+	var only map[string]bool
+	if ev := strings.TrimSpace(r.URL.Query().Get("events")); ev != "" {
+		only = map[string]bool{}
+		for _, name := range strings.Split(ev, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				only[name] = true
+			}
+		}
+	}
+	wants := func(event string) bool {
+		return only == nil || only[event]
+	}
+
+	ch := make(chan StreamEvent, sseBufferSize)
+	var replay []StreamEvent
+	resumed := false
+
+	streamLock.Lock()
+	// only resume if nothing was trimmed out of the backlog in between, otherwise start fresh with a snapshot
+	if lastID > 0 && lastID <= streamNextID && len(streamBacklog) > 0 && streamBacklog[0].ID <= lastID+1 {
+		for _, ev := range streamBacklog {
+			if ev.ID > lastID {
+				replay = append(replay, ev)
+			}
+		}
+		resumed = true
+	}
+	sseClients[ch] = true
+	streamLock.Unlock()
+
+	defer func() {
+		streamLock.Lock()
+		if _, ok := sseClients[ch]; ok {
+			delete(sseClients, ch)
+			close(ch)
+		}
+		streamLock.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: 3000\n\n")
+	if !resumed && wants("prices") {
+		// no id on the snapshot so it doesnt move the client's Last-Event-ID
+		snap, err := json.Marshal(pricesSnapshot())
+		if err == nil {
+			_ = writeSSE(w, StreamEvent{Event: "prices", Data: snap})
+		}
+	}
+	for _, ev := range replay {
+		if !wants(ev.Event) {
+			continue
+		}
+		if err := writeSSE(w, ev); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	ping := time.NewTicker(pingPeriod)
+	defer ping.Stop()
+
+	for {
+		select {
+		case ev, open := <-ch:
+			if !open {
+				return
+			}
+			if !wants(ev.Event) {
+				continue
+			}
+			if err := writeSSE(w, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ping.C:
+			if _, err := fmt.Fprintf(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -11,11 +11,11 @@ import (
 )
 
 type TeamMember struct {
-	UserID   int64   `json:"user_id"`
-	Username string  `json:"username"`
-	Cash     float64 `json:"cash"`
-	Networth float64 `json:"networth"`
-	JoinedAt string  `json:"joined_at"`
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+	Cash     Money  `json:"cash"`
+	Networth Money  `json:"networth"`
+	JoinedAt string `json:"joined_at"`
 }
 
 type TeamOut struct {
@@ -25,8 +25,8 @@ type TeamOut struct {
 	Members     []TeamMember `json:"members"`
 	MemberCount int          `json:"member_count"`
 	Capacity    int          `json:"capacity"`
-	TeamCash    float64      `json:"team_cash"`
-	TeamValue   float64      `json:"team_value"`
+	TeamCash    Money        `json:"team_cash"`
+	TeamValue   Money        `json:"team_value"`
 	AvgNetworth float64      `json:"avg_networth"`
 	Rank        int          `json:"rank,omitempty"`
 }
@@ -62,15 +62,15 @@ func teamsHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "invalid team id", http.StatusBadRequest)
 			return
 		}
-		handleSingleTeam(w, teamID)
+		handleSingleTeam(w, teamID, competitionIDFromRequest(r))
 		return
 	}
 	if r.URL.Query().Get("view") == "summary" {
-		handleTeamsSummary(w)
+		handleTeamsSummary(w, competitionIDFromRequest(r))
 		return
 	}
 
-	handleAllTeams(w)
+	handleAllTeams(w, competitionIDFromRequest(r))
 }
 
 // admin only edit capacity
@@ -113,8 +113,9 @@ func editTeamHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func handleSingleTeam(w http.ResponseWriter, teamID int64) {
-	team, err := getTeamDetails(teamID)
+// teams are shared, their members and values only count accounts in competition compID
+func handleSingleTeam(w http.ResponseWriter, teamID, compID int64) {
+	team, err := getTeamDetails(teamID, compID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "team not found", http.StatusNotFound)
@@ -128,7 +129,7 @@ func handleSingleTeam(w http.ResponseWriter, teamID int64) {
 	json.NewEncoder(w).Encode(team)
 }
 
-func handleTeamsSummary(w http.ResponseWriter) {
+func handleTeamsSummary(w http.ResponseWriter, compID int64) {
 	rows, err := db.Query("SELECT id, name, created_at FROM teams ORDER BY created_at DESC")
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
@@ -146,7 +147,7 @@ func handleTeamsSummary(w http.ResponseWriter) {
 		}
 
 		// calculate team stats
-		memberCount, teamValue := calculateTeamStats(teamID)
+		memberCount, teamValue := calculateTeamStats(teamID, compID)
 
 		teams = append(teams, TeamSummary{
 			ID:          teamID,
@@ -170,7 +171,7 @@ func handleTeamsSummary(w http.ResponseWriter) {
 	json.NewEncoder(w).Encode(teams)
 }
 
-func handleAllTeams(w http.ResponseWriter) {
+func handleAllTeams(w http.ResponseWriter, compID int64) {
 	rows, err := db.Query("SELECT id, name, created_at FROM teams ORDER BY created_at DESC")
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
@@ -187,7 +188,7 @@ func handleAllTeams(w http.ResponseWriter) {
 			continue
 		}
 
-		team, err := getTeamDetails(teamID)
+		team, err := getTeamDetails(teamID, compID)
 		if err != nil {
 			continue
 		}
@@ -208,7 +209,7 @@ func handleAllTeams(w http.ResponseWriter) {
 	json.NewEncoder(w).Encode(teams)
 }
 
-func getTeamDetails(teamID int64) (*TeamOut, error) {
+func getTeamDetails(teamID, compID int64) (*TeamOut, error) {
 	var name string
 	var created sql.NullString
 
@@ -222,24 +223,24 @@ func getTeamDetails(teamID int64) (*TeamOut, error) {
 	}
 
 	memberRows, err := db.Query(`
-		SELECT u.id, u.school_code, u.cash, u.created_at 
+		SELECT u.id, `+accountNameSQL+`, u.cash, u.created_at 
 		FROM users u 
-		WHERE u.team_id = ? 
+		WHERE u.team_id = ? AND u.competition_id = ?
 		ORDER BY u.created_at ASC
-	`, teamID)
+	`, teamID, compID)
 	if err != nil {
 		return nil, err
 	}
 	defer memberRows.Close()
 
 	var members []TeamMember
-	var totalCash float64
-	var totalValue float64
+	var totalCash Money
+	var totalValue Money
 
 	for memberRows.Next() {
 		var userID int64
 		var username string
-		var cash float64
+		var cash Money
 		var joinedAt sql.NullString
 
 		if err := memberRows.Scan(&userID, &username, &cash, &joinedAt); err != nil {
@@ -254,7 +255,7 @@ func getTeamDetails(teamID int64) (*TeamOut, error) {
 			UserID:   userID,
 			Username: username,
 			Cash:     cash,
-			Networth: roundToTwo(networth),
+			Networth: networth,
 		}
 
 		if joinedAt.Valid {
@@ -268,7 +269,7 @@ func getTeamDetails(teamID int64) (*TeamOut, error) {
 
 	avgNetworth := 0.0
 	if len(members) > 0 {
-		avgNetworth = totalValue / float64(len(members))
+		avgNetworth = totalValue.Float() / float64(len(members))
 	}
 
 	team := &TeamOut{
@@ -277,8 +278,8 @@ func getTeamDetails(teamID int64) (*TeamOut, error) {
 		Members:     members,
 		MemberCount: len(members),
 		Capacity:    6, // fallback, db issues can occur fr some reason idk
-		TeamCash:    roundToTwo(totalCash),
-		TeamValue:   roundToTwo(totalValue),
+		TeamCash:    totalCash,
+		TeamValue:   totalValue,
 		AvgNetworth: roundToTwo(avgNetworth),
 	}
 
@@ -293,17 +294,18 @@ func getTeamDetails(teamID int64) (*TeamOut, error) {
 	return team, nil
 }
 
-func calculateUserPortfolioValue(userID int64) float64 {
+// everything but cash, in cents so networths rank exactly
+func calculateUserPortfolioValue(userID int64) Money {
 	rows, err := db.Query("SELECT stock_id, shares FROM portfolio WHERE user_id = ?", userID)
 	if err != nil {
 		return 0
 	}
 	defer rows.Close()
 
-	var total float64
+	var total Money
 	for rows.Next() {
 		var stockID string
-		var shares int64
+		var shares Qty
 		if err := rows.Scan(&stockID, &shares); err != nil {
 			continue
 		}
@@ -312,21 +314,21 @@ func calculateUserPortfolioValue(userID int64) float64 {
 		if err != nil {
 			price = 0
 		}
-		total += float64(shares) * price
+		total += costOf(shares, price)
 	}
 
-	return total
+	return total + ipoEscrow(db, userID) + moneyFromFloat(optionPositionsValue(db, userID)+bondPositionsValue(db, userID))
 }
 
-func calculateTeamStats(teamID int64) (int, float64) {
-	rows, err := db.Query("SELECT id FROM users WHERE team_id = ?", teamID)
+func calculateTeamStats(teamID, compID int64) (int, float64) {
+	rows, err := db.Query("SELECT id FROM users WHERE team_id = ? AND competition_id = ?", teamID, compID)
 	if err != nil {
 		return 0, 0
 	}
 	defer rows.Close()
 
 	var memberCount int
-	var totalValue float64
+	var totalValue Money
 
 	for rows.Next() {
 		var userID int64
@@ -335,7 +337,7 @@ func calculateTeamStats(teamID int64) (int, float64) {
 		}
 		memberCount++
 
-		var cash float64
+		var cash Money
 		if err := db.QueryRow("SELECT cash FROM users WHERE id = ?", userID).Scan(&cash); err != nil {
 			continue
 		}
@@ -344,7 +346,7 @@ func calculateTeamStats(teamID int64) (int, float64) {
 		totalValue += cash + portfolioValue
 	}
 
-	return memberCount, totalValue
+	return memberCount, totalValue.Float()
 }
 
 func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
@@ -361,18 +363,19 @@ func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	type Entry struct {
-		UserID   int64   `json:"user_id"`
-		Username string  `json:"username"`
-		TeamName string  `json:"team_name"`
-		Networth float64 `json:"networth"`
-		Rank     int     `json:"rank"`
+		UserID   int64  `json:"user_id"`
+		Username string `json:"username"`
+		TeamName string `json:"team_name"`
+		Networth Money  `json:"networth"`
+		Rank     int    `json:"rank"`
 	}
 
 	rows, err := db.Query(`
-		SELECT u.id, u.school_code, u.cash, t.name
+		SELECT u.id, `+accountNameSQL+`, u.cash, t.name
 		FROM users u
 		LEFT JOIN teams t ON u.team_id = t.id
-	`)
+		WHERE u.competition_id = ?
+	`, competitionIDFromRequest(r))
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
@@ -384,7 +387,7 @@ func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var uid int64
 		var username string
-		var cash float64
+		var cash Money
 		var teamName sql.NullString
 
 		if err := rows.Scan(&uid, &username, &cash, &teamName); err != nil {
@@ -392,7 +395,7 @@ func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		portfolioValue := calculateUserPortfolioValue(uid)
-		networth := roundToTwo(cash + portfolioValue)
+		networth := cash + portfolioValue
 
 		entries = append(entries, Entry{
 			UserID:   uid,
@@ -440,7 +443,8 @@ func teamLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	rows, err := db.Query("SELECT DISTINCT team_id FROM users WHERE team_id IS NOT NULL")
+	compID := competitionIDFromRequest(r)
+	rows, err := db.Query("SELECT DISTINCT team_id FROM users WHERE team_id IS NOT NULL AND competition_id = ?", compID)
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
@@ -451,10 +455,10 @@ func teamLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
 		TeamID      int64   `json:"team_id"`
 		TeamName    string  `json:"team_name"`
 		MemberCount int     `json:"member_count"`
-		TeamValue   float64 `json:"team_value"`
+		TeamValue   Money   `json:"team_value"`
 		AvgNetworth float64 `json:"avg_networth"`
 		TopMember   string  `json:"top_member"`
-		TopNetworth float64 `json:"top_networth"`
+		TopNetworth Money   `json:"top_networth"`
 		Rank        int     `json:"rank"`
 	}
 
@@ -470,20 +474,20 @@ func teamLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
 		if err := db.QueryRow("SELECT name FROM teams WHERE id = ?", teamID).Scan(&teamName); err != nil {
 			continue
 		}
-		memberRows, err := db.Query("SELECT id, school_code, cash FROM users WHERE team_id = ?", teamID)
+		memberRows, err := db.Query("SELECT u.id, "+accountNameSQL+", u.cash FROM users u WHERE u.team_id = ? AND u.competition_id = ?", teamID, compID)
 		if err != nil {
 			continue
 		}
 
-		var totalValue float64
+		var totalValue Money
 		var memberCount int
 		var topMember string
-		var topNetworth float64
+		var topNetworth Money
 
 		for memberRows.Next() {
 			var userID int64
 			var username string
-			var cash float64
+			var cash Money
 
 			if err := memberRows.Scan(&userID, &username, &cash); err != nil {
 				continue
@@ -503,17 +507,17 @@ func teamLeaderboardHandler(w http.ResponseWriter, r *http.Request) {
 
 		avgNetworth := 0.0
 		if memberCount > 0 {
-			avgNetworth = totalValue / float64(memberCount)
+			avgNetworth = totalValue.Float() / float64(memberCount)
 		}
 
 		teams = append(teams, TeamLeaderboardEntry{
 			TeamID:      teamID,
 			TeamName:    teamName,
 			MemberCount: memberCount,
-			TeamValue:   roundToTwo(totalValue),
+			TeamValue:   totalValue,
 			AvgNetworth: roundToTwo(avgNetworth),
 			TopMember:   topMember,
-			TopNetworth: roundToTwo(topNetworth),
+			TopNetworth: topNetworth,
 		})
 	}
 	for i := 0; i < len(teams); i++ {
@@ -548,7 +552,7 @@ func joinTeamHandler(w http.ResponseWriter, r *http.Request) {
 
 	userID, err := parseUserIDFromRequest(r)
 	if err != nil {
-		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		writeAuthError(w, err)
 		return
 	}
 
@@ -589,9 +593,9 @@ func joinTeamHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// get capacity
+	// get capacity, only counting members in this account's competition
 	var memberCount int
-	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE team_id = ?", req.TeamID).Scan(&memberCount); err != nil {
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE team_id = ? AND competition_id = (SELECT competition_id FROM users WHERE id = ?)", req.TeamID, userID).Scan(&memberCount); err != nil {
 		tx.Rollback()
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
@@ -656,7 +660,7 @@ func createTeamHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	userID, err := parseUserIDFromRequest(r)
 	if err != nil {
-		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		writeAuthError(w, err)
 		return
 	}
 	var req struct {
@@ -4,8 +4,12 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
-	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // this gets stock price for any given stock symbol
@@ -20,6 +24,21 @@ func getStockPrice(stockID string) (float64, error) {
 	return 0, errors.New("stock not found")
 }
 
+// a user's trades run one at a time so reads of cash and shares inside a trade cant go stale under a second
+// trade from the same account, and _txlock=immediate stops the same race across processes. the locks are a
+// fixed set striped by account id so nothing grows with the number of accounts, two accounts on the same
+// stripe just wait for each other. nothing holds two account locks at once
+const accountLockStripes = 256
+
+var accountLocks [accountLockStripes]sync.Mutex
+
+// blocks until no other trade for userID is running, call the returned func when done
+func lockAccount(userID int64) func() {
+	mu := &accountLocks[uint64(userID)%accountLockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
 // this took me lot of time to get right lol, very proud of this <3
 func tradeHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodOptions {
@@ -35,36 +54,80 @@ func tradeHandler(w http.ResponseWriter, r *http.Request) {
 		UserID  int64  `json:"user_id"`
 		StockID string `json:"stock_id"`
 		Action  string `json:"action"` // buy or sell
-		Shares  int64  `json:"shares"`
+		Shares  Qty    `json:"shares"` // fractional up to share_decimals
+		Amount  Money  `json:"amount"` // or size the order in cash instead, "invest 1000 in APEX"
+
+		LotMethod string `json:"lot_method"` // fifo or lifo for sells, config default otherwise
+
+		// the price the user saw, the trade is refused if it has since moved against them by more than max_slippage
+		ExpectedPrice float64 `json:"expected_price"`
+		MaxSlippage   float64 `json:"max_slippage"` // 0.01 = 1%, config default otherwise
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
 		http.Error(w, "invalid json", http.StatusBadRequest)
 		return
 	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, badJSON(err), http.StatusBadRequest)
+		return
+	}
 
-	userID := req.UserID
-	if userID == 0 {
-		if c, err := r.Cookie("stocksim_user"); err == nil {
-			if id, err2 := strconv.ParseInt(c.Value, 10, 64); err2 == nil {
-				userID = id
-			}
-		}
+	// the account in the request's competition, for the user_id in the body or the cookie's login
+	var userID int64
+	if req.UserID != 0 {
+		userID, err = accountInContext(r, req.UserID)
+	} else {
+		userID, err = parseUserIDFromRequest(r)
 	}
-	if userID == 0 {
-		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+	if err != nil {
+		writeAuthError(w, err)
 		return
 	}
 
-	if req.Shares <= 0 {
-		http.Error(w, "shares must be > 0", http.StatusBadRequest)
+	// same key again means a retry or a double click, answer with the first response instead of trading twice
+	if key := strings.TrimSpace(r.Header.Get("Idempotency-Key")); key != "" {
+		if !claimIdempotencyKey(w, userID, key, body) {
+			return
+		}
+		defer holdIdempotencyKey(userID, key)()
+		iw := &idempotentWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			if p := recover(); p != nil {
+				iw.status = http.StatusInternalServerError
+				finishIdempotencyKey(userID, key, iw)
+				panic(p)
+			}
+			finishIdempotencyKey(userID, key, iw)
+		}()
+		w = iw
+	}
+
+	leg := tradeLeg{StockID: req.StockID, Action: req.Action, Shares: req.Shares, Amount: req.Amount, LotMethod: req.LotMethod}
+	if err := leg.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	defer lockAccount(userID)()
+
+	// a split or buyback cant run halfway through this trade
+	corpActionLock.RLock()
+	defer corpActionLock.RUnlock()
+
 	price, err := getStockPrice(req.StockID)
 	if err != nil {
 		http.Error(w, "unknown stock", http.StatusBadRequest)
 		return
 	}
+	if msg := haltMessage(req.StockID); msg != "" {
+		http.Error(w, msg, http.StatusConflict)
+		return
+	}
+	if err := checkSlippage(req.Action, price, req.ExpectedPrice, req.MaxSlippage); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 
 	tx, err := db.Begin()
 	if err != nil {
@@ -78,125 +141,191 @@ func tradeHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	// read user cash (use userID determined from cookie/payload)
-	var cash float64
-	if err := tx.QueryRow("SELECT cash FROM users WHERE id = ?", userID).Scan(&cash); err != nil {
-		if err == sql.ErrNoRows {
-			tx.Rollback()
-			http.Error(w, "user not found", http.StatusNotFound)
-			return
-		}
+	if _, err := executeTrade(tx, userID, leg, price); err != nil {
 		tx.Rollback()
-		http.Error(w, "db error", http.StatusInternalServerError)
+		writeTradeError(w, err)
 		return
 	}
 
-	cost := float64(req.Shares) * price
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		http.Error(w, "db commit error", http.StatusInternalServerError)
+		return
+	}
 
-	switch req.Action {
-	case "buy":
-		if cash < cost {
-			tx.Rollback()
-			http.Error(w, "insufficient funds", http.StatusBadRequest)
-			return
-		}
+	rGet := r.Clone(r.Context())
+	rGet.Method = http.MethodGet
+	portfolioHandler(w, rGet)
+}
 
-		var curShares int64
-		var curAvg float64
-		row := tx.QueryRow("SELECT shares, avg_price FROM portfolio WHERE user_id = ? AND stock_id = ?", userID, req.StockID)
-		err = row.Scan(&curShares, &curAvg)
-		if err == sql.ErrNoRows {
-			if _, err = tx.Exec("INSERT INTO portfolio(user_id, stock_id, shares, avg_price) VALUES(?,?,?,?)", userID, req.StockID, req.Shares, price); err != nil {
-				tx.Rollback()
-				http.Error(w, "db insert error", http.StatusInternalServerError)
-				return
-			}
-		} else if err == nil {
-			newShares := curShares + req.Shares
-			newAvg := ((float64(curShares) * curAvg) + (float64(req.Shares) * price)) / float64(newShares)
-			if _, err = tx.Exec("UPDATE portfolio SET shares = ?, avg_price = ? WHERE user_id = ? AND stock_id = ?", newShares, newAvg, userID, req.StockID); err != nil {
-				tx.Rollback()
-				http.Error(w, "db update error", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			tx.Rollback()
-			http.Error(w, "db error", http.StatusInternalServerError)
-			return
-		}
+// one buy or sell, sized by shares or by amount
+type tradeLeg struct {
+	StockID   string `json:"stock_id"`
+	Action    string `json:"action"`
+	Shares    Qty    `json:"shares,omitempty"`
+	Amount    Money  `json:"amount,omitempty"`
+	LotMethod string `json:"lot_method,omitempty"`
+}
 
-		if _, err = tx.Exec("UPDATE users SET cash = cash - ? WHERE id = ?", cost, userID); err != nil {
-			tx.Rollback()
-			http.Error(w, "db update error", http.StatusInternalServerError)
-			return
-		}
+// what a leg actually traded
+type tradeFill struct {
+	TransactionID int64   `json:"transaction_id"`
+	StockID       string  `json:"stock_id"`
+	Action        string  `json:"action"`
+	Shares        Qty     `json:"shares"`
+	Price         float64 `json:"price"`
+	Total         Money   `json:"total"`
+	Fee           Money   `json:"fee"`
+}
 
-		if _, err = tx.Exec("INSERT INTO transactions(user_id, stock_id, action, shares, price) VALUES(?,?,?,?,?)", userID, req.StockID, "buy", req.Shares, price); err != nil {
-			tx.Rollback()
-			http.Error(w, "db insert error", http.StatusInternalServerError)
-			return
+// a trade the user cant make (funds, shares, bad input), anything else out of executeTrade is a db problem
+type tradeRejected string
+
+func (e tradeRejected) Error() string { return string(e) }
+
+var errTradeUserNotFound = errors.New("user not found")
+
+func (l tradeLeg) validate() error {
+	if l.Shares != 0 && l.Amount != 0 {
+		return tradeRejected("give shares or amount, not both")
+	}
+	if l.Amount < 0 || (l.Amount == 0 && l.Shares <= 0) {
+		return tradeRejected("shares must be > 0")
+	}
+	if l.Amount == 0 && !l.Shares.valid() {
+		return tradeRejected(fmt.Sprintf("shares can have at most %d decimal places", shareDecimals))
+	}
+	return nil
+}
+
+func writeTradeError(w http.ResponseWriter, err error) {
+	var rejected tradeRejected
+	switch {
+	case errors.As(err, &rejected):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err == errTradeUserNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, "db error", http.StatusInternalServerError)
+	}
+}
+
+// runs one leg at price inside the caller's transaction: cash, lots, the position and the transactions row
+func executeTrade(tx *sql.Tx, userID int64, leg tradeLeg, price float64) (tradeFill, error) {
+	fill := tradeFill{StockID: leg.StockID, Action: leg.Action, Shares: leg.Shares, Price: price}
+	if leg.Action != "buy" && leg.Action != "sell" {
+		return fill, tradeRejected("action must be buy or sell")
+	}
+
+	var cash Money
+	if err := tx.QueryRow("SELECT cash FROM users WHERE id = ?", userID).Scan(&cash); err == sql.ErrNoRows {
+		return fill, errTradeUserNotFound
+	} else if err != nil {
+		return fill, err
+	}
+	comp, err := accountCompetition(tx, userID)
+	if err != nil {
+		return fill, err
+	}
+	if err := comp.checkOpen(time.Now()); err != nil {
+		return fill, err
+	}
+	if !comp.allows(leg.StockID) {
+		return fill, tradeRejected(leg.StockID + " isnt part of " + comp.Name)
+	}
+
+	if leg.Amount > 0 {
+		fill.Shares = sharesForAmount(leg.Action, leg.Amount, price)
+		if fill.Shares <= 0 {
+			return fill, tradeRejected("amount is too small to trade any shares at this price")
 		}
+	}
+	cost := costOf(fill.Shares, price)
+	fee := tradeFee(cost)
+	fill.Total, fill.Fee = cost, fee
+	row := txnRow{UserID: userID, StockID: leg.StockID, Action: leg.Action, Shares: fill.Shares, Price: price, Amount: cost, Fee: fee}
 
-	case "sell":
-		var curShares int64
-		var curAvg float64
-		row := tx.QueryRow("SELECT shares, avg_price FROM portfolio WHERE user_id = ? AND stock_id = ?", userID, req.StockID)
-		err = row.Scan(&curShares, &curAvg)
-		if err == sql.ErrNoRows {
-			tx.Rollback()
-			http.Error(w, "no shares to sell", http.StatusBadRequest)
-			return
-		} else if err != nil {
-			tx.Rollback()
-			http.Error(w, "db error", http.StatusInternalServerError)
-			return
+	if leg.Action == "buy" {
+		if cash < cost+fee {
+			return fill, tradeRejected("insufficient funds")
 		}
-		if curShares < req.Shares {
-			tx.Rollback()
-			http.Error(w, "not enough shares", http.StatusBadRequest)
-			return
+		if err := checkTradeRules(tx, comp.rules(), userID, leg.StockID, leg.Action, leg.LotMethod, fill.Shares, price, cost, fee, cash); err != nil {
+			return fill, err
 		}
-
-		if _, err = tx.Exec("UPDATE users SET cash = cash + ? WHERE id = ?", cost, userID); err != nil {
-			tx.Rollback()
-			http.Error(w, "db update error", http.StatusInternalServerError)
-			return
+		// the fee goes into the lot's cost basis
+		if err := openLot(tx, userID, leg.StockID, fill.Shares, (cost+fee).Float()/fill.Shares.Float(), "buy"); err != nil {
+			return fill, err
 		}
-
-		newShares := curShares - req.Shares
-		if newShares == 0 {
-			if _, err = tx.Exec("DELETE FROM portfolio WHERE user_id = ? AND stock_id = ?", userID, req.StockID); err != nil {
-				tx.Rollback()
-				http.Error(w, "db delete error", http.StatusInternalServerError)
-				return
-			}
-		} else {
-			if _, err = tx.Exec("UPDATE portfolio SET shares = ? WHERE user_id = ? AND stock_id = ?", newShares, userID, req.StockID); err != nil {
-				tx.Rollback()
-				http.Error(w, "db update error", http.StatusInternalServerError)
-				return
-			}
+		if err := syncHolding(tx, userID, leg.StockID); err != nil {
+			return fill, err
 		}
-
-		if _, err = tx.Exec("INSERT INTO transactions(user_id, stock_id, action, shares, price) VALUES(?,?,?,?,?)", userID, req.StockID, "sell", req.Shares, price); err != nil {
-			tx.Rollback()
-			http.Error(w, "db insert error", http.StatusInternalServerError)
-			return
+		if _, err := tx.Exec("UPDATE users SET cash = cash - ? WHERE id = ?", cost+fee, userID); err != nil {
+			return fill, err
 		}
+		id, err := recordTransaction(tx, row)
+		fill.TransactionID = id
+		return fill, err
+	}
 
-	default:
-		tx.Rollback()
-		http.Error(w, "action must be buy or sell", http.StatusBadRequest)
-		return
+	var held Qty
+	if err := tx.QueryRow("SELECT shares FROM portfolio WHERE user_id = ? AND stock_id = ?", userID, leg.StockID).Scan(&held); err == sql.ErrNoRows {
+		return fill, tradeRejected("no shares to sell")
+	} else if err != nil {
+		return fill, err
+	}
+	if held < fill.Shares {
+		return fill, tradeRejected("not enough shares")
 	}
+	if cash+cost < fee {
+		return fill, tradeRejected("insufficient funds for the fee")
+	}
+	if err := checkTradeRules(tx, comp.rules(), userID, leg.StockID, leg.Action, leg.LotMethod, fill.Shares, price, cost, fee, cash); err != nil {
+		return fill, err
+	}
+	if _, err := tx.Exec("UPDATE users SET cash = cash + ? WHERE id = ?", cost-fee, userID); err != nil {
+		return fill, err
+	}
+	id, err := recordTransaction(tx, row)
+	if err != nil {
+		return fill, err
+	}
+	fill.TransactionID = id
+	if _, err := closeLots(tx, userID, leg.StockID, fill.Shares, price, fee.Float(), leg.LotMethod, id, "sell"); err != nil {
+		return fill, err
+	}
+	return fill, syncHolding(tx, userID, leg.StockID)
+}
 
-	if err := tx.Commit(); err != nil {
-		tx.Rollback()
-		http.Error(w, "db commit error", http.StatusInternalServerError)
-		return
+// how far a price can move against expected_price when the trade doesnt say. "max_slippage" in config.json
+var defaultMaxSlippage = 0.01
+
+// only moves against the trader count, a buy that got cheaper or a sell that got dearer goes through
+func checkSlippage(action string, price, expected, maxSlippage float64) error {
+	if expected <= 0 {
+		return nil
+	}
+	if maxSlippage <= 0 {
+		maxSlippage = defaultMaxSlippage
+	}
+	moved := (price - expected) / expected
+	if action == "sell" {
+		moved = -moved
 	}
+	if moved > maxSlippage {
+		return fmt.Errorf("price moved from %.2f to %.2f, more than the %.2f%% allowed", expected, price, maxSlippage*100)
+	}
+	return nil
+}
 
-	rGet := r.Clone(r.Context())
-	rGet.Method = http.MethodGet
-	portfolioHandler(w, rGet)
+// shares for an order sized in cash. a buy spends at most amount including the fee, a sell raises about amount before it
+func sharesForAmount(action string, amount Money, price float64) Qty {
+	if action != "buy" {
+		return qtyForAmount(amount, price)
+	}
+	q := qtyForAmount(amount-tradeFee(amount), price)
+	step := qtyStep(shareDecimals)
+	for q > 0 && costOf(q, price)+tradeFee(costOf(q, price)) > amount {
+		q -= step
+	}
+	return q
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// a fresh sqlite db in a temp dir with two stocks and the main competition open
+func setupTradeTest(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.Chdir(wd)
+	})
+
+	stocksLock.Lock()
+	stocks = []Stock{
+		{ID: "APEX", Name: "Apex", Price: 100, Sector: "Technology"},
+		{ID: "NOVA", Name: "Nova", Price: 40, Sector: "Energy"},
+	}
+	stocksLock.Unlock()
+	compStart = time.Now().Add(-time.Hour)
+	compEnd = time.Now().Add(time.Hour)
+	tradingRules = &TradingRules{}
+
+	initDB()
+	seedCompetitions()
+}
+
+func signupForTest(t *testing.T, name string) int64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	signupHandler(rec, httptest.NewRequest(http.MethodPost, "/api/auth/signup", strings.NewReader(`{"username": "`+name+`"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("signup: %d %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		UserID int64 `json:"user_id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.UserID
+}
+
+// hammers one account with buys and sells at once, every trade has to see the cash and shares
+// the one before it left, so nothing is overspent or oversold and the ledger still adds up
+func TestConcurrentTradesOneAccount(t *testing.T) {
+	setupTradeTest(t)
+	userID := signupForTest(t, "stress")
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 400)
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stock := []string{"APEX", "NOVA"}[i%2]
+			action := "buy"
+			shares := 30
+			if i%3 == 0 {
+				action, shares = "sell", 20
+			}
+			body := fmt.Sprintf(`{"stock_id": "%s", "action": "%s", "shares": %d}`, stock, action, shares)
+			req := httptest.NewRequest(http.MethodPost, "/api/trade", strings.NewReader(body))
+			req.AddCookie(&http.Cookie{Name: "stocksim_user", Value: fmt.Sprint(userID)})
+			rec := httptest.NewRecorder()
+			tradeHandler(rec, req)
+			// running out of cash or shares is a 400, anything else is a bug
+			if rec.Code != http.StatusOK && rec.Code != http.StatusBadRequest {
+				errs <- fmt.Sprintf("%s %d %s: %d %s", action, shares, stock, rec.Code, rec.Body.String())
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for e := range errs {
+		t.Error(e)
+	}
+
+	var cash Money
+	if err := db.QueryRow("SELECT cash FROM users WHERE id = ?", userID).Scan(&cash); err != nil {
+		t.Fatal(err)
+	}
+	if cash < 0 {
+		t.Errorf("cash went negative: %s", cash)
+	}
+	rows, err := db.Query("SELECT stock_id, shares FROM portfolio WHERE user_id = ?", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var id string
+		var shares Qty
+		if err := rows.Scan(&id, &shares); err != nil {
+			t.Fatal(err)
+		}
+		if shares < 0 {
+			t.Errorf("%s oversold: %s shares", id, shares)
+		}
+	}
+	rows.Close()
+
+	var trades int
+	db.QueryRow("SELECT COUNT(*) FROM transactions WHERE user_id = ? AND action IN ('buy', 'sell')", userID).Scan(&trades)
+	if trades == 0 {
+		t.Error("no trade went through")
+	}
+
+	issues, err := reconcileAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range issues {
+		t.Errorf("reconcile: %+v", i)
+	}
+}
+
+// a count far past anything wholeShares or the premium maths can hold, sent to each endpoint that
+// takes one, has to come back as a 400 with the account untouched instead of wrapping into free cash
+func TestHugeCountsRejected(t *testing.T) {
+	setupTradeTest(t)
+	userID := signupForTest(t, "whale")
+
+	expires := compEnd.Add(-time.Minute)
+	if _, err := db.Exec("INSERT INTO option_contracts (symbol, stock_id, type, strike, multiplier, expires_at, status) VALUES (?, 'APEX', 'call', 100, 100, ?, 'open')",
+		optionSymbol("APEX", "call", 100, expires), toDBTime(expires)); err != nil {
+		t.Fatal(err)
+	}
+	res, err := db.Exec("INSERT INTO ipos (stock_id, name, sector, price, shares_offered, status, closes_at) VALUES ('ZETA', 'Zeta', 'Technology', 10, 1000, 'open', ?)", toDBTime(compEnd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ipoID, _ := res.LastInsertId()
+	if _, err := issueBond(BondRequest{ID: "TB1", TermSeconds: 1800}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	var before Money
+	db.QueryRow("SELECT cash FROM users WHERE id = ?", userID).Scan(&before)
+
+	const huge = "100000000000000000"
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+	}{
+		{"option buy", optionTradeHandler, `{"symbol": "` + optionSymbol("APEX", "call", 100, expires) + `", "action": "buy", "contracts": ` + huge + `}`},
+		{"option sell", optionTradeHandler, `{"symbol": "` + optionSymbol("APEX", "call", 100, expires) + `", "action": "sell", "contracts": ` + huge + `}`},
+		{"ipo subscribe", subscribeIPOHandler, fmt.Sprintf(`{"ipo_id": %d, "shares": %s}`, ipoID, huge)},
+		{"ipo over offer", subscribeIPOHandler, fmt.Sprintf(`{"ipo_id": %d, "shares": 1001}`, ipoID)},
+		{"bond buy", bondTradeHandler, `{"id": "TB1", "action": "buy", "quantity": ` + huge + `}`},
+		{"bond sell", bondTradeHandler, `{"id": "TB1", "action": "sell", "quantity": ` + huge + `}`},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(c.body))
+		req.AddCookie(&http.Cookie{Name: "stocksim_user", Value: fmt.Sprint(userID)})
+		rec := httptest.NewRecorder()
+		c.handler(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d %s, want 400", c.name, rec.Code, strings.TrimSpace(rec.Body.String()))
+		}
+	}
+
+	var after Money
+	db.QueryRow("SELECT cash FROM users WHERE id = ?", userID).Scan(&after)
+	if after != before {
+		t.Errorf("cash moved from %s to %s", before, after)
+	}
+	var rows int
+	db.QueryRow("SELECT (SELECT COUNT(*) FROM option_positions WHERE user_id = ?) + (SELECT COUNT(*) FROM ipo_subscriptions WHERE user_id = ?) + (SELECT COUNT(*) FROM bond_positions WHERE user_id = ?)", userID, userID, userID).Scan(&rows)
+	if rows != 0 {
+		t.Errorf("%d positions or subscriptions left behind", rows)
+	}
+}
@@ -23,7 +23,7 @@ func usersHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	rows, err := db.Query("SELECT u.id, u.school_code, u.cash, u.team_id, u.created_at, t.name FROM users u LEFT JOIN teams t ON u.team_id = t.id")
+	rows, err := db.Query("SELECT u.id, "+accountNameSQL+", u.cash, u.team_id, u.created_at, t.name FROM users u LEFT JOIN teams t ON u.team_id = t.id WHERE u.competition_id = ?", competitionIDFromRequest(r))
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
@@ -33,7 +33,7 @@ func usersHandler(w http.ResponseWriter, r *http.Request) {
 	type UserOut struct {
 		ID        int64   `json:"id"`
 		Username  string  `json:"username"`
-		Cash      float64 `json:"cash"`
+		Cash      Money   `json:"cash"`
 		TeamID    *int64  `json:"team_id,omitempty"`
 		TeamName  *string `json:"team_name,omitempty"`
 		CreatedAt string  `json:"created_at"`
@@ -98,12 +98,17 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "username required (1-64 chars)", http.StatusBadRequest)
 		return
 	}
+	// "@" is how competition accounts are named
+	if strings.Contains(req.Username, "@") {
+		http.Error(w, "username cant contain @", http.StatusBadRequest)
+		return
+	}
 
 	action := strings.ToLower(strings.TrimSpace(req.TeamAction))
 
 	var existingID int64
-	var existingCash float64
-	err := db.QueryRow("SELECT id, cash FROM users WHERE school_code = ?", req.Username).Scan(&existingID, &existingCash)
+	var existingCash Money
+	err := db.QueryRow("SELECT id, cash FROM users WHERE school_code = ? AND owner_id IS NULL", req.Username).Scan(&existingID, &existingCash)
 	if err == nil {
 		cookie := &http.Cookie{
 			Name:     "stocksim_user",
@@ -154,6 +159,14 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	// a new login starts in main, other competitions are joined through /api/competitions/enrol
+	mainComp, err := getCompetition(mainCompetition)
+	if err != nil {
+		tx.Rollback()
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
 	var teamIDResult sql.NullInt64
 
 	if action == "create" {
@@ -180,7 +193,7 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 
 		if teamIDResult.Valid {
 			var cnt int
-			if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE team_id = ?", teamIDResult.Int64).Scan(&cnt); err != nil {
+			if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE team_id = ? AND competition_id = ?", teamIDResult.Int64, mainComp.ID).Scan(&cnt); err != nil {
 				tx.Rollback()
 				http.Error(w, "db error", http.StatusInternalServerError)
 				return
@@ -209,7 +222,7 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		var cnt int
-		if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE team_id = ?", req.TeamID).Scan(&cnt); err != nil {
+		if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE team_id = ? AND competition_id = ?", req.TeamID, mainComp.ID).Scan(&cnt); err != nil {
 			tx.Rollback()
 			http.Error(w, "db error", http.StatusInternalServerError)
 			return
@@ -226,13 +239,13 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 
 	var res sql.Result
 	if teamIDResult.Valid {
-		res, err = tx.Exec("INSERT INTO users (school_code, cash, team_id) VALUES (?, ?, ?)", req.Username, 10000.0, teamIDResult.Int64)
+		res, err = tx.Exec("INSERT INTO users (school_code, cash, starting_cash, competition_id, team_id) VALUES (?, ?, ?, ?, ?)", req.Username, startingCash, startingCash, mainComp.ID, teamIDResult.Int64)
 	} else {
-		res, err = tx.Exec("INSERT INTO users (school_code, cash) VALUES (?, ?)", req.Username, 10000.0)
+		res, err = tx.Exec("INSERT INTO users (school_code, cash, starting_cash, competition_id) VALUES (?, ?, ?, ?)", req.Username, startingCash, startingCash, mainComp.ID)
 	}
 	if err != nil {
 		tx.Rollback()
-		if err2 := db.QueryRow("SELECT id, cash FROM users WHERE school_code = ?", req.Username).Scan(&existingID, &existingCash); err2 == nil {
+		if err2 := db.QueryRow("SELECT id, cash FROM users WHERE school_code = ? AND owner_id IS NULL", req.Username).Scan(&existingID, &existingCash); err2 == nil {
 			cookie := &http.Cookie{
 				Name:     "stocksim_user",
 				Value:    fmt.Sprintf("%d", existingID),
@@ -251,6 +264,11 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	newID, _ := res.LastInsertId()
+	if err := postJournal(tx, "opening", newID, 0, cashLegs("capital", userAccount(newID), startingCash)); err != nil {
+		tx.Rollback()
+		http.Error(w, "db error inserting user", http.StatusInternalServerError)
+		return
+	}
 
 	if err := tx.Commit(); err != nil {
 		tx.Rollback()
@@ -279,7 +297,7 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 	resp := map[string]interface{}{
 		"user_id":  newID,
 		"username": req.Username,
-		"cash":     10000.0,
+		"cash":     startingCash,
 		"message":  "ok",
 	}
 	if teamIDResult.Valid {
@@ -309,12 +327,27 @@ func meHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// cash and team are the account in the request's competition, user_id stays the login
+	comp, err := competitionFromRequest(r)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	accountID, err := accountFor(userID, comp)
+	if err == sql.ErrNoRows {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	} else if err != nil && err != errNotEnrolled {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+
 	var username string
-	var cash float64
+	var cash Money
 	var teamID sql.NullInt64
 	var teamName sql.NullString
 
-	err = db.QueryRow("SELECT school_code, cash, team_id FROM users WHERE id = ?", userID).Scan(&username, &cash, &teamID)
+	err = db.QueryRow("SELECT "+accountNameSQL+", u.cash, u.team_id FROM users u WHERE u.id = ?", userID).Scan(&username, &cash, &teamID)
 	if err == sql.ErrNoRows {
 		http.Error(w, "user not found", http.StatusNotFound)
 		return
@@ -322,14 +355,40 @@ func meHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
 	}
+	if accountID != 0 && accountID != userID {
+		if err := db.QueryRow("SELECT cash, team_id FROM users WHERE id = ?", accountID).Scan(&cash, &teamID); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+	}
 	if teamID.Valid {
 		_ = db.QueryRow("SELECT name FROM teams WHERE id = ?", teamID.Int64).Scan(&teamName)
 	}
 
+	enrolled := []string{}
+	rows, err := db.Query(`SELECT c.slug FROM users u JOIN competitions c ON c.id = u.competition_id
+		WHERE u.id = ? OR u.owner_id = ? ORDER BY c.start_at ASC`, userID, userID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err == nil {
+			enrolled = append(enrolled, slug)
+		}
+	}
+
 	resp := map[string]interface{}{
-		"user_id":  userID,
-		"username": username,
-		"cash":     cash,
+		"user_id":     userID,
+		"username":    username,
+		"cash":        cash,
+		"competition": comp.Slug,
+		"enrolled":    enrolled,
+	}
+	if accountID != 0 {
+		resp["account_id"] = accountID
 	}
 	if teamID.Valid {
 		resp["team_id"] = teamID.Int64
@@ -3,6 +3,9 @@
 	const API_NEWS = '/api/news'
 	const ADMIN_PUBLISH_NEWS = '/api/admin/publish-news'
 	const ADMIN_STOCK_ACTION = '/api/admin/stock-action'
+	const ADMIN_EVENTS = '/api/admin/events'
+	const ADMIN_EVENTS_CANCEL = '/api/admin/events/cancel'
+	const ADMIN_SCENARIOS = '/api/admin/scenarios'
 	const ADMIN_COMPETITION_UPDATE = '/api/teams'
 	let adminSecret = null
 	let stocks = []
@@ -37,6 +40,16 @@
 	const capacityBtn = document.getElementById('comp-update')
 
 	const stocksTableBody = document.querySelector('#stocks-table tbody')
+	const eventsTableBody = document.querySelector('#events-table tbody')
+	const eventsStatus = document.getElementById('events-status')
+	const scenarioSelect = document.getElementById('scenario-select')
+	const scenarioJson = document.getElementById('scenario-json')
+	const scenarioUpload = document.getElementById('scenario-upload')
+	const scenarioPreviewBtn = document.getElementById('scenario-preview')
+	const scenarioRunBtn = document.getElementById('scenario-run')
+	const scenarioPreviewOut = document.getElementById('scenario-preview-out')
+	const scenarioRunsBody = document.querySelector('#scenario-runs-table tbody')
+	const scenarioStatus = document.getElementById('scenario-status')
 	const newsListDiv = document.getElementById('news-list')
 	const logoutBtn = document.getElementById('logout-admin')
 
@@ -359,6 +372,174 @@
 			setStatus(actionStatus, `Network error: ${err && err.message ? err.message : String(err)}`, true)
 		}
 	}
+	// price moves the server is running right now (news, tank/spike)
+	async function fetchEvents() {
+		if (!eventsTableBody || !adminSecret) return
+		let events = []
+		try {
+			const res = await fetch(ADMIN_EVENTS, { credentials: 'same-origin', headers: { 'X-Admin-Secret': adminSecret } })
+			if (!res.ok) return
+			events = await res.json()
+		} catch (e) { return }
+		eventsTableBody.innerHTML = ''
+		if (!Array.isArray(events) || events.length === 0) {
+			const tr = document.createElement('tr')
+			const td = document.createElement('td'); td.colSpan = 7; td.className = 'text-center'; td.textContent = 'No running events'
+			tr.appendChild(td); eventsTableBody.appendChild(tr)
+			return
+		}
+		for (const ev of events) {
+			const tr = document.createElement('tr')
+			const cells = [
+				ev.id,
+				ev.kind,
+				ev.label || '',
+				(ev.stocks || []).join(', '),
+				(ev.impact >= 0 ? '+' : '') + toFixedSafe(ev.impact * 100) + '%',
+				Math.round((ev.progress || 0) * 100) + '%'
+			]
+			for (const c of cells) {
+				const td = document.createElement('td'); td.textContent = String(c); tr.appendChild(td)
+			}
+			const actions = document.createElement('td')
+			const stopBtn = document.createElement('button'); stopBtn.className = 'btn'; stopBtn.textContent = 'Stop'
+			stopBtn.addEventListener('click', (e) => { e.preventDefault(); cancelEvent(ev.id, false) })
+			const revertBtn = document.createElement('button'); revertBtn.className = 'btn'; revertBtn.textContent = 'Stop & revert'
+			revertBtn.addEventListener('click', (e) => { e.preventDefault(); cancelEvent(ev.id, true) })
+			actions.appendChild(stopBtn); actions.appendChild(revertBtn)
+			tr.appendChild(actions)
+			eventsTableBody.appendChild(tr)
+		}
+	}
+
+	async function cancelEvent(id, revert) {
+		if (!adminSecret) { setStatus(eventsStatus, 'Admin secret required.', true); return }
+		try {
+			const res = await fetch(ADMIN_EVENTS_CANCEL, {
+				method: 'POST',
+				credentials: 'same-origin',
+				headers: {
+					'Content-Type': 'application/json',
+					'X-Admin-Secret': adminSecret
+				},
+				body: JSON.stringify({ id: id, revert: revert })
+			})
+			if (!res.ok) {
+				const txt = await res.text().catch(()=>'')
+				setStatus(eventsStatus, txt || `Cancel failed (${res.status})`, true)
+				return
+			}
+			setStatus(eventsStatus, revert ? `Event #${id} stopped and reverting` : `Event #${id} stopped`, false)
+			await fetchEvents()
+		} catch (err) {
+			setStatus(eventsStatus, `Network error: ${err && err.message ? err.message : String(err)}`, true)
+		}
+	}
+
+	async function adminPost(url, payload) {
+		const res = await fetch(url, {
+			method: 'POST',
+			credentials: 'same-origin',
+			headers: {
+				'Content-Type': 'application/json',
+				'X-Admin-Secret': adminSecret
+			},
+			body: JSON.stringify(payload)
+		})
+		if (!res.ok) {
+			const txt = await res.text().catch(()=>'')
+			throw new Error(txt || `Request failed (${res.status})`)
+		}
+		return res.json()
+	}
+
+	async function fetchScenarios() {
+		if (!scenarioSelect || !adminSecret) return
+		let list = []
+		try {
+			const res = await fetch(ADMIN_SCENARIOS, { credentials: 'same-origin', headers: { 'X-Admin-Secret': adminSecret } })
+			if (res.ok) list = await res.json()
+		} catch (e) {}
+		const prev = scenarioSelect.value
+		scenarioSelect.innerHTML = ''
+		const blank = document.createElement('option'); blank.value = ''; blank.textContent = '(use the JSON below)'; scenarioSelect.appendChild(blank)
+		for (const sc of (Array.isArray(list) ? list : [])) {
+			const o = document.createElement('option'); o.value = sc.id; o.textContent = sc.name + (sc.description ? ' — ' + sc.description : ''); scenarioSelect.appendChild(o)
+		}
+		scenarioSelect.value = prev
+	}
+
+	// selected stored scenario, or whatever is in the textarea
+	function scenarioPayload() {
+		const id = Number(scenarioSelect ? scenarioSelect.value : 0) || 0
+		if (id) return { id: id }
+		const txt = (scenarioJson ? scenarioJson.value : '').trim()
+		if (!txt) throw new Error('Pick a scenario or paste one')
+		return { scenario: JSON.parse(txt) }
+	}
+
+	async function scenarioAction(kind) {
+		if (!adminSecret) { setStatus(scenarioStatus, 'Admin secret required.', true); return }
+		try {
+			if (kind === 'upload') {
+				const sc = JSON.parse((scenarioJson ? scenarioJson.value : '').trim() || '{}')
+				const saved = await adminPost(ADMIN_SCENARIOS, sc)
+				setStatus(scenarioStatus, `Saved "${saved.name}"`, false)
+				await fetchScenarios()
+				if (scenarioSelect) scenarioSelect.value = saved.id
+				return
+			}
+			if (kind === 'preview') {
+				const p = await adminPost(ADMIN_SCENARIOS + '/preview', scenarioPayload())
+				const lines = [`${p.name}: ${Math.round(p.duration_seconds)}s total`]
+				for (const id of Object.keys(p.summary || {}).sort()) {
+					const s = p.summary[id]
+					lines.push(`${id}: $${toFixedSafe(s.from)} → $${toFixedSafe(s.to)} (${s.change_pct >= 0 ? '+' : ''}${toFixedSafe(s.change_pct)}%, low $${toFixedSafe(s.low)}, high $${toFixedSafe(s.high)})`)
+				}
+				if (scenarioPreviewOut) scenarioPreviewOut.textContent = lines.join('\n')
+				return
+			}
+			const run = await adminPost(ADMIN_SCENARIOS + '/run', scenarioPayload())
+			setStatus(scenarioStatus, `Started run #${run.id}`, false)
+			await fetchScenarioRuns()
+		} catch (err) {
+			setStatus(scenarioStatus, err && err.message ? err.message : String(err), true)
+		}
+	}
+
+	async function fetchScenarioRuns() {
+		if (!scenarioRunsBody || !adminSecret) return
+		let runs = []
+		try {
+			const res = await fetch(ADMIN_SCENARIOS + '/runs', { credentials: 'same-origin', headers: { 'X-Admin-Secret': adminSecret } })
+			if (res.ok) runs = await res.json()
+		} catch (e) { return }
+		scenarioRunsBody.innerHTML = ''
+		for (const run of (Array.isArray(runs) ? runs : [])) {
+			const tr = document.createElement('tr')
+			for (const c of ['#' + run.id, run.name, run.status, `${run.remaining}/${run.legs}`]) {
+				const td = document.createElement('td'); td.textContent = String(c); tr.appendChild(td)
+			}
+			const actions = document.createElement('td')
+			const controls = run.status === 'running' ? ['pause', 'cancel'] : run.status === 'paused' ? ['resume', 'cancel'] : []
+			for (const action of controls) {
+				const b = document.createElement('button'); b.className = 'btn'; b.textContent = action
+				b.addEventListener('click', async (e) => {
+					e.preventDefault()
+					try {
+						await adminPost(`${ADMIN_SCENARIOS}/${action}`, { run_id: run.id })
+						await fetchScenarioRuns()
+					} catch (err) {
+						setStatus(scenarioStatus, err && err.message ? err.message : String(err), true)
+					}
+				})
+				actions.appendChild(b)
+			}
+			tr.appendChild(actions)
+			scenarioRunsBody.appendChild(tr)
+		}
+	}
+
 	async function updateCompetitionCapacity() {
 		if (!adminSecret) { setStatus(competitionStatus, 'Admin secret required.', true); return }
 		const capacity = (maxParticipantsInput ? (maxParticipantsInput.value || '').trim() : '')
@@ -405,6 +586,9 @@
 	if (publishBtn) publishBtn.addEventListener('click', (ev) => { ev.preventDefault(); publishNews(false) })
 	if (actionPreview) actionPreview.addEventListener('click', (ev) => { ev.preventDefault(); runStockAction(true) })
 	if (actionRun) actionRun.addEventListener('click', (ev) => { ev.preventDefault(); runStockAction(false) })
+	if (scenarioUpload) scenarioUpload.addEventListener('click', (ev) => { ev.preventDefault(); scenarioAction('upload') })
+	if (scenarioPreviewBtn) scenarioPreviewBtn.addEventListener('click', (ev) => { ev.preventDefault(); scenarioAction('preview') })
+	if (scenarioRunBtn) scenarioRunBtn.addEventListener('click', (ev) => { ev.preventDefault(); scenarioAction('run') })
 	if (capacityBtn) capacityBtn.addEventListener('click', (ev) => { ev.preventDefault(); updateCompetitionCapacity() })
 
 	if (secretSubmit) {
@@ -437,8 +621,13 @@
 		await loadSources()
 		await fetchStocks()
 		await fetchNewsList()
+		await fetchEvents()
+		await fetchScenarios()
+		await fetchScenarioRuns()
 	}
 
+	setInterval(() => { fetchEvents(); fetchScenarioRuns() }, 3000)
+
 	(async function boot() {
 		await loadSources()
 		let stored = null
@@ -1,7 +1,10 @@
 (function () {
 	const API_STOCKS = '/api/stocks'
 	const API_HISTORY = '/api/history'
+	const API_NEWS_TIMELINE = '/api/news/timeline'
 	const WS_URL = (location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + '/ws/prices'
+	const SSE_URL = '/api/stream'
+	const WS_FAILS_BEFORE_SSE = 2
 
 	function $ (sel) { return document.querySelector(sel) }
 	function byId (id) { return document.getElementById(id) }
@@ -16,6 +19,8 @@
 	const netDollarEl = byId('net-dollar-change')
 	const netPctEl = byId('net-percent-change')
 	const chartWrapper = byId('stock-history')
+	const stockNewsEl = byId('stock-news')
+	const haltedBadgeEl = byId('halted-badge')
 	const searchInput = $('.search-stocks input')
 
 	const ocOpenEl = byId('oclh-open')
@@ -29,6 +34,8 @@
 
 	let ws = null
 	let wsReconnectTimer = null
+	let wsFailures = 0
+	let sse = null
 	let lastHistoryClose = null
 	let lastChartPointTime = null
 	let selectedStockFullName = null
@@ -142,6 +149,7 @@
 		}
 		if (stockSymbolEl) stockSymbolEl.textContent = `(${id})`
 		if (stockPriceEl) stockPriceEl.textContent = fmtMoney(priceNum)
+		if (haltedBadgeEl) haltedBadgeEl.hidden = !stockObj.halted
 
 		const sign = changeAbs > 0 ? '+' : (changeAbs < 0 ? '-' : '')
 		const absFmt = fmtMoney(Math.abs(changeAbs)).replace('$-', '$')
@@ -230,7 +238,28 @@
 		}
 	}
 
+	// market indices plus the selected stock's sector, ids match backend/indices.go
+	function renderIndices(list) {
+		const strip = document.getElementById('index-strip')
+		if (!strip || !Array.isArray(list)) return
+		const sector = ((document.getElementById('company-sector') || {}).textContent || '').toUpperCase().replace(/[^A-Z0-9]/g, '')
+		const wanted = ['^MARKET', '^MARKET-EW']
+		if (sector && sector !== 'NA' && sector !== 'ETF') wanted.push('^' + sector)
+		strip.innerHTML = ''
+		for (const id of wanted) {
+			const idx = list.find(x => x.id === id)
+			if (!idx) continue
+			const pct = Number(idx.change_pct || 0)
+			const span = document.createElement('span')
+			span.className = 'index-item ' + (pct >= 0 ? 'pos' : 'neg')
+			span.title = idx.name || id
+			span.textContent = `${id} ${Number(idx.value || 0).toFixed(2)} (${pct >= 0 ? '+' : ''}${pct.toFixed(2)}%)`
+			strip.appendChild(span)
+		}
+	}
+
 	function handleWSMessageForSelected(msg) {
+		if (msg && Array.isArray(msg.indices)) renderIndices(msg.indices)
 		if (!msg || !Array.isArray(msg.stocks)) return
 		const msgTime = msg.time || null
 		const sel = (localStorage.getItem('stocksim_selected') || '').toString().trim().toUpperCase()
@@ -252,7 +281,10 @@
 			if (!wsReconnectTimer) wsReconnectTimer = setTimeout(() => connectPricesWS(onStockUpdate), 1000)
 			return
 		}
+		let opened = false
 		ws.addEventListener('open', () => {
+			opened = true
+			wsFailures = 0
 			if (wsReconnectTimer) { clearTimeout(wsReconnectTimer); wsReconnectTimer = null }
 		})
 		ws.addEventListener('message', (ev) => {
@@ -263,6 +295,12 @@
 			} catch (err) {}
 		})
 		ws.addEventListener('close', () => {
+			// some school networks block the upgrade entirely, fall back to server-sent events
+			if (!opened) wsFailures++
+			if (wsFailures >= WS_FAILS_BEFORE_SSE && typeof EventSource !== 'undefined') {
+				connectPricesSSE(onStockUpdate)
+				return
+			}
 			if (!wsReconnectTimer) wsReconnectTimer = setTimeout(() => connectPricesWS(onStockUpdate), 1000)
 		})
 		ws.addEventListener('error', (e) => {
@@ -270,6 +308,19 @@
 		})
 	}
 
+	function connectPricesSSE(onStockUpdate) {
+		if (sse) return
+		// EventSource reconnects on its own and resumes with Last-Event-ID
+		sse = new EventSource(SSE_URL)
+		sse.addEventListener('prices', (ev) => {
+			try {
+				const msg = JSON.parse(ev.data)
+				if (!msg || !Array.isArray(msg.stocks)) return
+				if (typeof onStockUpdate === 'function') onStockUpdate(msg)
+			} catch (err) {}
+		})
+	}
+
 	async function loadHistoryAndPlot(symbol, points) {
 		createChartee()
 		if (!areaSeries) return
@@ -362,6 +413,49 @@
 		}
 	}
 
+	// news that moved this stock, newest on top, shown under the chart
+	async function loadNewsTimeline(symbol) {
+		if (!stockNewsEl) return
+		stockNewsEl.innerHTML = ''
+		let data = null
+		try {
+			const res = await fetch(`${API_NEWS_TIMELINE}?stock=${encodeURIComponent(symbol)}&limit=20`)
+			if (res.ok) data = await res.json()
+		} catch (e) {}
+		if (!data || !Array.isArray(data.items) || data.items.length === 0) return
+
+		const title = document.createElement('h3')
+		title.textContent = 'News'
+		stockNewsEl.appendChild(title)
+
+		const list = document.createElement('ul')
+		list.className = 'stock-news-list'
+		for (const it of data.items.slice().reverse()) {
+			const n = it.news || {}
+			const li = document.createElement('li')
+			if (n.status === 'retracted') li.classList.add('retracted')
+
+			const head = document.createElement('div')
+			head.className = 'stock-news-title'
+			head.textContent = n.title || ''
+
+			const meta = document.createElement('div')
+			meta.className = 'stock-news-meta'
+			const when = new Date(it.time).toLocaleString()
+			const parts = [when]
+			if (n.source) parts.push(n.source)
+			if (n.affected_sector && !n.affected_stock) parts.push(n.affected_sector + ' sector')
+			if (it.price_at !== undefined) parts.push('at ' + fmtMoney(it.price_at))
+			if (it.change_since !== undefined) parts.push((it.change_since >= 0 ? '+' : '') + Number(it.change_since).toFixed(2) + '% since')
+			meta.textContent = parts.join(' · ')
+
+			li.appendChild(head)
+			li.appendChild(meta)
+			list.appendChild(li)
+		}
+		stockNewsEl.appendChild(list)
+	}
+
 	function fillHeaderFromStock(s) {
 		if (!s) { showNoSelection(); return }
 
@@ -376,6 +470,7 @@
 		if (companySectorEl) companySectorEl.textContent = s.sector || 'N/A'
 		if (stockSymbolEl) stockSymbolEl.textContent = `(${id})`
 		if (stockPriceEl) stockPriceEl.textContent = fmtMoney(priceNum)
+		if (haltedBadgeEl) haltedBadgeEl.hidden = !s.halted
 		if (marketPriceEl) marketPriceEl.textContent = fmtMoney(priceNum)
 		if (marketPriceEl2) marketPriceEl2.textContent = fmtMoney(priceNum)
 
@@ -404,6 +499,7 @@
 		preserveSelectedStockName(stock)
 		fillHeaderFromStock(stock)
 		await loadHistoryAndPlot(stock.id)
+		loadNewsTimeline(stock.id)
 		connectPricesWS(handleWSMessageForSelected)
 	}
 
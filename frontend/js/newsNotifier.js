@@ -3,6 +3,7 @@
   const VIEW_URL = window.NEWS_NOTIFIER_VIEW_URL || '/news';
   const STORAGE_KEY = 'newsnotifier:lastSeenId';
   const API_URL = '/api/news?limit=1';
+  const STREAM_URL = '/api/stream?events=news';
 
   function ensureContainer() {
     if (document.getElementById('news-notifier-style')) return;
@@ -46,20 +47,21 @@
     }
   }
 
-  function showToast(title, onView) {
+  function showToast(title, onView, urgency) {
     ensureContainer();
     const root = document.getElementById('news-notifier-root');
     if (!root) return;
 
     const toast = document.createElement('div');
     toast.className = 'news-notifier-toast';
+    if (urgency === 'breaking') toast.classList.add('breaking');
 
     const left = document.createElement('div');
     left.className = 'news-notifier-left';
 
     const t = document.createElement('div');
     t.className = 'news-notifier-title';
-    t.textContent = 'News updated';
+    t.textContent = urgency === 'breaking' ? 'Breaking news' : 'News updated';
 
     const sub = document.createElement('div');
     sub.className = 'news-notifier-sub';
@@ -102,7 +104,7 @@
       });
     });
 
-    const autoHide = setTimeout(() => hideToast(toast), 6000);
+    const autoHide = setTimeout(() => hideToast(toast), urgency === 'breaking' ? 12000 : 6000);
 
     function hideToast(el) {
       clearTimeout(autoHide);
@@ -134,22 +136,45 @@
     if (!lastSeen || latestId !== lastSeen) {
       lastSeen = latestId;
       saveId(latestId);
-      showToast(latest.title || 'News updated', function () {
-        try {
-          window.location.href = VIEW_URL;
-        } catch (e) {
-        }
-      });
+      showToast(latest.title || 'News updated', openView, latest.raw && latest.raw.urgency);
     }
   }
 
-  if (document.readyState === 'loading') {
-    document.addEventListener('DOMContentLoaded', () => {
-      pollOnce();
-      setInterval(pollOnce, POLL_INTERVAL_MS);
+  function openView() {
+    try {
+      window.location.href = VIEW_URL;
+    } catch (e) {
+    }
+  }
+
+  // news is pushed the moment it's published, polling stays around in case the stream drops
+  function connectStream() {
+    if (typeof EventSource === 'undefined') return;
+    const es = new EventSource(STREAM_URL);
+    es.addEventListener('news', function (ev) {
+      try {
+        const msg = JSON.parse(ev.data);
+        const n = msg && msg.news;
+        if (!n || n.id === undefined) return;
+        const id = String(n.id);
+        if (id === lastSeen) return;
+        lastSeen = id;
+        saveId(id);
+        initialRun = false;
+        showToast(n.title || 'News updated', openView, msg.urgency);
+      } catch (e) {}
     });
-  } else {
+  }
+
+  function start() {
     pollOnce();
+    connectStream();
     setInterval(pollOnce, POLL_INTERVAL_MS);
   }
+
+  if (document.readyState === 'loading') {
+    document.addEventListener('DOMContentLoaded', start);
+  } else {
+    start();
+  }
 })();
@@ -2,6 +2,7 @@
   const API_PORTFOLIO = '/api/portfolio';
   const API_TRANSACTIONS = '/api/transactions?limit=50';
   const WS_PRICES = (location.protocol === 'https:' ? 'wss:' : 'ws:') + '//' + location.host + '/ws/prices';
+  const SSE_PRICES = '/api/stream';
 
   const currentCashEl = document.getElementById('current-cash');
   const currentNetworthEl = document.getElementById('current-networth');
@@ -21,6 +22,8 @@
   let stocksIndex = {};
   let socket = null;
   let reconnectTimer = null;
+  let socketFailures = 0;
+  let eventSource = null;
 
   function fmtMoney(n) { return '$' + Number(n || 0).toFixed(2); }
   function fmtPct(n) { const sign = n > 0 ? '+' : (n < 0 ? '-' : ''); return sign + Math.abs(Number(n || 0)).toFixed(2) + '%'; }
@@ -257,6 +260,31 @@
     }
   }
 
+  function applyPriceMessage(parsed) {
+    let stocksArr = null;
+    if (Array.isArray(parsed)) stocksArr = parsed;
+    else if (parsed.stocks && Array.isArray(parsed.stocks)) stocksArr = parsed.stocks;
+    else if (parsed.data && parsed.data.stocks && Array.isArray(parsed.data.stocks)) stocksArr = parsed.data.stocks;
+
+    if (!stocksArr) {
+      return;
+    }
+
+    for (let s of stocksArr) {
+      const id = s.ID || s.id || s.stock_id || s.symbol || s.Stock || s.stock;
+      const price = s.Price || s.price || s.close || s.p || s.price_now;
+      const change = s.Change || s.change || s.delta || 0;
+      const key = normalizeKey(id);
+      if (!key) continue;
+      if (price !== undefined) {
+        stocksIndex[key] = Number(price);
+      }
+      stocksIndex['__change__' + key] = Number(change);
+    }
+
+    updateUIFromState();
+  }
+
   function initWebSocket() {
     if (socket && socket.readyState === WebSocket.OPEN) {
       return;
@@ -269,42 +297,23 @@
       return;
     }
 
+    let opened = false;
     socket.addEventListener('open', () => {
+      opened = true;
+      socketFailures = 0;
       if (reconnectTimer) { clearTimeout(reconnectTimer); reconnectTimer = null; }
     });
 
     socket.addEventListener('message', (ev) => {
       try {
-        const parsed = JSON.parse(ev.data);
-
-        let stocksArr = null;
-        if (Array.isArray(parsed)) stocksArr = parsed;
-        else if (parsed.stocks && Array.isArray(parsed.stocks)) stocksArr = parsed.stocks;
-        else if (parsed.data && parsed.data.stocks && Array.isArray(parsed.data.stocks)) stocksArr = parsed.data.stocks;
-
-        if (!stocksArr) {
-          return;
-        }
-
-        for (let s of stocksArr) {
-          const id = s.ID || s.id || s.stock_id || s.symbol || s.Stock || s.stock;
-          const price = s.Price || s.price || s.close || s.p || s.price_now;
-          const change = s.Change || s.change || s.delta || 0;
-          const key = normalizeKey(id);
-          if (!key) continue;
-          if (price !== undefined) {
-            stocksIndex[key] = Number(price);
-          }
-          stocksIndex['__change__' + key] = Number(change);
-        }
-
-        updateUIFromState();
+        applyPriceMessage(JSON.parse(ev.data));
       } catch (err) {
         console.error('[portfolio] websocket message parse error', err);
       }
     });
 
     socket.addEventListener('close', () => {
+      if (!opened) socketFailures++;
       scheduleReconnect();
     });
 
@@ -315,8 +324,25 @@
     });
   }
 
+  // websocket upgrades are blocked on some networks, /api/stream carries the same payloads
+  function initEventSource() {
+    if (eventSource) return;
+    eventSource = new EventSource(SSE_PRICES);
+    eventSource.addEventListener('prices', (ev) => {
+      try {
+        applyPriceMessage(JSON.parse(ev.data));
+      } catch (err) {
+        console.error('[portfolio] sse message parse error', err);
+      }
+    });
+  }
+
   function scheduleReconnect() {
-    if (reconnectTimer) return;
+    if (reconnectTimer || eventSource) return;
+    if (socketFailures >= 2 && typeof EventSource !== 'undefined') {
+      initEventSource();
+      return;
+    }
     reconnectTimer = setTimeout(() => {
       reconnectTimer = null;
       initWebSocket();
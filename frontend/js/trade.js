@@ -191,12 +191,13 @@
 				}
 
 				const sharesVal = Number(sharesInput.value);
-				if (!Number.isFinite(sharesVal) || sharesVal <= 0 || Math.floor(sharesVal) !== sharesVal) {
-					showFormMessage(form, 'Enter a valid whole number of shares (> 0).', 'error');
+				// fractional shares are allowed, the server checks them against share_decimals
+				if (!Number.isFinite(sharesVal) || sharesVal <= 0) {
+					showFormMessage(form, 'Enter a valid number of shares (> 0).', 'error');
 					sharesInput.focus();
 					return;
 				}
-				const shares = Math.floor(sharesVal);
+				const shares = sharesVal;
 
 				const symbol = getSelectedSymbol();
 				if (!symbol) {
@@ -221,6 +222,11 @@
 					action: action,
 					shares: shares
 				};
+				// the server refuses the trade if the price has moved too far from what is on screen
+				const seenPrice = parsePriceFromMarket();
+				if (seenPrice > 0) payload.expected_price = seenPrice;
+				// one key per click, a retried request with it wont trade twice
+				const idempotencyKey = (window.crypto && crypto.randomUUID) ? crypto.randomUUID() : `${Date.now()}-${Math.random().toString(36).slice(2)}`;
 
 				confirmBtn.disabled = true;
 				const origText = confirmBtn.textContent;
@@ -231,7 +237,7 @@
 					const res = await fetch('/api/trade', {
 						method: 'POST',
 						credentials: 'same-origin',
-						headers: { 'Content-Type': 'application/json' },
+						headers: { 'Content-Type': 'application/json', 'Idempotency-Key': idempotencyKey },
 						body: JSON.stringify(payload)
 					});
 